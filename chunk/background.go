@@ -0,0 +1,244 @@
+// Package chunk provides the core data structures for the markdown runner. It
+// defines the ExecutableChunk, which represents a runnable code block, and the
+// RunningCommand, which represents a single command to be executed.
+package chunk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/shlex"
+)
+
+const (
+	// defaultReadyTimeout is the time a background chunk has to become ready
+	defaultReadyTimeout = time.Minute
+	// defaultReadyInterval is the time between two checks of a readiness probe
+	defaultReadyInterval = 500 * time.Millisecond
+)
+
+// ReadinessProbe describes how to know that a background chunk is ready for the
+// next chunks to interact with it. Exactly one of Tcp, File, Command or Log must
+// be set.
+type ReadinessProbe struct {
+	// Tcp is an address, such as "localhost:8080", that must accept connections.
+	Tcp string `json:"tcp,omitempty"`
+	// File is a path, relative to the directory of the chunk, that must exist.
+	File string `json:"file,omitempty"`
+	// Command is a command that must succeed, run in the directory of the chunk.
+	Command string `json:"command,omitempty"`
+	// Log is a regex that must match the output of the background process.
+	Log string `json:"log,omitempty"`
+	// Timeout is the Go duration after which the chunk is considered as failed
+	// if it isn't ready yet, one minute by default.
+	Timeout string `json:"timeout,omitempty"`
+	// Interval is the Go duration between two checks, 500ms by default.
+	Interval string `json:"interval,omitempty"`
+	// the parsed versions of the fields above
+	timeout  time.Duration
+	interval time.Duration
+	log      *regexp.Regexp
+}
+
+// parse validates the probe and parses its durations and regex.
+func (probe *ReadinessProbe) parse() error {
+	var err error
+	probe.timeout = defaultReadyTimeout
+	if probe.Timeout != "" {
+		probe.timeout, err = time.ParseDuration(probe.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid ready timeout: %w", err)
+		}
+	}
+	probe.interval = defaultReadyInterval
+	if probe.Interval != "" {
+		probe.interval, err = time.ParseDuration(probe.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid ready interval: %w", err)
+		}
+	}
+	if probe.Log != "" {
+		probe.log, err = regexp.Compile(probe.Log)
+		if err != nil {
+			return fmt.Errorf("invalid ready log regex '%s': %w", probe.Log, err)
+		}
+	}
+	return nil
+}
+
+// check runs the probe once against the running command. It returns nil if the
+// command is ready.
+func (probe *ReadinessProbe) check(ctx context.Context, command *RunningCommand) error {
+	switch {
+	case probe.Tcp != "":
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", probe.Tcp)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case probe.File != "":
+		file := probe.File
+		if !path.IsAbs(file) {
+			file = path.Join(command.Cmd.Dir, file)
+		}
+		_, err := os.Stat(file)
+		return err
+	case probe.Command != "":
+		args, err := shlex.Split(probe.Command)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return errors.New("empty ready command")
+		}
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Dir = command.Cmd.Dir
+		cmd.Env = command.Cmd.Env
+		return cmd.Run()
+	case probe.log != nil:
+		if !probe.log.MatchString(command.currentOutput()) {
+			return fmt.Errorf("the output doesn't match '%s' yet", probe.Log)
+		}
+		return nil
+	}
+	return nil
+}
+
+// lockedWriter serializes the writes of a background process with the reads of
+// its readiness probe.
+type lockedWriter struct {
+	mutex  *sync.Mutex
+	writer io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.writer.Write(p)
+}
+
+// currentOutput returns what a running background command has printed so far.
+func (command *RunningCommand) currentOutput() string {
+	command.outputMutex.Lock()
+	defer command.outputMutex.Unlock()
+	return command.Outb.String() + command.Errb.String()
+}
+
+// watchBackground waits for the background process in a goroutine, closing the
+// exited channel once it's over.
+func (command *RunningCommand) watchBackground() {
+	command.exited = make(chan struct{})
+	go func() {
+		command.exitError = waitGroup(command.Cmd)
+		close(command.exited)
+	}()
+}
+
+// hasExited reports whether the process of the command is over.
+func (command *RunningCommand) hasExited() bool {
+	if !command.IsBackground {
+		return command.status() != nil
+	}
+	select {
+	case <-command.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// terminate asks the background process and its descendants to exit, killing
+// them if they're still running after the grace period, and waits for the
+// process to be over. It does nothing if the process already exited.
+func (command *RunningCommand) terminate() {
+	select {
+	case <-command.exited:
+		return
+	default:
+	}
+	command.Terminated = true
+	command.stop()
+	<-command.exited
+}
+
+// StartBackground starts the command of a background chunk and waits for its
+// readiness probe, if any, to succeed. The process keeps running until
+// StopBackground gets called. It returns an error if the process could not be
+// started, or if it exited or wasn't ready before the probe timeout.
+func (chunk *ExecutableChunk) StartBackground() error {
+	if !chunk.IsBackground {
+		return errors.New("Cannot start a non-background chunk with StartBackground, use Execute instead")
+	}
+	command := chunk.Commands[0]
+	err := command.InitializeLogger()
+	if err != nil {
+		return err
+	}
+	err = command.Start()
+	if err != nil {
+		return err
+	}
+	// the command didn't really start, let Wait give the feedback
+	if command.Ctx.Cfg.DryRun || command.Cmd == nil {
+		return command.Wait()
+	}
+	chunk.ReadyError = chunk.waitUntilReady(command)
+	if chunk.ReadyError != nil {
+		command.Ctx.RView.StopCommand(command.id, false, chunk.ReadyError.Error())
+		return chunk.ReadyError
+	}
+	command.Ctx.RView.StopCommand(command.id, true, command.CmdPrettyName+" is running in the background")
+	return nil
+}
+
+// waitUntilReady checks the readiness probe of the chunk until it succeeds, the
+// process exits or the probe times out.
+func (chunk *ExecutableChunk) waitUntilReady(command *RunningCommand) error {
+	if chunk.Ready == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(command.deadline, chunk.Ready.timeout)
+	defer cancel()
+	for {
+		err := chunk.Ready.check(ctx, command)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-command.exited:
+			return fmt.Errorf("%s exited before being ready", command.CmdPrettyName)
+		case <-ctx.Done():
+			return fmt.Errorf("%s was not ready after %s: %w", command.CmdPrettyName, chunk.Ready.timeout, err)
+		case <-time.After(chunk.Ready.interval):
+		}
+	}
+}
+
+// StopBackground terminates the process of a background chunk if it's still
+// running and collects its output. It does nothing if the process was never
+// started or was already stopped. It returns an error if the process exited by
+// itself with a failure.
+func (chunk *ExecutableChunk) StopBackground() error {
+	if !chunk.IsBackground || len(chunk.Commands) == 0 {
+		return nil
+	}
+	command := chunk.Commands[0]
+	if command.exited == nil || command.stopped {
+		return nil
+	}
+	command.stopped = true
+	command.Ctx.RView.DescribeCommand(command.id, command.details)
+	command.Ctx.RView.StartCommand(command.id, "stopping "+command.CmdPrettyName)
+	command.terminate()
+	return command.Wait()
+}
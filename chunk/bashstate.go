@@ -0,0 +1,73 @@
+// Package chunk provides the core data structures for the markdown runner. It
+// defines the ExecutableChunk, which represents a runnable code block, and the
+// RunningCommand, which represents a single command to be executed.
+package chunk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+const (
+	// envStateSuffix is appended to the state path of a bash script to name
+	// the file holding its environment, as NUL-separated KEY=VALUE entries
+	envStateSuffix = ".env"
+	// shellStateSuffix is appended to the state path of a bash script to name
+	// the file holding the definitions of its functions and aliases
+	shellStateSuffix = ".shell"
+)
+
+// bashStatePath returns the prefix of the state files of a bash script.
+func bashStatePath(scriptPath string) string {
+	return strings.TrimSuffix(scriptPath, ".sh")
+}
+
+// shellQuote quotes a string for bash, so that it's taken literally.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// restoreBashState reads the state a bash script dumped once done, making its
+// environment, functions and aliases the ones of the next chunks. The state
+// files are removed once read.
+//
+// Nothing is restored when the script didn't get to the end: a background
+// script is still running when the next chunks start, and a script expected
+// to fail stopped before dumping its state.
+func (command *RunningCommand) restoreBashState() error {
+	if command.statePath == "" {
+		return nil
+	}
+	envPath := command.statePath + envStateSuffix
+	shellPath := command.statePath + shellStateSuffix
+	defer os.Remove(envPath)
+	defer os.Remove(shellPath)
+	if command.IsBackground {
+		return nil
+	}
+	envDump, err := os.ReadFile(envPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read the environment of %s: %w", command.CmdPrettyName, err)
+	}
+	shellDump, err := os.ReadFile(shellPath)
+	if err != nil {
+		return fmt.Errorf("cannot read the functions and aliases of %s: %w", command.CmdPrettyName, err)
+	}
+	var env []string
+	for _, variable := range bytes.Split(envDump, []byte{0}) {
+		// the dump ends with a NUL, leaving an empty entry
+		if len(variable) > 0 {
+			env = append(env, string(variable))
+		}
+	}
+	command.Ctx.Env = env
+	command.Ctx.ShellDefinitions = string(shellDump)
+	return nil
+}
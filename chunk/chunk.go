@@ -7,17 +7,38 @@ import (
 	"bufio"
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/arkmq-org/markdown-runner/condition"
 	"github.com/arkmq-org/markdown-runner/runnercontext"
+	"github.com/arkmq-org/markdown-runner/view"
 	"github.com/google/shlex"
 	"github.com/google/uuid"
+	"github.com/pmezard/go-difflib/difflib"
 )
 
+// Source locates a chunk in its markdown file.
+type Source struct {
+	File string
+	// Line is the line of the opening fence of the chunk, starting at 1
+	Line int
+}
+
+// String returns the location as file:line, empty when it's unknown.
+func (source Source) String() string {
+	if source.File == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", source.File, source.Line)
+}
+
 // ExecutableChunk represents a block of code from a markdown file that can be
 // executed. It contains all the metadata and content parsed from the code fence.
 type ExecutableChunk struct {
@@ -31,16 +52,48 @@ type ExecutableChunk struct {
 	// "stageName/chunkId". The current chunk will only be executed if the
 	// required chunk has been executed successfully.
 	Requires string `json:"requires,omitempty"`
+	// Include references the chunks of another markdown file replacing this
+	// one, as "path/to/file.md#stage", "path/to/file.md#stage/id" or
+	// "path/to/file.md#id" for some of them, or "path/to/file.md" for all.
+	Include string `json:"include,omitempty"`
 	// RootDir specifies the execution directory for the chunk. It can be set
 	// to special values like "$initial_dir" or "$tmpdir.name" to use the
 	// initial working directory or a shared temporary directory, respectively.
 	RootDir string `json:"rootdir,omitempty"`
 	// Runtime specifies the execution environment. Common values are "bash"
-	// for shell scripts or "writer" to write content to a file.
+	// for shell scripts or "writer" to write content to a file. The "sh",
+	// "python3", "node" and "go" runtimes execute the content with the
+	// corresponding interpreter, and "exec" pipes it to the Interpreter.
 	Runtime string `json:"runtime,omitempty"`
+	// Interpreter is the command the content of an "exec" runtime chunk is
+	// piped to, such as "ruby -".
+	Interpreter string `json:"interpreter,omitempty"`
+	// Shell feeds a bash chunk to a long-lived shell instead of a script of
+	// its own, for it to share its working directory, variables, functions
+	// and aliases with the next chunks of the shell. It's either "file", for a
+	// shell per file, or "rootdir", for a shell per runtime directory.
+	Shell string `json:"shell,omitempty"`
 	// IsParallel, if true, indicates that this chunk can be run in parallel
 	// with other chunks in the same stage.
 	IsParallel bool `json:"parallel,omitempty"`
+	// IsBackground, if true, starts the chunk as a long-lived process that
+	// keeps running across the next stages until the file is done.
+	IsBackground bool `json:"background,omitempty"`
+	// Ready is the probe telling when a background chunk is ready.
+	Ready *ReadinessProbe `json:"ready,omitempty"`
+	// ExpectExit is the exit code the commands of the chunk are expected to end
+	// with. It's either a code, a list of codes or "nonzero", 0 by default.
+	ExpectExit ExitExpectation `json:"expect_exit,omitempty"`
+	// ExpectStderr is a regex the stderr of the commands is expected to match.
+	ExpectStderr string `json:"expect_stderr,omitempty"`
+	// If is a condition, see the condition package, that must be true for the
+	// chunk to be executed.
+	If string `json:"if,omitempty"`
+	// SkipIf is a condition that skips the chunk when true.
+	SkipIf string `json:"skip_if,omitempty"`
+	// Tags are the names the chunk can be selected by with --tags and
+	// --skip-tags.
+	Tags []string `json:"tags,omitempty"`
 	// Label provides a human-readable name for the chunk, which is used in
 	// logging and CLI output.
 	Label string `json:"label,omitempty"`
@@ -49,8 +102,24 @@ type ExecutableChunk struct {
 	HasBreakpoint bool `json:"breakpoint,omitempty"`
 	// Destination is the target file path for chunks with the "writer" runtime.
 	Destination string `json:"destination,omitempty"`
+	// Normalize lists the normalizers applied to the output of the chunk before
+	// it gets written or compared, on top of the ones from the configuration.
+	Normalize []NormalizerSpec `json:"normalize,omitempty"`
+	// Timeout is the maximum duration of each command of the chunk, written as
+	// a Go duration such as "30s" or "5m". It overrides the global timeout.
+	Timeout string `json:"timeout,omitempty"`
+	// Retries is the number of times a failing chunk is executed again before
+	// being considered as failed.
+	Retries int `json:"retries,omitempty"`
+	// RetryDelay is the Go duration to wait for between two attempts.
+	RetryDelay string `json:"retry_delay,omitempty"`
 	// Content holds the lines of code that make up the chunk's body.
 	Content []string
+	// ExpectedOutput holds the lines of the output block that follows the chunk
+	// in the markdown file. It is nil when the chunk has no output block.
+	ExpectedOutput []string
+	// Source is where the chunk is written, set by the parser.
+	Source Source
 	// Commands is the list of RunningCommand instances generated from the Content.
 	Commands []*RunningCommand
 	// BackQuotes stores the number of backquotes used in the opening code fence,
@@ -58,6 +127,34 @@ type ExecutableChunk struct {
 	BackQuotes int
 	Context    *runnercontext.Context
 	IsSkipped  bool
+	// IsExcluded is set when the chunk isn't part of the selection made with
+	// the tags, it's then skipped.
+	IsExcluded bool
+	// SkipReason tells why the chunk was skipped.
+	SkipReason string
+	// ReadyError is set when a background chunk failed its readiness probe.
+	ReadyError error
+	// WriteError is set when a writer chunk failed to write its file.
+	WriteError error
+	// HasStarted is set once the chunk has been handed over for execution.
+	HasStarted bool
+	// Index is the position of the chunk within its stage.
+	Index int
+	// Attempt is the number of times the chunk has been prepared for execution.
+	Attempt int
+	// PreviousAttempts holds the commands of the failed attempts that were
+	// retried, Commands always holding the ones of the last attempt.
+	PreviousAttempts [][]*RunningCommand
+	// timeout, retryDelay and expectStderr are the parsed versions of Timeout,
+	// RetryDelay and ExpectStderr
+	timeout      time.Duration
+	retryDelay   time.Duration
+	expectStderr *regexp.Regexp
+	// ifCondition and skipIfCondition are the parsed versions of If and SkipIf
+	ifCondition     *condition.Expression
+	skipIfCondition *condition.Expression
+	// normalizers are the compiled versions of the configured normalizers
+	normalizers []normalizer
 }
 
 // Init initializes an ExecutableChunk after it has been unmarshalled from JSON.
@@ -70,6 +167,88 @@ func (chunk *ExecutableChunk) Init() {
 	chunk.IsSkipped = false
 }
 
+// ParseExecutionPolicy parses the timeout and the retry delay of the chunk. It
+// returns an error if one of them isn't a valid positive duration.
+func (chunk *ExecutableChunk) ParseExecutionPolicy() error {
+	var err error
+	if chunk.Timeout != "" {
+		chunk.timeout, err = time.ParseDuration(chunk.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %w", err)
+		}
+		if chunk.timeout <= 0 {
+			return fmt.Errorf("invalid timeout %s: it must be positive", chunk.Timeout)
+		}
+	}
+	if chunk.RetryDelay != "" {
+		chunk.retryDelay, err = time.ParseDuration(chunk.RetryDelay)
+		if err != nil {
+			return fmt.Errorf("invalid retry_delay: %w", err)
+		}
+	}
+	if chunk.Retries < 0 {
+		return fmt.Errorf("invalid retries %d: it can't be negative", chunk.Retries)
+	}
+	if chunk.ExpectStderr != "" {
+		chunk.expectStderr, err = regexp.Compile(chunk.ExpectStderr)
+		if err != nil {
+			return fmt.Errorf("invalid expect_stderr regex '%s': %w", chunk.ExpectStderr, err)
+		}
+	}
+	if chunk.IsBackground && chunk.IsParallel {
+		return errors.New("a background chunk can't be parallel")
+	}
+	if chunk.IsBackground && chunk.Runtime == "writer" {
+		return errors.New("a writer chunk can't run in the background")
+	}
+	if chunk.Runtime == "exec" && chunk.Interpreter == "" {
+		return errors.New("an exec runtime requires an interpreter property")
+	}
+	if chunk.Interpreter != "" && chunk.Runtime != "exec" {
+		return errors.New("an interpreter requires the exec runtime")
+	}
+	if chunk.Shell != "" {
+		if chunk.Runtime != "bash" {
+			return errors.New("a persistent shell requires the bash runtime")
+		}
+		if chunk.IsParallel || chunk.IsBackground {
+			return errors.New("a chunk fed to a persistent shell can't be parallel or run in the background")
+		}
+	}
+	if chunk.If != "" {
+		chunk.ifCondition, err = condition.Parse(chunk.If)
+		if err != nil {
+			return err
+		}
+	}
+	if chunk.SkipIf != "" {
+		chunk.skipIfCondition, err = condition.Parse(chunk.SkipIf)
+		if err != nil {
+			return err
+		}
+	}
+	if chunk.Ready != nil {
+		if !chunk.IsBackground {
+			return errors.New("a readiness probe requires the chunk to run in the background")
+		}
+		return chunk.Ready.parse()
+	}
+	return nil
+}
+
+// commandTimeout returns the timeout of the chunk if it has one, the global
+// timeout otherwise. Background chunks have no timeout unless they set one, as
+// they are meant to keep running, 0 is returned for them.
+func (chunk *ExecutableChunk) commandTimeout() time.Duration {
+	if chunk.timeout > 0 {
+		return chunk.timeout
+	}
+	if chunk.IsBackground {
+		return 0
+	}
+	return time.Duration(chunk.Context.Cfg.MinutesToTimeout) * time.Minute
+}
+
 // HasOutput checks if any of the commands in the chunk have produced stdout.
 // It always returns true if the runner is in dry-run mode.
 func (chunk *ExecutableChunk) HasOutput() bool {
@@ -84,65 +263,88 @@ func (chunk *ExecutableChunk) HasOutput() bool {
 	return false
 }
 
+// Output returns the captured stdout and stderr of all commands in the chunk,
+// normalized and formatted exactly as they are written in the output code
+// block.
+func (chunk *ExecutableChunk) Output() string {
+	var builder strings.Builder
+	for _, command := range chunk.Commands {
+		for _, output := range []string{command.Stdout, command.Stderr} {
+			if output == "" {
+				continue
+			}
+			builder.WriteString(output)
+			// make sure to only have one carriage return at the end
+			if output[len(output)-1] != '\n' {
+				builder.WriteString("\n")
+			}
+		}
+	}
+	return chunk.normalizeOutput(builder.String())
+}
+
 // WriteOutputTo writes the captured stdout and stderr of all commands in the
 // chunk to a new code block in the provided writer.
 //
 // bqNumber is the number of backquotes to use for the output code fence.
 // writer is the bufio.Writer to write the output to.
 func (chunk *ExecutableChunk) WriteOutputTo(bqNumber int, writer *bufio.Writer) error {
-	var err error
-	// print the start of the chunk
-	for i := 0; i < bqNumber; i++ {
-		_, err = writer.WriteString("`")
-		if err != nil {
-			return err
-		}
+	fence := strings.Repeat("`", bqNumber)
+	_, err := writer.WriteString(fence + "shell markdown_runner\n")
+	if err != nil {
+		return err
 	}
-	_, err = writer.WriteString("shell markdown_runner\n")
+	_, err = writer.WriteString(chunk.Output())
 	if err != nil {
 		return err
 	}
-	for _, command := range chunk.Commands {
-		if command.Stdout != "" {
-			_, err = writer.WriteString(command.Stdout)
-			if err != nil {
-				return err
-			}
-			// make sure to only have one carriage return at the end
-			if command.Stdout[len(command.Stdout)-1] != '\n' {
-				_, err = writer.WriteString("\n")
-				if err != nil {
-					return err
-				}
-			}
-		}
-		if command.Stderr != "" {
-			_, err = writer.WriteString(command.Stderr)
-			if err != nil {
-				return err
-			}
-			// make sure to only have one carriage return at the end
-			if command.Stderr[len(command.Stderr)-1] != '\n' {
-				_, err = writer.WriteString("\n")
-				if err != nil {
-					return err
-				}
-			}
-		}
+	// print the end of the chunk with a final carriage return
+	_, err = writer.WriteString(fence + "\n")
+	return err
+}
+
+// DisplayName returns a human-readable name for the chunk, preferring its
+// label, then its stage/id reference, and finally its stage name.
+func (chunk *ExecutableChunk) DisplayName() string {
+	if chunk.Label != "" {
+		return chunk.Label
 	}
-	// print the end of the chunk
-	for i := 0; i < bqNumber; i++ {
-		_, err = writer.WriteString("`")
-		if err != nil {
-			return err
-		}
+	if chunk.Id != "" {
+		return chunk.Stage + "/" + chunk.Id
 	}
-	// add a final carriage return
-	_, err = writer.WriteString("\n")
+	return chunk.Stage
+}
+
+// CheckOutput compares the output of an executed chunk with the output block
+// recorded in the markdown file. Chunks that did not run or that have no output
+// block are ignored, an empty output block expecting no output. It returns an
+// error holding a unified diff when the two disagree.
+func (chunk *ExecutableChunk) CheckOutput() error {
+	if chunk.ExpectedOutput == nil || chunk.Context.Cfg.DryRun || !chunk.HasFinishedExecution() {
+		return nil
+	}
+	expected := ""
+	if len(chunk.ExpectedOutput) > 0 {
+		expected = chunk.normalizeOutput(strings.Join(chunk.ExpectedOutput, "\n") + "\n")
+	}
+	actual := chunk.Output()
+	if expected == actual {
+		return nil
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(expected),
+		B:        difflib.SplitLines(actual),
+		FromFile: "documented",
+		ToFile:   "actual",
+		Context:  3,
+	})
 	if err != nil {
 		return err
 	}
-	return nil
+	if chunk.Source.File != "" {
+		return fmt.Errorf("%s: output of '%s' differs from the documentation:\n%s", chunk.Source, chunk.DisplayName(), diff)
+	}
+	return fmt.Errorf("output of '%s' differs from the documentation:\n%s", chunk.DisplayName(), diff)
 }
 
 // GetOrCreateRuntimeDirectory determines the correct execution directory for
@@ -212,12 +414,18 @@ func (chunk *ExecutableChunk) WriteFile(basedir string) error {
 
 // WriteBashScript writes the content of a chunk with the "bash" runtime to a
 // temporary shell script on disk. The script is made executable and includes
-// standard shell boilerplate.
+// standard shell boilerplate: it restores the functions and aliases of the
+// previous bash chunks and, once done, dumps its environment, functions and
+// aliases to the state files of the script for the next chunks.
 //
 // basedir is the directory where the script will be created.
 // script_name is the name of the script file.
 func (chunk *ExecutableChunk) WriteBashScript(basedir string, script_name string) error {
 	scriptPath := path.Join(basedir, script_name)
+	statePath, err := filepath.Abs(bashStatePath(scriptPath))
+	if err != nil {
+		return err
+	}
 	f, err := os.Create(scriptPath)
 	if err != nil {
 		return err
@@ -235,6 +443,12 @@ func (chunk *ExecutableChunk) WriteBashScript(basedir string, script_name string
 		return err
 	}
 
+	// restore the functions and aliases defined by the previous bash chunks
+	_, err = writer.WriteString("shopt -s expand_aliases\n" + chunk.Context.ShellDefinitions)
+	if err != nil {
+		return err
+	}
+
 	// enable verbose mode (set -x) when verbose flag is set
 	if chunk.Context.Cfg.Verbose {
 		_, err = writer.WriteString("set -x\n")
@@ -251,11 +465,13 @@ func (chunk *ExecutableChunk) WriteBashScript(basedir string, script_name string
 		}
 	}
 
-	// bubble up the env after the script execution
-	// include newline in the echo output itself to ensure ENV marker starts on its own line
-	// even if user script doesn't end with newline (e.g. echo -n)
-	_, err = writer.WriteString("echo \"\n### ENV ###\"\n")
-	_, err = writer.WriteString("printenv\n")
+	// bubble up the env, functions and aliases after the script execution,
+	// through files so that the output of the user is never mistaken for them
+	_, err = writer.WriteString(fmt.Sprintf("env -0 > %s\n", shellQuote(statePath+envStateSuffix)))
+	if err != nil {
+		return err
+	}
+	_, err = writer.WriteString(fmt.Sprintf("{ declare -f; alias -p; } > %s\n", shellQuote(statePath+shellStateSuffix)))
 	if err != nil {
 		return err
 	}
@@ -276,22 +492,23 @@ func (chunk *ExecutableChunk) HasFinishedExecution() bool {
 		return false
 	}
 	for _, command := range chunk.Commands {
-		if command.Cmd.ProcessState == nil {
+		if !command.hasExited() {
 			return false
 		}
 	}
 	return true
 }
 
-// HasExecutedCorrectly checks if all commands within the chunk completed with a
-// zero exit code. It always returns true in dry-run mode.
+// HasExecutedCorrectly checks if all commands within the chunk completed as
+// expected, with a zero exit code unless the chunk expects another one. It
+// always returns true in dry-run mode.
 func (chunk *ExecutableChunk) HasExecutedCorrectly() bool {
-	if !chunk.HasFinishedExecution() {
+	if !chunk.HasFinishedExecution() || chunk.ReadyError != nil {
 		return false
 	}
 	var allOk bool = true
 	for _, command := range chunk.Commands {
-		allOk = allOk && command.Cmd.ProcessState.ExitCode() == 0
+		allOk = allOk && command.HasSucceeded()
 	}
 	return allOk
 }
@@ -310,7 +527,15 @@ func (chunk *ExecutableChunk) AddCommandToExecute(trimedCommand string, tmpDirs
 	// create the cancel background function, the command.cancelFunc has to get called eventually to avoid leaking
 	// memory
 	ctx := context.Background()
-	ctx, command.CancelFunc = context.WithTimeout(context.Background(), time.Duration(chunk.Context.Cfg.MinutesToTimeout)*time.Minute)
+	if command.interrupt = chunk.interrupt(); command.interrupt != nil {
+		ctx = command.interrupt
+	}
+	if timeout := chunk.commandTimeout(); timeout > 0 {
+		ctx, command.CancelFunc = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, command.CancelFunc = context.WithCancel(ctx)
+	}
+	command.deadline = ctx
 
 	if trimedCommand == "" {
 		return nil, errors.New("empty command string provided")
@@ -324,6 +549,8 @@ func (chunk *ExecutableChunk) AddCommandToExecute(trimedCommand string, tmpDirs
 	executable := splited[0]
 	args := splited[1:]
 	command.Cmd = exec.CommandContext(ctx, executable, args...)
+	// the processes started by the command are given the chance to stop by themselves
+	command.Cmd.Cancel = command.stop
 
 	// set the runtime directory for the command
 	command.Cmd.Dir, err = chunk.GetOrCreateRuntimeDirectory(tmpDirs)
@@ -332,18 +559,33 @@ func (chunk *ExecutableChunk) AddCommandToExecute(trimedCommand string, tmpDirs
 	}
 
 	// Copy the environment before calling the command
-	command.Cmd.Env = append(command.Cmd.Env, chunk.Context.Cfg.Env...)
+	command.Cmd.Env = append(command.Cmd.Env, chunk.Context.Env...)
 
 	// give a pretty name to the command for the cli output
 	command.InitCommandLabel(chunk)
+	command.details = chunk.viewDetails()
+	command.details.Streamed = chunk.Context.Cfg.Stream
 
 	// set the bash flag for the command
 	command.IsBash = chunk.Runtime == "bash"
+	command.IsBackground = chunk.IsBackground
+	command.ExpectExit = chunk.ExpectExit
+	command.expectStderr = chunk.expectStderr
 
 	chunk.Commands = append(chunk.Commands, &command)
 	return &command, nil
 }
 
+// interrupt returns the context that is done once the execution of the chunk
+// gets interrupted, nil when it can't be. The teardown chunks are the ones
+// executed after an interruption, they can't be interrupted.
+func (chunk *ExecutableChunk) interrupt() context.Context {
+	if chunk.Stage == "teardown" {
+		return nil
+	}
+	return chunk.Context.Interrupt
+}
+
 // ExecuteSequential runs the commands in a sequential chunk. It's a convenience method
 // that initializes a spinner for each command and then executes it. This method
 // should only be used for chunks where IsParallel is false.
@@ -351,6 +593,25 @@ func (chunk *ExecutableChunk) ExecuteSequential() error {
 	if chunk.IsParallel {
 		return errors.New("Cannot execute a parallel chunk with Execute, use Start instead")
 	}
+	return chunk.execute()
+}
+
+// execute executes the chunk through its runtime, or reports what it would do
+// in dry-run mode.
+func (chunk *ExecutableChunk) execute() error {
+	runtime, err := chunk.runtime()
+	if err != nil {
+		return err
+	}
+	if chunk.Context.Cfg.DryRun {
+		return runtime.DryRun(chunk)
+	}
+	return runtime.Execute(chunk)
+}
+
+// executeCommands runs the commands of the chunk one after the other, stopping
+// at the first one failing.
+func (chunk *ExecutableChunk) executeCommands() error {
 	for _, command := range chunk.Commands {
 		err := command.Execute()
 		if err != nil {
@@ -393,6 +654,48 @@ func (chunk *ExecutableChunk) WaitParallel(shouldKill bool) error {
 	return command.Wait()
 }
 
+// Retry executes a chunk that failed with err again, as long as the error comes
+// from a command that ran and failed and the chunk has retries left. The
+// commands of every failed attempt are kept in PreviousAttempts. It returns the
+// error of the last attempt, nil if one of them succeeded.
+//
+// tmpDirs is the map of temporary directories for runtime directory resolution.
+func (chunk *ExecutableChunk) Retry(err error, tmpDirs map[string]string) error {
+	for isExitError(err) && chunk.Attempt <= chunk.Retries {
+		chunk.Context.RView.Warning(fmt.Sprintf("%s failed on attempt %d/%d, retrying in %s", chunk.DisplayName(), chunk.Attempt, chunk.Retries+1, chunk.retryDelay))
+		var interrupted <-chan struct{}
+		if interrupt := chunk.interrupt(); interrupt != nil {
+			interrupted = interrupt.Done()
+		}
+		select {
+		case <-time.After(chunk.retryDelay):
+		case <-interrupted:
+			return err
+		}
+		chunk.PreviousAttempts = append(chunk.PreviousAttempts, chunk.Commands)
+		chunk.Commands = nil
+		err = chunk.PrepareForExecution(tmpDirs)
+		if err != nil {
+			return err
+		}
+		err = chunk.execute()
+	}
+	return err
+}
+
+// viewDetails describes the chunk for the views that report more than the
+// pretty name of its commands.
+func (chunk *ExecutableChunk) viewDetails() view.CommandDetails {
+	return view.CommandDetails{
+		Stage:      chunk.Stage,
+		ChunkId:    chunk.Id,
+		ChunkIndex: chunk.Index,
+		Label:      chunk.Label,
+		Source:     chunk.Source.String(),
+		Attempt:    chunk.Attempt,
+	}
+}
+
 // applyWriter handles the execution for a chunk with the "writer" runtime. It
 // creates the destination file and writes the chunk's content to it, showing a
 // spinner in the CLI during the process.
@@ -402,13 +705,13 @@ func (chunk *ExecutableChunk) applyWriter(tmpDirs map[string]string) error {
 		writerString += " for " + chunk.Label
 	}
 	id := uuid.New().String()
+	chunk.Context.RView.DescribeCommand(id, chunk.viewDetails())
 	chunk.Context.RView.StartCommand(id, writerString)
 	directory, err := chunk.GetOrCreateRuntimeDirectory(tmpDirs)
-	if err != nil {
-		chunk.Context.RView.StopCommand(id, false, err.Error())
-		return err
+	if err == nil {
+		err = chunk.WriteFile(directory)
 	}
-	err = chunk.WriteFile(directory)
+	chunk.WriteError = err
 	if err != nil {
 		chunk.Context.RView.StopCommand(id, false, err.Error())
 		return err
@@ -426,6 +729,9 @@ func (chunk *ExecutableChunk) prepareClassical(tmpDirs map[string]string) error
 	if chunk.IsParallel && len(chunk.Content) > 1 {
 		return errors.New("Multiple commands for non bash runtime is not supported when parallel is set, update the chunk to a bash runtime")
 	}
+	if chunk.IsBackground && len(chunk.Content) > 1 {
+		return errors.New("Multiple commands for non bash runtime is not supported when background is set, update the chunk to a bash runtime")
+	}
 	for _, command := range chunk.Content {
 		_, err := chunk.AddCommandToExecute(command, tmpDirs)
 		if err != nil {
@@ -445,37 +751,113 @@ func (chunk *ExecutableChunk) prepareBashChunkForExecution(tmpDirs map[string]st
 	if err != nil {
 		return err
 	}
+	if chunk.Shell != "" {
+		return chunk.prepareShellScript(command, cmd)
+	}
 	err = chunk.WriteBashScript(command.Cmd.Dir, cmd)
 	if err != nil {
 		return err
 	}
+	command.statePath = bashStatePath(path.Join(command.Cmd.Dir, cmd))
 	return nil
 }
 
-// PrepareForExecution sets up the chunk for execution based on its runtime.
-// It dispatches to the appropriate helper function (e.g., for "writer" or
-// "bash" runtimes) to create the necessary commands and files.
+// prepareShellScript writes the script of a bash chunk fed to a persistent
+// shell, and sets the command up to be executed by the shell.
+//
+// command is the command of the chunk.
+// scriptName is the name of the script file, in the runtime directory.
+func (chunk *ExecutableChunk) prepareShellScript(command *RunningCommand, scriptName string) error {
+	scriptPath, err := filepath.Abs(path.Join(command.Cmd.Dir, scriptName))
+	if err != nil {
+		return err
+	}
+	content := shellScriptPrelude
+	if chunk.Context.Cfg.Verbose {
+		content += "set -x\n"
+	}
+	for _, line := range chunk.Content {
+		content += line + "\n"
+	}
+	err = os.WriteFile(scriptPath, []byte(content), 0o660)
+	if err != nil {
+		return err
+	}
+	command.scriptPath = scriptPath
+	command.statePath = bashStatePath(scriptPath)
+	command.shellKey = chunk.shellKey(command.Cmd.Dir)
+	return nil
+}
+
+// PrepareForExecution sets up the chunk for execution through its runtime,
+// which creates the necessary commands and files, see Runtime.
 func (chunk *ExecutableChunk) PrepareForExecution(tmpDirs map[string]string) error {
-	switch chunk.Runtime {
-	case "writer":
-		return chunk.applyWriter(tmpDirs)
-	case "bash":
-		return chunk.prepareBashChunkForExecution(tmpDirs)
-	default:
-		return chunk.prepareClassical(tmpDirs)
+	chunk.HasStarted = true
+	chunk.Attempt++
+	runtime, err := chunk.runtime()
+	if err != nil {
+		return err
+	}
+	return runtime.Prepare(chunk, tmpDirs)
+}
+
+// EvaluateConditions evaluates the if and skip_if conditions of the chunk. It
+// returns the reason why the chunk must be skipped, empty if it must be
+// executed, and an error if a condition could not be evaluated.
+func (chunk *ExecutableChunk) EvaluateConditions(resolver condition.Resolver) (string, error) {
+	if chunk.ifCondition != nil {
+		result, err := chunk.ifCondition.Evaluate(resolver)
+		if err != nil {
+			return "", err
+		}
+		if !result {
+			return fmt.Sprintf("the condition %q is false", chunk.ifCondition), nil
+		}
 	}
+	if chunk.skipIfCondition != nil {
+		result, err := chunk.skipIfCondition.Evaluate(resolver)
+		if err != nil {
+			return "", err
+		}
+		if result {
+			return fmt.Sprintf("the skip condition %q is true", chunk.skipIfCondition), nil
+		}
+	}
+	return "", nil
 }
 
+// ConditionReferences returns the references to other chunks, such as
+// 'stageName/id', made by the if and skip_if conditions of the chunk.
+func (chunk *ExecutableChunk) ConditionReferences() []string {
+	var refs []string
+	if chunk.ifCondition != nil {
+		refs = append(refs, chunk.ifCondition.ChunkReferences()...)
+	}
+	if chunk.skipIfCondition != nil {
+		refs = append(refs, chunk.skipIfCondition.ChunkReferences()...)
+	}
+	return refs
+}
+
+// SkipBecause marks the chunk as skipped for the given reason, without it being
+// an error, and reports it.
+func (chunk *ExecutableChunk) SkipBecause(reason string) {
+	chunk.IsSkipped = true
+	chunk.SkipReason = reason
+	id := uuid.New().String()
+	chunk.Context.RView.DescribeCommand(id, chunk.viewDetails())
+	chunk.Context.RView.StartCommand(id, chunk.DisplayName())
+	chunk.Context.RView.SkipCommand(id, fmt.Sprintf("%s: skipped as %s", chunk.DisplayName(), reason))
+}
+
+// Skip marks the chunk as skipped due to previous errors and reports it.
 func (chunk *ExecutableChunk) Skip() {
 	chunk.IsSkipped = true
-	switch chunk.Runtime {
-	case "writer":
-		chunk.Context.RView.Info("Skip writer chunk '" + chunk.Label + "' due to previous errors")
-	case "bash":
-		chunk.Context.RView.Info("Skip bash chunk '" + chunk.Label + "' due to previous errors")
-	default:
-		for _, command := range chunk.Content {
-			chunk.Context.RView.Info("Skip command '" + command + "' due to previous errors")
-		}
+	chunk.SkipReason = "skipped due to previous errors"
+	runtime, err := chunk.runtime()
+	if err != nil {
+		chunk.Context.RView.Info("Skip chunk '" + chunk.DisplayName() + "' due to previous errors")
+		return
 	}
+	chunk.Context.RView.Info(runtime.SkipMessage(chunk))
 }
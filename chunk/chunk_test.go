@@ -2,12 +2,14 @@ package chunk_test
 
 import (
 	"bufio"
+	"net"
 	"os"
 	"os/exec"
 	"path"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/arkmq-org/markdown-runner/chunk"
 	"github.com/arkmq-org/markdown-runner/config"
@@ -201,6 +203,439 @@ func TestExecutableChunk(t *testing.T) {
 			assert.NoError(t, err)
 		})
 	})
+	t.Run("timeout", func(t *testing.T) {
+		c := &chunk.ExecutableChunk{
+			Content: []string{"sleep 5"},
+			Timeout: "100ms",
+			Context: &runnercontext.Context{
+				Cfg:   &config.Config{MinutesToTimeout: 1},
+				RView: view.NewView("mock"),
+			},
+		}
+		assert.NoError(t, c.ParseExecutionPolicy())
+		assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+		start := time.Now()
+		err := c.ExecuteSequential()
+		assert.Error(t, err, "Expected the command to be killed by its timeout")
+		assert.Less(t, time.Since(start), 2*time.Second)
+	})
+	t.Run("process group", func(t *testing.T) {
+		// isGone tells whether the process whose pid is written in the file is over
+		isGone := func(pidFile string) bool {
+			content, err := os.ReadFile(pidFile)
+			if err != nil {
+				return false
+			}
+			stat, err := os.ReadFile("/proc/" + strings.TrimSpace(string(content)) + "/stat")
+			// a zombie is over, its parent just didn't reap it yet
+			return err != nil || strings.Contains(string(stat), ") Z ")
+		}
+		t.Run("it should stop the descendants on timeout", func(t *testing.T) {
+			tmpDir := t.TempDir()
+			c := &chunk.ExecutableChunk{
+				Runtime: "bash",
+				RootDir: tmpDir,
+				Content: []string{"trap '' TERM", "sleep 30 &", "echo $! > pid", "wait"},
+				Timeout: "100ms",
+				Context: &runnercontext.Context{
+					Cfg:   &config.Config{MinutesToTimeout: 1, GracePeriod: 200 * time.Millisecond},
+					RView: view.NewView("mock"),
+				},
+			}
+			assert.NoError(t, c.ParseExecutionPolicy())
+			assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+			start := time.Now()
+			assert.Error(t, c.ExecuteSequential(), "Expected the command to be stopped by its timeout")
+			assert.Less(t, time.Since(start), 3*time.Second)
+			assert.Eventually(t, func() bool { return isGone(path.Join(tmpDir, "pid")) }, 3*time.Second, 50*time.Millisecond,
+				"Expected the descendants ignoring SIGTERM to be killed")
+		})
+		t.Run("it should not wait for the descendants holding the output", func(t *testing.T) {
+			tmpDir := t.TempDir()
+			c := &chunk.ExecutableChunk{
+				Runtime: "bash",
+				RootDir: tmpDir,
+				Content: []string{"sleep 30 &", "echo $! > pid", "echo done"},
+				Context: &runnercontext.Context{
+					Cfg:   &config.Config{MinutesToTimeout: 1, GracePeriod: 200 * time.Millisecond},
+					RView: view.NewView("mock"),
+				},
+			}
+			assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+			start := time.Now()
+			assert.NoError(t, c.ExecuteSequential())
+			assert.Less(t, time.Since(start), 3*time.Second)
+			assert.Equal(t, "done\n", c.Commands[0].Stdout)
+			assert.Eventually(t, func() bool { return isGone(path.Join(tmpDir, "pid")) }, 3*time.Second, 50*time.Millisecond,
+				"Expected the descendants left behind to be stopped")
+		})
+	})
+	t.Run("streaming", func(t *testing.T) {
+		// outputOf returns the lines given to the view for the command, prefixed by the stream
+		outputOf := func(ui *view.MockRunnerView) []string {
+			var lines []string
+			for _, call := range ui.Calls["CommandOutput"] {
+				stream := "out"
+				if call[1].(bool) {
+					stream = "err"
+				}
+				lines = append(lines, stream+" "+call[2].(string))
+			}
+			return lines
+		}
+		t.Run("it should give the output to the view line by line", func(t *testing.T) {
+			ui := view.NewView("mock").(*view.MockRunnerView)
+			c := &chunk.ExecutableChunk{
+				Runtime: "bash",
+				RootDir: t.TempDir(),
+				Content: []string{"echo one", "echo two >&2", "sleep 0.1", "echo -n three"},
+				Context: &runnercontext.Context{Cfg: &config.Config{MinutesToTimeout: 1, Stream: true}, RView: ui},
+			}
+			assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+			assert.NoError(t, c.ExecuteSequential())
+			assert.Equal(t, []string{"out one", "err two", "out three"}, outputOf(ui))
+			assert.True(t, ui.Calls["DescribeCommand"][0][1].(view.CommandDetails).Streamed)
+			assert.Equal(t, "one\nthree", c.Commands[0].Stdout, "Expected the output to be captured as well")
+			assert.Equal(t, "two\n", c.Commands[0].Stderr)
+		})
+		t.Run("it should give the output of a persistent shell to the view", func(t *testing.T) {
+			ui := view.NewView("mock").(*view.MockRunnerView)
+			ctx := runnercontext.NewContext(&config.Config{MinutesToTimeout: 1, Env: os.Environ(), Stream: true}, ui)
+			defer ctx.CloseShells()
+			c := &chunk.ExecutableChunk{
+				Runtime: "bash",
+				Shell:   chunk.ShellPerFile,
+				RootDir: t.TempDir(),
+				Content: []string{"echo one", "echo -n two"},
+				Context: ctx,
+			}
+			assert.NoError(t, c.ParseExecutionPolicy())
+			assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+			assert.NoError(t, c.ExecuteSequential())
+			assert.Equal(t, []string{"out one", "out two"}, outputOf(ui), "Expected the sentinel not to be given to the view")
+			assert.Equal(t, "one\ntwo", c.Commands[0].Stdout)
+		})
+		t.Run("it should not give the output to the view by default", func(t *testing.T) {
+			ui := view.NewView("mock").(*view.MockRunnerView)
+			c := &chunk.ExecutableChunk{
+				Runtime: "bash",
+				RootDir: t.TempDir(),
+				Content: []string{"echo one"},
+				Context: &runnercontext.Context{Cfg: &config.Config{MinutesToTimeout: 1}, RView: ui},
+			}
+			assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+			assert.NoError(t, c.ExecuteSequential())
+			assert.Empty(t, outputOf(ui))
+		})
+	})
+	t.Run("retry", func(t *testing.T) {
+		t.Run("it should retry a failing chunk until it succeeds", func(t *testing.T) {
+			tmpDir := t.TempDir()
+			c := &chunk.ExecutableChunk{
+				Runtime:    "bash",
+				RootDir:    tmpDir,
+				Content:    []string{"echo attempt >> attempts", "test $(wc -l < attempts) -ge 3"},
+				Retries:    3,
+				RetryDelay: "10ms",
+				Context: &runnercontext.Context{
+					Cfg:   &config.Config{MinutesToTimeout: 1},
+					RView: view.NewView("mock"),
+				},
+			}
+			assert.NoError(t, c.ParseExecutionPolicy())
+			assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+			err := c.Retry(c.ExecuteSequential(), make(map[string]string))
+			assert.NoError(t, err)
+			assert.Equal(t, 3, c.Attempt)
+			assert.Len(t, c.PreviousAttempts, 2)
+			assert.True(t, c.HasExecutedCorrectly())
+			assert.Contains(t, c.Commands[0].CmdPrettyName, "(attempt 3/4)")
+		})
+		t.Run("it should give up once out of retries", func(t *testing.T) {
+			c := &chunk.ExecutableChunk{
+				Content: []string{"false"},
+				Retries: 1,
+				Context: &runnercontext.Context{
+					Cfg:   &config.Config{MinutesToTimeout: 1},
+					RView: view.NewView("mock"),
+				},
+			}
+			assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+			err := c.Retry(c.ExecuteSequential(), make(map[string]string))
+			assert.Error(t, err)
+			assert.Equal(t, 2, c.Attempt)
+			assert.Len(t, c.PreviousAttempts, 1)
+		})
+		t.Run("it should not retry a command that could not start", func(t *testing.T) {
+			c := &chunk.ExecutableChunk{
+				Content: []string{"this-command-does-not-exist"},
+				Retries: 2,
+				Context: &runnercontext.Context{
+					Cfg:   &config.Config{MinutesToTimeout: 1},
+					RView: view.NewView("mock"),
+				},
+			}
+			assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+			err := c.Retry(c.ExecuteSequential(), make(map[string]string))
+			assert.Error(t, err)
+			assert.Equal(t, 1, c.Attempt)
+		})
+	})
+	t.Run("background", func(t *testing.T) {
+		newBackgroundChunk := func(content string, ready *chunk.ReadinessProbe) *chunk.ExecutableChunk {
+			return &chunk.ExecutableChunk{
+				Runtime:      "bash",
+				RootDir:      t.TempDir(),
+				IsBackground: true,
+				Ready:        ready,
+				Content:      []string{content},
+				Context: &runnercontext.Context{
+					Cfg:   &config.Config{MinutesToTimeout: 1},
+					RView: view.NewView("mock"),
+				},
+			}
+		}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer listener.Close()
+		readyCases := []struct {
+			name    string
+			content string
+			ready   *chunk.ReadinessProbe
+		}{
+			{"no probe", "while true; do sleep 0.1; done", nil},
+			{"log probe", "sleep 0.2; echo started; while true; do sleep 0.1; done", &chunk.ReadinessProbe{Log: "^start"}},
+			{"file probe", "sleep 0.2; touch ready; while true; do sleep 0.1; done", &chunk.ReadinessProbe{File: "ready"}},
+			{"command probe", "sleep 0.2; touch ready; while true; do sleep 0.1; done", &chunk.ReadinessProbe{Command: "test -f ready"}},
+			{"tcp probe", "while true; do sleep 0.1; done", &chunk.ReadinessProbe{Tcp: listener.Addr().String()}},
+		}
+		for _, tc := range readyCases {
+			t.Run(tc.name, func(t *testing.T) {
+				if tc.ready != nil {
+					tc.ready.Interval = "50ms"
+				}
+				c := newBackgroundChunk(tc.content, tc.ready)
+				assert.NoError(t, c.ParseExecutionPolicy())
+				assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+				assert.NoError(t, c.StartBackground())
+				assert.False(t, c.HasFinishedExecution(), "Expected the process to keep running")
+				assert.NoError(t, c.StopBackground())
+				assert.True(t, c.HasFinishedExecution())
+				assert.True(t, c.HasExecutedCorrectly(), "Expected a stopped background chunk to be successful")
+				assert.True(t, c.Commands[0].Terminated)
+				// stopping twice is a no-op
+				assert.NoError(t, c.StopBackground())
+			})
+		}
+		t.Run("it should keep the output", func(t *testing.T) {
+			c := newBackgroundChunk("echo started; while true; do sleep 0.1; done", &chunk.ReadinessProbe{Log: "started", Interval: "50ms"})
+			assert.NoError(t, c.ParseExecutionPolicy())
+			assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+			assert.NoError(t, c.StartBackground())
+			assert.NoError(t, c.StopBackground())
+			assert.Equal(t, "started\n", c.Output())
+		})
+		t.Run("it should fail when exiting before being ready", func(t *testing.T) {
+			c := newBackgroundChunk("exit 3", &chunk.ReadinessProbe{File: "never", Interval: "50ms"})
+			assert.NoError(t, c.ParseExecutionPolicy())
+			assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+			err := c.StartBackground()
+			assert.ErrorContains(t, err, "exited before being ready")
+			assert.Error(t, c.StopBackground(), "Expected the exit code to be reported")
+			assert.False(t, c.HasExecutedCorrectly())
+		})
+		t.Run("it should fail when not ready in time", func(t *testing.T) {
+			c := newBackgroundChunk("while true; do sleep 0.1; done", &chunk.ReadinessProbe{File: "never", Timeout: "200ms", Interval: "50ms"})
+			assert.NoError(t, c.ParseExecutionPolicy())
+			assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+			err := c.StartBackground()
+			assert.ErrorContains(t, err, "was not ready after 200ms")
+			assert.NoError(t, c.StopBackground())
+			assert.False(t, c.HasExecutedCorrectly())
+		})
+		t.Run("it should be ignored by StopBackground when it never started", func(t *testing.T) {
+			c := newBackgroundChunk("true", nil)
+			assert.NoError(t, c.StopBackground())
+		})
+		t.Run("it should reject invalid settings", func(t *testing.T) {
+			assert.Error(t, (&chunk.ExecutableChunk{IsBackground: true, IsParallel: true}).ParseExecutionPolicy())
+			assert.Error(t, (&chunk.ExecutableChunk{IsBackground: true, Runtime: "writer"}).ParseExecutionPolicy())
+			assert.Error(t, (&chunk.ExecutableChunk{Ready: &chunk.ReadinessProbe{File: "ready"}}).ParseExecutionPolicy())
+			assert.Error(t, (&chunk.ExecutableChunk{IsBackground: true, Ready: &chunk.ReadinessProbe{Log: "("}}).ParseExecutionPolicy())
+			assert.Error(t, (&chunk.ExecutableChunk{IsBackground: true, Ready: &chunk.ReadinessProbe{File: "ready", Timeout: "soon"}}).ParseExecutionPolicy())
+		})
+	})
+	t.Run("persistent shell", func(t *testing.T) {
+		newShellContext := func() *runnercontext.Context {
+			return runnercontext.NewContext(&config.Config{MinutesToTimeout: 1, Env: os.Environ()}, view.NewView("mock"))
+		}
+		run := func(ctx *runnercontext.Context, c *chunk.ExecutableChunk) error {
+			c.Runtime = "bash"
+			c.Context = ctx
+			if c.Shell == "" {
+				c.Shell = chunk.ShellPerFile
+			}
+			assert.NoError(t, c.ParseExecutionPolicy())
+			assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+			return c.ExecuteSequential()
+		}
+		t.Run("it should share the state of the shell between chunks", func(t *testing.T) {
+			ctx := newShellContext()
+			defer ctx.CloseShells()
+			rootDir := t.TempDir()
+			first := &chunk.ExecutableChunk{RootDir: rootDir, Content: []string{
+				"mkdir sub && cd sub",
+				"greet() { echo \"hello $1\"; }",
+				"alias shout='echo HEY'",
+				"LOCAL=kept",
+				"export EXPORTED=yes",
+				"echo -n started",
+			}}
+			assert.NoError(t, run(ctx, first))
+			assert.Equal(t, "started", first.Commands[0].Stdout, "Expected the sentinel not to be part of the output")
+			assert.Contains(t, ctx.Env, "EXPORTED=yes", "Expected the exported variables to be handed over to the other chunks")
+
+			second := &chunk.ExecutableChunk{Content: []string{"pwd", "greet world", "shout", "echo $LOCAL", "echo warning >&2"}}
+			assert.NoError(t, run(ctx, second))
+			assert.Equal(t, path.Join(rootDir, "sub")+"\nhello world\nHEY\nkept\n", second.Commands[0].Stdout)
+			assert.Equal(t, "warning\n", second.Commands[0].Stderr)
+		})
+		t.Run("it should stop a chunk at its first failure without ending the shell", func(t *testing.T) {
+			ctx := newShellContext()
+			defer ctx.CloseShells()
+			failing := &chunk.ExecutableChunk{Content: []string{"STATE=before", "f() { false; echo unreachable; }", "f", "STATE=after"}}
+			assert.Error(t, run(ctx, failing))
+			assert.Equal(t, "", failing.Commands[0].Stdout)
+			code, hasRun := failing.Commands[0].ExitCode()
+			assert.True(t, hasRun)
+			assert.Equal(t, 1, code)
+
+			next := &chunk.ExecutableChunk{Content: []string{"echo $STATE"}}
+			assert.NoError(t, run(ctx, next))
+			assert.Equal(t, "before\n", next.Commands[0].Stdout)
+		})
+		t.Run("it should start a new shell once a chunk exited", func(t *testing.T) {
+			ctx := newShellContext()
+			defer ctx.CloseShells()
+			exiting := &chunk.ExecutableChunk{ExpectExit: chunk.ExitExpectation{Codes: []int{4}}, Content: []string{"STATE=set", "echo bye", "exit 4"}}
+			assert.NoError(t, run(ctx, exiting))
+			assert.Equal(t, "bye\n", exiting.Commands[0].Stdout)
+
+			next := &chunk.ExecutableChunk{Content: []string{"echo ${STATE:-unset}"}}
+			assert.NoError(t, run(ctx, next))
+			assert.Equal(t, "unset\n", next.Commands[0].Stdout)
+		})
+		t.Run("it should keep a shell per rootdir", func(t *testing.T) {
+			ctx := newShellContext()
+			defer ctx.CloseShells()
+			tmpDirs := make(map[string]string)
+			for _, c := range []*chunk.ExecutableChunk{
+				{RootDir: "$tmpdir.a", Content: []string{"STATE=a"}},
+				{RootDir: "$tmpdir.b", Content: []string{"STATE=b"}},
+			} {
+				c.Runtime, c.Shell, c.Context = "bash", chunk.ShellPerRootdir, ctx
+				assert.NoError(t, c.PrepareForExecution(tmpDirs))
+				assert.NoError(t, c.ExecuteSequential())
+			}
+			check := &chunk.ExecutableChunk{Runtime: "bash", Shell: chunk.ShellPerRootdir, RootDir: "$tmpdir.a", Context: ctx, Content: []string{"echo $STATE"}}
+			assert.NoError(t, check.PrepareForExecution(tmpDirs))
+			assert.NoError(t, check.ExecuteSequential())
+			assert.Equal(t, "a\n", check.Commands[0].Stdout)
+			assert.Len(t, ctx.Shells, 2)
+		})
+		t.Run("it should kill the shell on timeout", func(t *testing.T) {
+			ctx := newShellContext()
+			defer ctx.CloseShells()
+			start := time.Now()
+			assert.Error(t, run(ctx, &chunk.ExecutableChunk{Timeout: "100ms", Content: []string{"sleep 5"}}))
+			assert.Less(t, time.Since(start), 3*time.Second)
+		})
+		t.Run("it should reject invalid settings", func(t *testing.T) {
+			assert.Error(t, (&chunk.ExecutableChunk{Shell: chunk.ShellPerFile}).ParseExecutionPolicy())
+			assert.Error(t, (&chunk.ExecutableChunk{Runtime: "bash", Shell: chunk.ShellPerFile, IsParallel: true}).ParseExecutionPolicy())
+			assert.Error(t, (&chunk.ExecutableChunk{Runtime: "bash", Shell: chunk.ShellPerFile, IsBackground: true}).ParseExecutionPolicy())
+		})
+	})
+	t.Run("interpreted runtimes", func(t *testing.T) {
+		testCases := []struct {
+			name        string
+			runtime     string
+			interpreter string
+			content     []string
+			stdout      string
+			shouldFail  bool
+		}{
+			{"sh", "sh", "", []string{"echo \"hello from $0\" | sed 's/from.*/from sh/'"}, "hello from sh\n", false},
+			{"sh failing", "sh", "", []string{"false", "echo unreachable"}, "", true},
+			{"sh unset variable", "sh", "", []string{"echo $UNSET_VARIABLE"}, "", true},
+			{"python3", "python3", "", []string{"import os", "print('hello', os.path.basename(os.getcwd()) != '')"}, "hello True\n", false},
+			{"python3 failing", "python3", "", []string{"raise SystemExit('boom')"}, "", true},
+			{"node", "node", "", []string{"const greeting = 'hello'", "console.log(`${greeting} from node`)"}, "hello from node\n", false},
+			{"node failing", "node", "", []string{"throw new Error('boom')"}, "", true},
+			{"go", "go", "", []string{"package main", "import \"fmt\"", "func main() { fmt.Println(\"hello from go\") }"}, "hello from go\n", false},
+			{"go not compiling", "go", "", []string{"package main", "func main() { undefined() }"}, "", true},
+			{"exec", "exec", "python3 -", []string{"print('piped')"}, "piped\n", false},
+			{"exec failing", "exec", "sh -s", []string{"exit 3"}, "", true},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				interpreter := tc.runtime
+				if tc.interpreter != "" {
+					interpreter = strings.Fields(tc.interpreter)[0]
+				}
+				if _, err := exec.LookPath(interpreter); err != nil {
+					t.Skipf("%s is not available", interpreter)
+				}
+				rootDir := t.TempDir()
+				c := &chunk.ExecutableChunk{
+					Runtime:     tc.runtime,
+					Interpreter: tc.interpreter,
+					RootDir:     rootDir,
+					Content:     tc.content,
+					Context:     runnercontext.NewContext(&config.Config{MinutesToTimeout: 1, Env: os.Environ()}, view.NewView("mock")),
+				}
+				assert.NoError(t, c.ParseExecutionPolicy())
+				assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+				err := c.ExecuteSequential()
+				leftovers, _ := os.ReadDir(rootDir)
+				assert.Empty(t, leftovers, "the files written to execute the chunk are removed")
+				if tc.shouldFail {
+					assert.Error(t, err)
+					return
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, tc.stdout, c.Commands[0].Stdout)
+			})
+		}
+		t.Run("go runs in the runtime directory", func(t *testing.T) {
+			if _, err := exec.LookPath("go"); err != nil {
+				t.Skip("go is not available")
+			}
+			rootDir := t.TempDir()
+			c := &chunk.ExecutableChunk{
+				Runtime: "go",
+				RootDir: rootDir,
+				Content: []string{"package main", "import \"os\"", "func main() { os.WriteFile(\"written\", nil, 0o644) }"},
+				Context: runnercontext.NewContext(&config.Config{MinutesToTimeout: 1, Env: append(os.Environ(), "GOFLAGS=-mod=mod")}, view.NewView("mock")),
+			}
+			assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+			assert.NoError(t, c.ExecuteSequential())
+			assert.FileExists(t, path.Join(rootDir, "written"))
+			files, _ := os.ReadDir(rootDir)
+			assert.Len(t, files, 1, "the temporary module is removed")
+		})
+		t.Run("it should reject invalid settings", func(t *testing.T) {
+			assert.Error(t, (&chunk.ExecutableChunk{Runtime: "exec"}).ParseExecutionPolicy())
+			assert.Error(t, (&chunk.ExecutableChunk{Runtime: "python3", Interpreter: "python3 -"}).ParseExecutionPolicy())
+		})
+	})
+	t.Run("parse execution policy", func(t *testing.T) {
+		assert.Error(t, (&chunk.ExecutableChunk{Timeout: "soon"}).ParseExecutionPolicy())
+		assert.Error(t, (&chunk.ExecutableChunk{Timeout: "-1s"}).ParseExecutionPolicy())
+		assert.Error(t, (&chunk.ExecutableChunk{RetryDelay: "later"}).ParseExecutionPolicy())
+		assert.Error(t, (&chunk.ExecutableChunk{Retries: -1}).ParseExecutionPolicy())
+		assert.NoError(t, (&chunk.ExecutableChunk{Timeout: "5m", Retries: 2, RetryDelay: "1s"}).ParseExecutionPolicy())
+	})
 	t.Run("wait", func(t *testing.T) {
 		t.Run("it should wait for a command", func(t *testing.T) {
 			ui := view.NewView("mock")
@@ -263,7 +698,7 @@ func TestExecutableChunk(t *testing.T) {
 
 		assert.Contains(t, testChunk.Commands[0].Stdout, "hello from bash")
 
-		found := slices.ContainsFunc(testChunk.Context.Cfg.Env, func(env string) bool {
+		found := slices.ContainsFunc(testChunk.Context.Env, func(env string) bool {
 			return env == "GREETING=hello from bash"
 		})
 		assert.True(t, found, "Expected GREETING to be in the environment variables")
@@ -405,6 +840,43 @@ func TestExecutableChunk(t *testing.T) {
 		expectedOutput := "```shell markdown_runner\nhello world\nthis is an error\n```\n"
 		assert.Equal(t, expectedOutput, writer.String())
 	})
+	t.Run("check output", func(t *testing.T) {
+		cfg := &config.Config{MinutesToTimeout: 1}
+		c := &chunk.ExecutableChunk{
+			Stage:          "test",
+			Content:        []string{"echo hello"},
+			ExpectedOutput: []string{"hello"},
+			Context:        &runnercontext.Context{Cfg: cfg, RView: view.NewView("mock")},
+		}
+		err := c.PrepareForExecution(make(map[string]string))
+		assert.NoError(t, err)
+		err = c.ExecuteSequential()
+		assert.NoError(t, err)
+		assert.NoError(t, c.CheckOutput(), "Expected the documented output to match")
+
+		c.ExpectedOutput = []string{"goodbye"}
+		err = c.CheckOutput()
+		assert.Error(t, err, "Expected an error when the documented output has drifted")
+		assert.Contains(t, err.Error(), "-goodbye")
+		assert.Contains(t, err.Error(), "+hello")
+
+		c.Source = chunk.Source{File: "test.md", Line: 12}
+		err = c.CheckOutput()
+		assert.ErrorContains(t, err, "test.md:12: output of", "Expected the error to locate the chunk")
+
+		c.ExpectedOutput = []string{}
+		assert.Error(t, c.CheckOutput(), "Expected an empty output block to expect no output")
+
+		c.ExpectedOutput = nil
+		assert.NoError(t, c.CheckOutput(), "Expected a chunk without output block not to be checked")
+	})
+	t.Run("check output ignores chunks that did not run", func(t *testing.T) {
+		c := &chunk.ExecutableChunk{
+			ExpectedOutput: []string{"hello"},
+			Context:        &runnercontext.Context{Cfg: &config.Config{}, RView: view.NewView("mock")},
+		}
+		assert.NoError(t, c.CheckOutput())
+	})
 	t.Run("has output dry run", func(t *testing.T) {
 		testChunk := &chunk.ExecutableChunk{
 			Commands: []*chunk.RunningCommand{},
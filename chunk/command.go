@@ -8,10 +8,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/arkmq-org/markdown-runner/runnercontext"
+	"github.com/arkmq-org/markdown-runner/view"
 )
 
 // RunningCommand represents a command that has been parsed and is ready to be
@@ -36,11 +41,49 @@ type RunningCommand struct {
 	Stderr string
 	// ReturnCode holds the exit code of the command after it has run.
 	ReturnCode int
+	// Duration is the time the command took to execute.
+	Duration time.Duration
 	// IsBash indicates whether the command is a bash script, which requires
 	// special environment variable handling.
 	IsBash bool
-	Ctx    *runnercontext.Context
-	id     string
+	// IsBackground indicates whether the command is the long-lived process of
+	// a background chunk.
+	IsBackground bool
+	// Terminated is set when the runner stopped a background command.
+	Terminated bool
+	// ExpectExit holds the exit codes the command is expected to end with.
+	ExpectExit ExitExpectation
+	Ctx        *runnercontext.Context
+	id         string
+	startTime  time.Time
+	details    view.CommandDetails
+	// the state of a background command, see background.go
+	outputMutex sync.Mutex
+	exited      chan struct{}
+	exitError   error
+	stopped     bool
+	// streams forward the output to the view when it's streamed, see stream.go
+	streams []*lineWriter
+	// expectStderr is the regex the stderr of the command is expected to match
+	expectStderr *regexp.Regexp
+	// statePath is the prefix of the files a bash script dumps its state to
+	statePath string
+	// tempPaths are the files and directories written to execute the command,
+	// removed once it's done
+	tempPaths []string
+	// Interrupted is set when the command got cancelled because the execution
+	// was interrupted.
+	Interrupted bool
+	// deadline is the context the command has to be done within
+	deadline context.Context
+	// interrupt is done once the execution gets interrupted, nil when the
+	// command can't be interrupted
+	interrupt context.Context
+	// the persistent shell the script of the command is fed to, see shell.go
+	shellKey    string
+	scriptPath  string
+	shell       *shellSession
+	shellStatus *exitStatus
 	// Option to pass in a function for user input that will override the one from pterm
 	// this is useful for testing.
 	GetUserInput func(string) (string, error)
@@ -55,6 +98,9 @@ func (command *RunningCommand) InitCommandLabel(chunk *ExecutableChunk) {
 	if chunk.Label != "" {
 		command.CmdPrettyName = chunk.Label
 	}
+	if chunk.Attempt > 1 {
+		command.CmdPrettyName = fmt.Sprintf("%s (attempt %d/%d)", command.CmdPrettyName, chunk.Attempt, chunk.Retries+1)
+	}
 	if command.Ctx.Cfg.Verbose {
 		command.CmdPrettyName = fmt.Sprint(command.CmdPrettyName, " in ", command.Cmd.Dir)
 		if len(command.Env) > 0 {
@@ -100,16 +146,30 @@ func (command *RunningCommand) Start() error {
 		return nil // no-op
 	}
 
-	command.Cmd.Stdout = &command.Outb
-	command.Cmd.Stderr = &command.Errb
+	command.Cmd.Stdout, command.Cmd.Stderr = command.outputWriters()
+	if command.IsBackground {
+		command.Cmd.Stdout = &lockedWriter{mutex: &command.outputMutex, writer: command.Cmd.Stdout}
+		command.Cmd.Stderr = &lockedWriter{mutex: &command.outputMutex, writer: command.Cmd.Stderr}
+	}
+	startInGroup(command.Cmd, command.gracePeriod())
 	if command.Ctx.Cfg.DryRun {
 		return nil
 	}
+	command.startTime = time.Now()
+	if command.shellKey != "" {
+		return command.startInShell()
+	}
 	err := command.Cmd.Start()
 	if err != nil {
+		command.removeTempPaths()
 		command.Ctx.RView.Error(fmt.Sprintf("%s: %s\n", command.CmdPrettyName, err))
+		return err
+	}
+	registerGroup(command.Cmd.Process)
+	if command.IsBackground {
+		command.watchBackground()
 	}
-	return err
+	return nil
 }
 
 // InitializeLogger creates and configures a logger for a command.
@@ -118,12 +178,14 @@ func (command *RunningCommand) Start() error {
 func (command *RunningCommand) InitializeLogger() error {
 	err := command.interactivePrompt()
 	if err != nil {
+		command.removeTempPaths()
 		return err
 	}
 	var spinnerText string = command.CmdPrettyName
 	if command.Ctx.Cfg.Interactive {
 		spinnerText = "executing"
 	}
+	command.Ctx.RView.DescribeCommand(command.id, command.details)
 	return command.Ctx.RView.StartCommand(command.id, spinnerText)
 }
 
@@ -131,6 +193,7 @@ func (command *RunningCommand) InitializeLogger() error {
 // code, stdout, and stderr, and handles environment variable extraction for
 // bash scripts. It returns an error if the command fails.
 func (command *RunningCommand) Wait() error {
+	defer command.removeTempPaths()
 	// don't wait if we're in dryRun mode
 	if command.Ctx.Cfg.DryRun {
 		command.Ctx.RView.DryRunCommand(command.id, command.CmdPrettyName)
@@ -142,14 +205,46 @@ func (command *RunningCommand) Wait() error {
 		command.Ctx.RView.SkipCommand(command.id, command.CmdPrettyName)
 		return nil
 	}
-	// wait for the termination
-	terminatingError := command.Cmd.Wait()
+	// wait for the termination, a background process is already being waited for
+	var terminatingError error
+	if command.IsBackground {
+		<-command.exited
+		terminatingError = command.exitError
+	} else if command.shell != nil {
+		terminatingError = command.waitInShell()
+	} else {
+		terminatingError = waitGroup(command.Cmd)
+	}
+	// the descendants left behind by a successful command held its output open
+	// past the grace period, they got killed
+	if errors.Is(terminatingError, exec.ErrWaitDelay) {
+		terminatingError = nil
+	}
+	command.flushStreams()
+	command.Duration = time.Since(command.startTime)
 	command.Stdout = command.Outb.String()
 	command.Stderr = command.Errb.String()
+	exitCode, _ := command.ExitCode()
+	command.Ctx.RView.CommandExited(command.id, exitCode)
+
+	// the command got cancelled, whatever it ended with
+	if terminatingError != nil && command.isInterrupted() && !command.Terminated {
+		command.Interrupted = true
+		command.Ctx.RView.StopCommand(command.id, false, command.CmdPrettyName+" was interrupted")
+		return fmt.Errorf("%s: %w", command.CmdPrettyName, runnercontext.ErrInterrupted)
+	}
 
-	// handle the output depending on the status of the command
-	if terminatingError != nil {
-		command.Ctx.RView.StopCommand(command.id, false, fmt.Sprintf("stdout:\n%s\nstderr:\n%s\nexit code:%d", command.Outb.String(), command.Errb.String(), command.Cmd.ProcessState.ExitCode()))
+	// handle the output depending on the status of the command, a background
+	// command stopped by the runner is expected to die from it
+	if !command.Terminated {
+		terminatingError = command.checkExit(terminatingError)
+	}
+	if terminatingError != nil && !command.Terminated {
+		message := fmt.Sprintf("stdout:\n%s\nstderr:\n%s\nexit code:%d", command.Outb.String(), command.Errb.String(), exitCode)
+		if !command.ExpectExit.isDefault() || command.expectStderr != nil {
+			message += "\n" + terminatingError.Error()
+		}
+		command.Ctx.RView.StopCommand(command.id, false, message)
 		return terminatingError
 	}
 	command.Ctx.RView.StopCommand(command.id, true, command.CmdPrettyName)
@@ -157,41 +252,10 @@ func (command *RunningCommand) Wait() error {
 	// During a bash runtime the user might want to export new variables or unset existing ones.
 	// Our job here is to recover them to build the new environment for the next chunk
 	if command.IsBash {
-		stoudtLines := strings.Split(command.Stdout, "\n")
-		var newLines []string
-		extractVariables := false
-		var bashEnvVars []string
-
-		for _, line := range stoudtLines {
-			// the environment gets separated by the output from a special string
-			if line == "### ENV ###" {
-				extractVariables = true
-			}
-			// then all of it is variables and can be added to the env
-			if extractVariables {
-				parts := strings.Split(line, "=")
-				if len(parts) > 1 {
-					bashEnvVars = append(bashEnvVars, line)
-				}
-			} else {
-				// if not it's output we want to keep for the user
-				newLines = append(newLines, line)
-			}
-		}
-
-		// This includes all environment variables that should be available to subsequent chunks.
-		command.Ctx.Cfg.Env = bashEnvVars
-
-		if len(newLines) > 0 {
-			// Remove the trailing empty line that precedes the ENV marker (added by our \n in the echo)
-			// This prevents an extra blank line when the ENV section is stripped
-			if len(newLines) > 0 && newLines[len(newLines)-1] == "" {
-				newLines = newLines[:len(newLines)-1]
-			}
-			command.Stdout = strings.Join(newLines, "\n")
-			command.Stdout = command.Stdout + "\n"
-		} else {
-			command.Stdout = ""
+		err := command.restoreBashState()
+		if err != nil {
+			command.Ctx.RView.Error(err.Error())
+			return err
 		}
 	}
 
@@ -207,12 +271,59 @@ func (command *RunningCommand) Wait() error {
 	return nil
 }
 
-// Kill forcefully terminates the command's process. It's used to clean up
+// Kill terminates the command's process along with its descendants, with
+// SIGTERM then SIGKILL once the grace period is over. It's used to clean up
 // running processes when a stage fails.
-// It returns an error if the process cannot be killed.
+// It returns an error if the processes cannot be signaled.
 func (command *RunningCommand) Kill() error {
 	command.Ctx.RView.KillCommand(command.id, command.CmdPrettyName)
-	return command.Cmd.Process.Kill()
+	defer command.removeTempPaths()
+	if command.shell != nil {
+		command.shell.terminate()
+		return nil
+	}
+	return command.stop()
+}
+
+// removeTempPaths removes the files and directories written to execute the
+// command.
+func (command *RunningCommand) removeTempPaths() {
+	for _, tempPath := range command.tempPaths {
+		os.RemoveAll(tempPath)
+	}
+	command.tempPaths = nil
+}
+
+// isInterrupted returns whether the command is interruptible and the
+// execution got interrupted.
+func (command *RunningCommand) isInterrupted() bool {
+	return command.interrupt != nil && command.interrupt.Err() != nil
+}
+
+// startInShell feeds the script of the command to its persistent shell,
+// starting the shell if needed.
+func (command *RunningCommand) startInShell() error {
+	var err error
+	command.shell, err = shellFor(command.Ctx, command.shellKey, command.Cmd.Dir)
+	if err == nil {
+		err = command.shell.run(command, command.scriptPath, command.statePath)
+	}
+	if err != nil {
+		command.shell = nil
+		command.Ctx.RView.Error(fmt.Sprintf("%s: %s\n", command.CmdPrettyName, err))
+		return err
+	}
+	return nil
+}
+
+// waitInShell waits for the script of the command to be done in its
+// persistent shell, returning a shellExitError if it failed.
+func (command *RunningCommand) waitInShell() error {
+	command.shellStatus = command.shell.wait(command.deadline)
+	if command.shellStatus.exited && command.shellStatus.code == 0 {
+		return nil
+	}
+	return &shellExitError{status: command.shellStatus}
 }
 
 // Execute runs a command and waits for it to complete. It's a convenience method that calls Start and then Wait.
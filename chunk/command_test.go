@@ -1,10 +1,13 @@
 package chunk
 
 import (
+	"os"
 	"os/exec"
+	"path/filepath"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/arkmq-org/markdown-runner/config"
 	"github.com/arkmq-org/markdown-runner/runnercontext"
@@ -37,10 +40,7 @@ func TestRunningCommand(t *testing.T) {
 		// Happy path
 		ui := view.NewView("mock")
 		chunk := ExecutableChunk{
-			Context: &runnercontext.Context{
-				Cfg:   cfg,
-				RView: ui,
-			},
+			Context: runnercontext.NewContext(cfg, ui),
 		}
 		cmdStr := "echo 'hello world'"
 		command, err := chunk.AddCommandToExecute(cmdStr, tmpDirs)
@@ -52,10 +52,7 @@ func TestRunningCommand(t *testing.T) {
 
 		// Failure path
 		chunk = ExecutableChunk{
-			Context: &runnercontext.Context{
-				Cfg:   cfg,
-				RView: ui,
-			},
+			Context: runnercontext.NewContext(cfg, ui),
 		}
 		cmdStr = "nonexistent-command"
 		command, err = chunk.AddCommandToExecute(cmdStr, tmpDirs)
@@ -94,10 +91,7 @@ func TestRunningCommand(t *testing.T) {
 		chunk := ExecutableChunk{
 			Runtime: "bash",
 			Content: []string{"export NEW_VAR=new_value"},
-			Context: &runnercontext.Context{
-				Cfg:   cfg,
-				RView: ui,
-			},
+			Context: runnercontext.NewContext(cfg, ui),
 		}
 		err := chunk.PrepareForExecution(tmpDirs)
 		assert.NoError(t, err, "Expected no error when preparing chunk for execution")
@@ -106,28 +100,28 @@ func TestRunningCommand(t *testing.T) {
 		assert.NoError(t, err, "Expected no error when executing bash chunk")
 
 		// Verify original environment variables are preserved
-		foundOriginal := slices.ContainsFunc(chunk.Context.Cfg.Env, func(env string) bool {
+		foundOriginal := slices.ContainsFunc(chunk.Context.Env, func(env string) bool {
 			return env == "ORIGINAL_VAR=original_value"
 		})
 		assert.True(t, foundOriginal, "Expected ORIGINAL_VAR to be preserved")
 
-		foundPath := slices.ContainsFunc(chunk.Context.Cfg.Env, func(env string) bool {
+		foundPath := slices.ContainsFunc(chunk.Context.Env, func(env string) bool {
 			return strings.HasPrefix(env, "PATH=")
 		})
 		assert.True(t, foundPath, "Expected PATH to be preserved")
 
-		foundHome := slices.ContainsFunc(chunk.Context.Cfg.Env, func(env string) bool {
+		foundHome := slices.ContainsFunc(chunk.Context.Env, func(env string) bool {
 			return env == "HOME=/home/test"
 		})
 		assert.True(t, foundHome, "Expected HOME to be preserved")
 
-		foundWorkingDir := slices.ContainsFunc(chunk.Context.Cfg.Env, func(env string) bool {
+		foundWorkingDir := slices.ContainsFunc(chunk.Context.Env, func(env string) bool {
 			return env == "WORKING_DIR=/test/dir"
 		})
 		assert.True(t, foundWorkingDir, "Expected WORKING_DIR to be preserved")
 
 		// Verify new variable was added
-		foundNew := slices.ContainsFunc(chunk.Context.Cfg.Env, func(env string) bool {
+		foundNew := slices.ContainsFunc(chunk.Context.Env, func(env string) bool {
 			return strings.HasPrefix(env, "NEW_VAR=")
 		})
 		assert.True(t, foundNew, "Expected NEW_VAR to be added")
@@ -150,10 +144,7 @@ func TestRunningCommand(t *testing.T) {
 		chunk := ExecutableChunk{
 			Runtime: "bash",
 			Content: []string{"unset REMOVE_VAR"},
-			Context: &runnercontext.Context{
-				Cfg:   cfg,
-				RView: ui,
-			},
+			Context: runnercontext.NewContext(cfg, ui),
 		}
 		err := chunk.PrepareForExecution(tmpDirs)
 		assert.NoError(t, err, "Expected no error when preparing chunk for execution")
@@ -162,18 +153,18 @@ func TestRunningCommand(t *testing.T) {
 		assert.NoError(t, err, "Expected no error when executing bash chunk")
 
 		// Verify the targeted variable was unset
-		foundRemoved := slices.ContainsFunc(chunk.Context.Cfg.Env, func(env string) bool {
+		foundRemoved := slices.ContainsFunc(chunk.Context.Env, func(env string) bool {
 			return strings.HasPrefix(env, "REMOVE_VAR=")
 		})
 		assert.False(t, foundRemoved, "Expected REMOVE_VAR to be removed after unset")
 
 		// Verify other variables are still preserved
-		foundKeep := slices.ContainsFunc(chunk.Context.Cfg.Env, func(env string) bool {
+		foundKeep := slices.ContainsFunc(chunk.Context.Env, func(env string) bool {
 			return env == "KEEP_VAR=keep_this"
 		})
 		assert.True(t, foundKeep, "Expected KEEP_VAR to be preserved after unset")
 
-		foundPath := slices.ContainsFunc(chunk.Context.Cfg.Env, func(env string) bool {
+		foundPath := slices.ContainsFunc(chunk.Context.Env, func(env string) bool {
 			return strings.HasPrefix(env, "PATH=")
 		})
 		assert.True(t, foundPath, "Expected PATH to be preserved after unset")
@@ -196,10 +187,7 @@ func TestRunningCommand(t *testing.T) {
 		chunk := ExecutableChunk{
 			Runtime: "bash",
 			Content: []string{"unset HOME"},
-			Context: &runnercontext.Context{
-				Cfg:   cfg,
-				RView: ui,
-			},
+			Context: runnercontext.NewContext(cfg, ui),
 		}
 		err := chunk.PrepareForExecution(tmpDirs)
 		assert.NoError(t, err, "Expected no error when preparing chunk for execution")
@@ -208,18 +196,18 @@ func TestRunningCommand(t *testing.T) {
 		assert.NoError(t, err, "Expected no error when executing bash chunk")
 
 		// Verify HOME was unset
-		foundHome := slices.ContainsFunc(chunk.Context.Cfg.Env, func(env string) bool {
+		foundHome := slices.ContainsFunc(chunk.Context.Env, func(env string) bool {
 			return strings.HasPrefix(env, "HOME=")
 		})
 		assert.False(t, foundHome, "Expected HOME to be removed after unset")
 
 		// Verify other variables are still preserved
-		foundKeep := slices.ContainsFunc(chunk.Context.Cfg.Env, func(env string) bool {
+		foundKeep := slices.ContainsFunc(chunk.Context.Env, func(env string) bool {
 			return env == "KEEP_VAR=keep_this"
 		})
 		assert.True(t, foundKeep, "Expected KEEP_VAR to be preserved after unsetting HOME")
 
-		foundPath := slices.ContainsFunc(chunk.Context.Cfg.Env, func(env string) bool {
+		foundPath := slices.ContainsFunc(chunk.Context.Env, func(env string) bool {
 			return strings.HasPrefix(env, "PATH=")
 		})
 		assert.True(t, foundPath, "Expected PATH to be preserved after unsetting HOME")
@@ -244,6 +232,40 @@ func TestRunningCommand(t *testing.T) {
 		assert.NoError(t, err)
 	})
 
+	t.Run("kill the process groups still running", func(t *testing.T) {
+		chunk := ExecutableChunk{
+			Context: &runnercontext.Context{
+				Cfg:   &config.Config{MinutesToTimeout: 1},
+				RView: view.NewView("mock"),
+			},
+		}
+		runningCmd, err := chunk.AddCommandToExecute("sleep 30", make(map[string]string))
+		assert.NoError(t, err)
+		assert.NoError(t, runningCmd.InitializeLogger())
+		assert.NoError(t, runningCmd.Start())
+		start := time.Now()
+		KillProcessGroups()
+		assert.Error(t, runningCmd.Wait(), "Expected the command to be killed")
+		assert.Less(t, time.Since(start), 5*time.Second)
+		assert.NotContains(t, groups.timers, runningCmd.Cmd.Process.Pid, "Expected the group to be forgotten once waited for")
+	})
+
+	t.Run("stop a group that was waited for", func(t *testing.T) {
+		chunk := ExecutableChunk{
+			Context: &runnercontext.Context{
+				Cfg:   &config.Config{MinutesToTimeout: 1},
+				RView: view.NewView("mock"),
+			},
+		}
+		runningCmd, err := chunk.AddCommandToExecute("true", make(map[string]string))
+		assert.NoError(t, err)
+		assert.NoError(t, runningCmd.InitializeLogger())
+		assert.NoError(t, runningCmd.Start())
+		assert.NoError(t, runningCmd.Wait())
+		assert.ErrorIs(t, stopGroup(runningCmd.Cmd.Process, time.Millisecond), os.ErrProcessDone,
+			"Expected the pid of the group, which may be reused, not to be signaled")
+	})
+
 	t.Run("start error", func(t *testing.T) {
 		ui := view.NewView("mock")
 		runningCmd := &RunningCommand{
@@ -360,10 +382,7 @@ func TestRunningCommand(t *testing.T) {
 		chunk := ExecutableChunk{
 			Runtime: "bash",
 			Content: []string{"echo -n 'TEST'"},
-			Context: &runnercontext.Context{
-				Cfg:   cfg,
-				RView: ui,
-			},
+			Context: runnercontext.NewContext(cfg, ui),
 		}
 		err := chunk.PrepareForExecution(tmpDirs)
 		assert.NoError(t, err, "Expected no error when preparing chunk for execution")
@@ -371,14 +390,13 @@ func TestRunningCommand(t *testing.T) {
 		err = chunk.ExecuteSequential()
 		assert.NoError(t, err, "Expected no error when executing bash chunk")
 
-		// Verify the output is just "TEST\n" without the ENV marker appended to it
-		// The trailing \n is added by the command processing logic
-		assert.Equal(t, "TEST\n", chunk.Commands[0].Stdout, "Expected stdout to be 'TEST\\n' without ENV marker concatenated")
-		// Crucially, verify it's NOT "TEST### ENV ###" which was the bug
-		assert.NotContains(t, chunk.Commands[0].Stdout, "### ENV ###", "Expected ENV marker to be stripped from output")
+		// Verify the output is just "TEST", the environment being dumped away from it
+		assert.Equal(t, "TEST", chunk.Commands[0].Stdout, "Expected stdout to be 'TEST' without the environment")
+		// The trailing \n is added when the output of the chunk is gathered
+		assert.Equal(t, "TEST\n", chunk.Output())
 
 		// Verify ENV section was still extracted (should have environment variables)
-		assert.NotEmpty(t, chunk.Context.Cfg.Env, "Expected environment variables to be extracted")
+		assert.NotEmpty(t, chunk.Context.Env, "Expected environment variables to be extracted")
 	})
 
 	t.Run("bash env extraction with normal echo command", func(t *testing.T) {
@@ -391,10 +409,7 @@ func TestRunningCommand(t *testing.T) {
 		chunk := ExecutableChunk{
 			Runtime: "bash",
 			Content: []string{"echo 'NORMAL'"},
-			Context: &runnercontext.Context{
-				Cfg:   cfg,
-				RView: ui,
-			},
+			Context: runnercontext.NewContext(cfg, ui),
 		}
 		err := chunk.PrepareForExecution(tmpDirs)
 		assert.NoError(t, err, "Expected no error when preparing chunk for execution")
@@ -406,6 +421,66 @@ func TestRunningCommand(t *testing.T) {
 		assert.Equal(t, "NORMAL\n", chunk.Commands[0].Stdout, "Expected stdout to be 'NORMAL\\n' without extra blank lines")
 
 		// Verify ENV section was still extracted
-		assert.NotEmpty(t, chunk.Context.Cfg.Env, "Expected environment variables to be extracted")
+		assert.NotEmpty(t, chunk.Context.Env, "Expected environment variables to be extracted")
+	})
+
+	t.Run("bash env extraction with no output", func(t *testing.T) {
+		// A bash chunk printing nothing must not be seen as having an output
+		tmpDirs := make(map[string]string)
+
+		cfg := &config.Config{MinutesToTimeout: 1, Env: []string{}}
+		ui := view.NewView("mock")
+
+		chunk := ExecutableChunk{
+			Runtime: "bash",
+			Content: []string{"true"},
+			Context: runnercontext.NewContext(cfg, ui),
+		}
+		err := chunk.PrepareForExecution(tmpDirs)
+		assert.NoError(t, err, "Expected no error when preparing chunk for execution")
+
+		err = chunk.ExecuteSequential()
+		assert.NoError(t, err, "Expected no error when executing bash chunk")
+
+		assert.Equal(t, "", chunk.Commands[0].Stdout, "Expected stdout to be empty")
+		assert.False(t, chunk.HasOutput(), "Expected the chunk to have no output")
+	})
+
+	t.Run("bash state hand-off", func(t *testing.T) {
+		cfg := &config.Config{MinutesToTimeout: 1, Env: []string{"PATH=/usr/bin:/bin", "UNSET_ME=1"}}
+		ctx := runnercontext.NewContext(cfg, view.NewView("mock"))
+		rootDir := t.TempDir()
+		run := func(content ...string) *ExecutableChunk {
+			chunk := &ExecutableChunk{Runtime: "bash", RootDir: rootDir, Content: content, Context: ctx}
+			assert.NoError(t, chunk.PrepareForExecution(make(map[string]string)))
+			assert.NoError(t, chunk.ExecuteSequential())
+			return chunk
+		}
+
+		first := run(
+			"export CERT=$'-----BEGIN-----\\nA=B\\n-----END-----'",
+			"unset UNSET_ME",
+			"greet() { echo \"hello $1\"; }",
+			"alias shout='echo HEY'",
+			"echo '### ENV ###'",
+			"echo 'SPOOFED=1'",
+		)
+		assert.Equal(t, "### ENV ###\nSPOOFED=1\n", first.Commands[0].Stdout, "Expected the output to be kept as is")
+		assert.Contains(t, ctx.Env, "CERT=-----BEGIN-----\nA=B\n-----END-----", "Expected multi-line values to be kept")
+		assert.NotContains(t, ctx.Env, "SPOOFED=1", "Expected the output not to be mistaken for the environment")
+		assert.False(t, slices.ContainsFunc(ctx.Env, func(env string) bool {
+			return strings.HasPrefix(env, "UNSET_ME=")
+		}), "Expected the unset variable to be removed")
+
+		second := run(
+			"[ \"$CERT\" = $'-----BEGIN-----\\nA=B\\n-----END-----' ]",
+			"greet world",
+			"shout",
+		)
+		assert.Equal(t, "hello world\nHEY\n", second.Commands[0].Stdout, "Expected the functions and aliases to be restored")
+
+		leftovers, err := filepath.Glob(filepath.Join(rootDir, "*.env"))
+		assert.NoError(t, err)
+		assert.Empty(t, leftovers, "Expected the state files to be removed once read")
 	})
 }
@@ -0,0 +1,172 @@
+// Package chunk provides the core data structures for the markdown runner. It
+// defines the ExecutableChunk, which represents a runnable code block, and the
+// RunningCommand, which represents a single command to be executed.
+package chunk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ExitExpectation describes the exit codes a command is expected to end with.
+// The zero value expects the command to succeed with the exit code 0.
+type ExitExpectation struct {
+	// Codes are the accepted exit codes.
+	Codes []int
+	// NonZero accepts any exit code but 0.
+	NonZero bool
+}
+
+// UnmarshalJSON accepts a single exit code, a list of exit codes or the
+// "nonzero" string.
+func (expectation *ExitExpectation) UnmarshalJSON(data []byte) error {
+	var code int
+	if err := json.Unmarshal(data, &code); err == nil {
+		*expectation = ExitExpectation{Codes: []int{code}}
+		return nil
+	}
+	var codes []int
+	if err := json.Unmarshal(data, &codes); err == nil {
+		if len(codes) == 0 {
+			return errors.New("expect_exit requires at least one exit code")
+		}
+		*expectation = ExitExpectation{Codes: codes}
+		return nil
+	}
+	var raw string
+	if err := json.Unmarshal(data, &raw); err == nil && raw == "nonzero" {
+		*expectation = ExitExpectation{NonZero: true}
+		return nil
+	}
+	return fmt.Errorf("invalid expect_exit %s: use an exit code, a list of exit codes or \"nonzero\"", string(data))
+}
+
+// isDefault reports whether the expectation is the default one, a success.
+func (expectation ExitExpectation) isDefault() bool {
+	return !expectation.NonZero && len(expectation.Codes) == 0
+}
+
+// Matches reports whether the exit code is an expected one.
+func (expectation ExitExpectation) Matches(exitCode int) bool {
+	if expectation.NonZero {
+		return exitCode != 0
+	}
+	if len(expectation.Codes) == 0 {
+		return exitCode == 0
+	}
+	return slices.Contains(expectation.Codes, exitCode)
+}
+
+// String describes the expected exit codes for the error messages.
+func (expectation ExitExpectation) String() string {
+	if expectation.NonZero {
+		return "a nonzero exit code"
+	}
+	if len(expectation.Codes) == 0 {
+		return "exit code 0"
+	}
+	if len(expectation.Codes) == 1 {
+		return "exit code " + strconv.Itoa(expectation.Codes[0])
+	}
+	var codes []string
+	for _, code := range expectation.Codes {
+		codes = append(codes, strconv.Itoa(code))
+	}
+	return "one of the exit codes " + strings.Join(codes, ", ")
+}
+
+// exitStatus describes how a command ended.
+type exitStatus struct {
+	// exited is false when the command was killed by a signal
+	exited bool
+	// code is the exit code of the command, -1 when it was killed
+	code int
+	// description is the status as written by os.ProcessState
+	description string
+}
+
+// status returns how the command ended, nil if it didn't run to completion.
+func (command *RunningCommand) status() *exitStatus {
+	if command.shellStatus != nil {
+		return command.shellStatus
+	}
+	state := command.Cmd.ProcessState
+	if state == nil {
+		return nil
+	}
+	return &exitStatus{exited: state.Exited(), code: state.ExitCode(), description: state.String()}
+}
+
+// ExitCode returns the exit code of the command, -1 if it was killed, and
+// whether it ran to completion at all.
+func (command *RunningCommand) ExitCode() (int, bool) {
+	state := command.status()
+	if state == nil {
+		return -1, false
+	}
+	return state.code, true
+}
+
+// shellExitError is the counterpart of exec.ExitError for the chunks executed
+// by a persistent shell, returned when they end with a non-zero status.
+type shellExitError struct {
+	status *exitStatus
+}
+
+// Error implements error
+func (err *shellExitError) Error() string {
+	return err.status.description
+}
+
+// isExitError tells whether err comes from a command that ran and failed.
+func isExitError(err error) bool {
+	var exitError *exec.ExitError
+	var shellError *shellExitError
+	return errors.As(err, &exitError) || errors.As(err, &shellError)
+}
+
+// checkExit tells whether a command that exited ended as expected, with an
+// expected exit code and stderr. It returns nil if that's the case, the reason
+// why it isn't otherwise.
+//
+// waitErr is the error the command was waited for with, if any.
+func (command *RunningCommand) checkExit(waitErr error) error {
+	state := command.status()
+	// the command could not be started
+	if state == nil {
+		return waitErr
+	}
+	// a command killed by a signal, such as a timeout, never ends as expected
+	if !state.exited {
+		if waitErr == nil {
+			waitErr = errors.New(state.description)
+		}
+		return waitErr
+	}
+	if !command.ExpectExit.Matches(state.code) {
+		if waitErr == nil {
+			waitErr = errors.New(state.description)
+		}
+		// keep the error as is when the command was simply expected to succeed
+		if command.ExpectExit.isDefault() {
+			return waitErr
+		}
+		return fmt.Errorf("%w, expected %s", waitErr, command.ExpectExit)
+	}
+	if command.expectStderr != nil && !command.expectStderr.MatchString(command.Stderr) {
+		return fmt.Errorf("stderr doesn't match '%s'", command.expectStderr)
+	}
+	return nil
+}
+
+// HasSucceeded reports whether the command ended as expected, or was stopped by
+// the runner while running in the background. It must only be called once the
+// command has exited.
+func (command *RunningCommand) HasSucceeded() bool {
+	return command.Terminated || command.checkExit(nil) == nil
+}
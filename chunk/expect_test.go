@@ -0,0 +1,109 @@
+package chunk_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/arkmq-org/markdown-runner/chunk"
+	"github.com/arkmq-org/markdown-runner/config"
+	"github.com/arkmq-org/markdown-runner/runnercontext"
+	"github.com/arkmq-org/markdown-runner/view"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitExpectation(t *testing.T) {
+	t.Run("unmarshal", func(t *testing.T) {
+		testCases := []struct {
+			name     string
+			raw      string
+			expected chunk.ExitExpectation
+			isError  bool
+		}{
+			{name: "single code", raw: `2`, expected: chunk.ExitExpectation{Codes: []int{2}}},
+			{name: "list of codes", raw: `[1, 2]`, expected: chunk.ExitExpectation{Codes: []int{1, 2}}},
+			{name: "nonzero", raw: `"nonzero"`, expected: chunk.ExitExpectation{NonZero: true}},
+			{name: "empty list", raw: `[]`, isError: true},
+			{name: "unknown string", raw: `"failure"`, isError: true},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				var expectation chunk.ExitExpectation
+				err := json.Unmarshal([]byte(tc.raw), &expectation)
+				if tc.isError {
+					assert.Error(t, err)
+					return
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, expectation)
+			})
+		}
+	})
+	t.Run("matches", func(t *testing.T) {
+		assert.True(t, chunk.ExitExpectation{}.Matches(0))
+		assert.False(t, chunk.ExitExpectation{}.Matches(1))
+		assert.True(t, chunk.ExitExpectation{NonZero: true}.Matches(3))
+		assert.False(t, chunk.ExitExpectation{NonZero: true}.Matches(0))
+		assert.True(t, chunk.ExitExpectation{Codes: []int{1, 2}}.Matches(2))
+		assert.False(t, chunk.ExitExpectation{Codes: []int{1, 2}}.Matches(0))
+	})
+	t.Run("execution", func(t *testing.T) {
+		testCases := []struct {
+			name         string
+			content      string
+			expectExit   chunk.ExitExpectation
+			expectStderr string
+			isError      bool
+		}{
+			{name: "expected code", content: "exit 3", expectExit: chunk.ExitExpectation{Codes: []int{3}}},
+			{name: "unexpected code", content: "exit 4", expectExit: chunk.ExitExpectation{Codes: []int{3}}, isError: true},
+			{name: "unexpected success", content: "true", expectExit: chunk.ExitExpectation{NonZero: true}, isError: true},
+			{name: "nonzero", content: "exit 1", expectExit: chunk.ExitExpectation{NonZero: true}},
+			{name: "expected stderr", content: "echo 'no such file' >&2; exit 1", expectExit: chunk.ExitExpectation{NonZero: true}, expectStderr: "no such"},
+			{name: "unexpected stderr", content: "echo 'permission denied' >&2; exit 1", expectExit: chunk.ExitExpectation{NonZero: true}, expectStderr: "no such", isError: true},
+			{name: "stderr of a success", content: "echo 'warning' >&2", expectStderr: "^warning"},
+			{name: "timeout is never expected", content: "sleep 5", expectExit: chunk.ExitExpectation{NonZero: true}, isError: true},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				c := &chunk.ExecutableChunk{
+					Runtime:      "bash",
+					RootDir:      t.TempDir(),
+					Content:      []string{tc.content},
+					Timeout:      "200ms",
+					ExpectExit:   tc.expectExit,
+					ExpectStderr: tc.expectStderr,
+					Context: &runnercontext.Context{
+						Cfg:   &config.Config{MinutesToTimeout: 1},
+						RView: view.NewView("mock"),
+					},
+				}
+				assert.NoError(t, c.ParseExecutionPolicy())
+				assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+				err := c.ExecuteSequential()
+				if tc.isError {
+					assert.Error(t, err)
+					assert.False(t, c.HasExecutedCorrectly())
+				} else {
+					assert.NoError(t, err)
+					assert.True(t, c.HasExecutedCorrectly())
+				}
+			})
+		}
+	})
+	t.Run("it should keep the environment of a bash chunk expected to fail", func(t *testing.T) {
+		ctx := runnercontext.NewContext(&config.Config{MinutesToTimeout: 1, Env: []string{"KEPT=1"}}, view.NewView("mock"))
+		c := &chunk.ExecutableChunk{
+			Runtime:    "bash",
+			RootDir:    t.TempDir(),
+			Content:    []string{"exit 1"},
+			ExpectExit: chunk.ExitExpectation{NonZero: true},
+			Context:    ctx,
+		}
+		assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+		assert.NoError(t, c.ExecuteSequential())
+		assert.Equal(t, []string{"KEPT=1"}, ctx.Env)
+	})
+	t.Run("invalid stderr regex", func(t *testing.T) {
+		assert.Error(t, (&chunk.ExecutableChunk{ExpectStderr: "("}).ParseExecutionPolicy())
+	})
+}
@@ -0,0 +1,135 @@
+// Package chunk provides the core data structures for the markdown runner. It
+// defines the ExecutableChunk, which represents a runnable code block, and the
+// RunningCommand, which represents a single command to be executed.
+package chunk
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// interpretedRuntime describes a runtime writing the content of the chunk to a
+// script executed by an interpreter.
+type interpretedRuntime struct {
+	CommandRuntime
+	// interpreter is the command executing the script
+	interpreter string
+	// extension is the extension of the script file
+	extension string
+	// prelude starts the script, for it to fail at the first error when the
+	// language doesn't already. Not every sh supports pipefail.
+	prelude string
+}
+
+// interpretedRuntimes are the runtimes executing the content of the chunk as
+// a script file, by runtime name.
+var interpretedRuntimes = map[string]interpretedRuntime{
+	"sh":      {interpreter: "sh", extension: ".sh", prelude: "set -eu\n(set -o pipefail) 2>/dev/null && set -o pipefail\n"},
+	"python3": {interpreter: "python3", extension: ".py"},
+	"node":    {interpreter: "node", extension: ".js"},
+}
+
+const (
+	// goChunkModule is the path of the temporary module of a go chunk
+	goChunkModule = "chunk"
+	// goChunkVersion is the go version of the temporary module of a go chunk
+	goChunkVersion = "1.21"
+)
+
+// content returns the content of the chunk as a single string.
+func (chunk *ExecutableChunk) content() string {
+	return strings.Join(chunk.Content, "\n") + "\n"
+}
+
+// Prepare implements Runtime. It writes the content of the chunk to a script
+// file in the runtime directory and creates the RunningCommand executing it.
+// The script is removed once the command is done.
+func (runtime interpretedRuntime) Prepare(chunk *ExecutableChunk, tmpDirs map[string]string) error {
+	script := "./" + uuid.New().String() + runtime.extension
+	command, err := chunk.AddCommandToExecute(runtime.interpreter+" "+script, tmpDirs)
+	if err != nil {
+		return err
+	}
+	scriptPath := path.Join(command.Cmd.Dir, script)
+	command.tempPaths = append(command.tempPaths, scriptPath)
+	return os.WriteFile(scriptPath, []byte(runtime.prelude+chunk.content()), 0o660)
+}
+
+// goRuntime executes the content of the chunk, a main package, with go run.
+type goRuntime struct {
+	CommandRuntime
+}
+
+// Prepare implements Runtime. The content of the chunk is written to a
+// temporary module next to a go.work file so that it can be executed with go
+// run while the runtime directory remains the working directory of the
+// program. The module is removed once the command is done.
+func (goRuntime) Prepare(chunk *ExecutableChunk, tmpDirs map[string]string) error {
+	command, err := chunk.AddCommandToExecute("go run "+goChunkModule, tmpDirs)
+	if err != nil {
+		return err
+	}
+	moduleDir, err := filepath.Abs(path.Join(command.Cmd.Dir, uuid.New().String()))
+	if err != nil {
+		return err
+	}
+	err = os.Mkdir(moduleDir, 0o770)
+	if err != nil {
+		return err
+	}
+	command.tempPaths = append(command.tempPaths, moduleDir)
+	files := map[string]string{
+		"go.mod":  "module " + goChunkModule + "\n\ngo " + goChunkVersion + "\n",
+		"go.work": "go " + goChunkVersion + "\n\nuse .\n",
+		"main.go": chunk.content(),
+	}
+	for name, content := range files {
+		err = os.WriteFile(path.Join(moduleDir, name), []byte(content), 0o660)
+		if err != nil {
+			return err
+		}
+	}
+	command.Cmd.Env = append(command.Cmd.Env, "GOWORK="+path.Join(moduleDir, "go.work"))
+	// the -mod flag is rejected in workspace mode
+	if flags, found := lookupEnv(command.Cmd.Env, "GOFLAGS"); found {
+		var kept []string
+		for _, flag := range strings.Fields(flags) {
+			if !strings.HasPrefix(flag, "-mod=") {
+				kept = append(kept, flag)
+			}
+		}
+		command.Cmd.Env = append(command.Cmd.Env, "GOFLAGS="+strings.Join(kept, " "))
+	}
+	return nil
+}
+
+// execRuntime pipes the content of the chunk to the interpreter of the chunk,
+// such as "ruby -".
+type execRuntime struct {
+	CommandRuntime
+}
+
+// Prepare implements Runtime
+func (execRuntime) Prepare(chunk *ExecutableChunk, tmpDirs map[string]string) error {
+	command, err := chunk.AddCommandToExecute(chunk.Interpreter, tmpDirs)
+	if err != nil {
+		return err
+	}
+	command.Cmd.Stdin = strings.NewReader(chunk.content())
+	return nil
+}
+
+// lookupEnv returns the value of a variable of the environment, the last one
+// winning like with exec.Cmd, and whether it's set.
+func lookupEnv(env []string, key string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if value, found := strings.CutPrefix(env[i], key+"="); found {
+			return value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,139 @@
+// Package chunk provides the core data structures for the markdown runner. It
+// defines the ExecutableChunk, which represents a runnable code block, and the
+// RunningCommand, which represents a single command to be executed.
+package chunk
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// normalizer rewrites the parts of an output matching its regex with its
+// replacement, it's used to remove volatile content such as timestamps.
+type normalizer struct {
+	regex       *regexp.Regexp
+	replacement string
+}
+
+// builtinNormalizers are the normalizers that can be referenced by name.
+var builtinNormalizers = map[string]normalizer{
+	"timestamp": {
+		regex:       regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`),
+		replacement: "<timestamp>",
+	},
+	"uuid": {
+		regex:       regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`),
+		replacement: "<uuid>",
+	},
+	// the runner creates its temporary directories with os.MkdirTemp("/tmp", "*")
+	"tmpdir": {
+		regex:       regexp.MustCompile(`/tmp/[0-9]+\b`),
+		replacement: "<tmpdir>",
+	},
+	"ansi": {
+		regex:       regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]`),
+		replacement: "",
+	},
+	"trailing_whitespace": {
+		regex:       regexp.MustCompile(`(?m)[ \t]+$`),
+		replacement: "",
+	},
+}
+
+// NormalizerSpec describes a normalizer to apply on the output of a chunk. It
+// is either the Name of a builtin normalizer or a custom Regex with its
+// Replacement.
+type NormalizerSpec struct {
+	Name        string `json:"-"`
+	Regex       string `json:"regex"`
+	Replacement string `json:"replacement"`
+}
+
+// UnmarshalJSON accepts either a string, naming a builtin normalizer or holding
+// a custom "regex=>replacement" rule, or an object with a regex and a
+// replacement.
+func (spec *NormalizerSpec) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err == nil {
+		parsed, err := ParseNormalizerSpec(raw)
+		if err != nil {
+			return err
+		}
+		*spec = *parsed
+		return nil
+	}
+	type plainSpec NormalizerSpec
+	return json.Unmarshal(data, (*plainSpec)(spec))
+}
+
+// ParseNormalizerSpec parses a normalizer given as a string. The string is
+// either the name of a builtin normalizer or a custom rule written as
+// "regex=>replacement".
+func ParseNormalizerSpec(raw string) (*NormalizerSpec, error) {
+	if regex, replacement, found := strings.Cut(raw, "=>"); found {
+		return &NormalizerSpec{Regex: regex, Replacement: replacement}, nil
+	}
+	if _, exists := builtinNormalizers[raw]; !exists {
+		return nil, fmt.Errorf("unknown normalizer '%s', use one of %s or a 'regex=>replacement' rule", raw, strings.Join(BuiltinNormalizerNames(), ", "))
+	}
+	return &NormalizerSpec{Name: raw}, nil
+}
+
+// BuiltinNormalizerNames returns the sorted names of the builtin normalizers.
+func BuiltinNormalizerNames() []string {
+	return slices.Sorted(maps.Keys(builtinNormalizers))
+}
+
+// compile turns the spec into a normalizer ready to be applied.
+func (spec *NormalizerSpec) compile() (normalizer, error) {
+	if spec.Name != "" {
+		builtin, exists := builtinNormalizers[spec.Name]
+		if !exists {
+			return normalizer{}, fmt.Errorf("unknown normalizer '%s'", spec.Name)
+		}
+		return builtin, nil
+	}
+	regex, err := regexp.Compile(spec.Regex)
+	if err != nil {
+		return normalizer{}, fmt.Errorf("invalid normalizer regex '%s': %w", spec.Regex, err)
+	}
+	return normalizer{regex: regex, replacement: spec.Replacement}, nil
+}
+
+// CompileNormalizers compiles the normalizers coming from the configuration
+// followed by the ones declared in the chunk metadata. It must be called before
+// the output of the chunk gets written or compared for them to be applied.
+// It returns an error if a normalizer is unknown or has an invalid regex.
+func (chunk *ExecutableChunk) CompileNormalizers() error {
+	var specs []NormalizerSpec
+	for _, raw := range chunk.Context.Cfg.Normalize {
+		spec, err := ParseNormalizerSpec(raw)
+		if err != nil {
+			return err
+		}
+		specs = append(specs, *spec)
+	}
+	specs = append(specs, chunk.Normalize...)
+	chunk.normalizers = nil
+	for _, spec := range specs {
+		compiled, err := spec.compile()
+		if err != nil {
+			return err
+		}
+		chunk.normalizers = append(chunk.normalizers, compiled)
+	}
+	return nil
+}
+
+// normalizeOutput applies all the compiled normalizers of the chunk, in order,
+// to the given output.
+func (chunk *ExecutableChunk) normalizeOutput(output string) string {
+	for _, n := range chunk.normalizers {
+		output = n.regex.ReplaceAllString(output, n.replacement)
+	}
+	return output
+}
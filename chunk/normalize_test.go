@@ -0,0 +1,98 @@
+package chunk_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/arkmq-org/markdown-runner/chunk"
+	"github.com/arkmq-org/markdown-runner/config"
+	"github.com/arkmq-org/markdown-runner/runnercontext"
+	"github.com/arkmq-org/markdown-runner/view"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizers(t *testing.T) {
+	t.Run("builtin normalizers", func(t *testing.T) {
+		testCases := []struct {
+			name     string
+			output   string
+			expected string
+		}{
+			{
+				name:     "timestamp",
+				output:   "started at 2024-03-01T12:34:56.789Z\n",
+				expected: "started at <timestamp>\n",
+			},
+			{
+				name:     "uuid",
+				output:   "./0b0f5a5e-0c4c-4b8e-9a3e-2f1c1d5e6f70.sh\n",
+				expected: "./<uuid>.sh\n",
+			},
+			{
+				name:     "tmpdir",
+				output:   "running in /tmp/123456/sub\n",
+				expected: "running in <tmpdir>/sub\n",
+			},
+			{
+				name:     "ansi",
+				output:   "\x1b[32mgreen\x1b[0m\n",
+				expected: "green\n",
+			},
+			{
+				name:     "trailing_whitespace",
+				output:   "a  \nb\t\n",
+				expected: "a\nb\n",
+			},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				c := &chunk.ExecutableChunk{
+					Normalize: []chunk.NormalizerSpec{{Name: tc.name}},
+					Commands:  []*chunk.RunningCommand{{Stdout: tc.output}},
+					Context:   &runnercontext.Context{Cfg: &config.Config{}, RView: view.NewView("mock")},
+				}
+				assert.NoError(t, c.CompileNormalizers())
+				assert.Equal(t, tc.expected, c.Output())
+			})
+		}
+	})
+	t.Run("custom and configured normalizers", func(t *testing.T) {
+		var specs []chunk.NormalizerSpec
+		err := json.Unmarshal([]byte(`["took [0-9]+ms=>took Xms", {"regex":"pod-[a-z0-9]+", "replacement":"pod-X"}]`), &specs)
+		assert.NoError(t, err)
+		c := &chunk.ExecutableChunk{
+			Normalize: specs,
+			Commands:  []*chunk.RunningCommand{{Stdout: "pod-x7f2 took 12ms at 2024-03-01 12:34:56\n"}},
+			Context:   &runnercontext.Context{Cfg: &config.Config{Normalize: []string{"timestamp"}}, RView: view.NewView("mock")},
+		}
+		assert.NoError(t, c.CompileNormalizers())
+		assert.Equal(t, "pod-X took Xms at <timestamp>\n", c.Output())
+	})
+	t.Run("check output normalizes both sides", func(t *testing.T) {
+		c := &chunk.ExecutableChunk{
+			Normalize:      []chunk.NormalizerSpec{{Name: "uuid"}},
+			Content:        []string{"echo id: 11111111-2222-3333-4444-555555555555"},
+			ExpectedOutput: []string{"id: aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"},
+			Context:        &runnercontext.Context{Cfg: &config.Config{MinutesToTimeout: 1}, RView: view.NewView("mock")},
+		}
+		assert.NoError(t, c.CompileNormalizers())
+		assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+		assert.NoError(t, c.ExecuteSequential())
+		assert.NoError(t, c.CheckOutput())
+	})
+	t.Run("invalid normalizers", func(t *testing.T) {
+		_, err := chunk.ParseNormalizerSpec("unknown")
+		assert.Error(t, err, "Expected an error for an unknown builtin")
+
+		c := &chunk.ExecutableChunk{
+			Normalize: []chunk.NormalizerSpec{{Regex: "("}},
+			Context:   &runnercontext.Context{Cfg: &config.Config{}, RView: view.NewView("mock")},
+		}
+		assert.Error(t, c.CompileNormalizers(), "Expected an error for an invalid regex")
+
+		c = &chunk.ExecutableChunk{
+			Context: &runnercontext.Context{Cfg: &config.Config{Normalize: []string{"unknown"}}, RView: view.NewView("mock")},
+		}
+		assert.Error(t, c.CompileNormalizers(), "Expected an error for an unknown configured normalizer")
+	})
+}
@@ -0,0 +1,129 @@
+// Package chunk provides the core data structures for the markdown runner. It
+// defines the ExecutableChunk, which represents a runnable code block, and the
+// RunningCommand, which represents a single command to be executed.
+package chunk
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// groups holds the process groups started by the runner whose leader hasn't
+// been waited for yet, by the pid of their leader, along with the timer
+// killing the ones being stopped. Their pid can't be reused by another process
+// meanwhile, unlike the one of a group whose leader was waited for.
+var groups = struct {
+	sync.Mutex
+	timers map[int]*time.Timer
+}{timers: make(map[int]*time.Timer)}
+
+// startInGroup makes the process of cmd the leader of its own process group
+// once started, for its descendants to be stopped along with it.
+func startInGroup(cmd *exec.Cmd, gracePeriod time.Duration) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	// the descendants left behind may hold the output open, the pipes get
+	// closed once the group had the time to be stopped
+	cmd.WaitDelay = gracePeriod + time.Second
+}
+
+// registerGroup records the process group led by the started process, for it
+// to be signaled until its leader is waited for.
+func registerGroup(process *os.Process) {
+	groups.Lock()
+	defer groups.Unlock()
+	groups.timers[process.Pid] = nil
+}
+
+// releaseGroup forgets the process group led by process once it was waited
+// for, cancelling its pending SIGKILL.
+func releaseGroup(process *os.Process) {
+	groups.Lock()
+	defer groups.Unlock()
+	if timer := groups.timers[process.Pid]; timer != nil {
+		timer.Stop()
+	}
+	delete(groups.timers, process.Pid)
+}
+
+// stopGroup asks the process group led by process to terminate with SIGTERM,
+// and kills what remains of it with SIGKILL once the grace period is over,
+// unless its leader was waited for by then. It doesn't wait for the group to
+// be gone. It returns os.ErrProcessDone if the group is already gone.
+func stopGroup(process *os.Process, gracePeriod time.Duration) error {
+	groups.Lock()
+	defer groups.Unlock()
+	if _, running := groups.timers[process.Pid]; !running {
+		return os.ErrProcessDone
+	}
+	err := syscall.Kill(-process.Pid, syscall.SIGTERM)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	if err != nil {
+		return err
+	}
+	if groups.timers[process.Pid] == nil {
+		groups.timers[process.Pid] = time.AfterFunc(gracePeriod, func() {
+			groups.Lock()
+			defer groups.Unlock()
+			if _, running := groups.timers[process.Pid]; running {
+				syscall.Kill(-process.Pid, syscall.SIGKILL)
+			}
+		})
+	}
+	return nil
+}
+
+// killGroup kills the process group led by process with SIGKILL, unless its
+// leader was waited for.
+func killGroup(process *os.Process) {
+	groups.Lock()
+	defer groups.Unlock()
+	if _, running := groups.timers[process.Pid]; running {
+		syscall.Kill(-process.Pid, syscall.SIGKILL)
+	}
+}
+
+// waitGroup waits for the leader of the process group of cmd and forgets the
+// group. The descendants left behind holding the output open past the grace
+// period are killed, as they can't be waited for.
+func waitGroup(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	if cmd.Process == nil {
+		return err
+	}
+	if errors.Is(err, exec.ErrWaitDelay) {
+		killGroup(cmd.Process)
+	}
+	releaseGroup(cmd.Process)
+	return err
+}
+
+// KillProcessGroups kills the process groups of the commands still running
+// with SIGKILL, along with the processes they started. It's meant for the
+// program to leave no process behind when it exits without stopping them.
+func KillProcessGroups() {
+	groups.Lock()
+	defer groups.Unlock()
+	for pid := range groups.timers {
+		syscall.Kill(-pid, syscall.SIGKILL)
+	}
+}
+
+// gracePeriod returns the time the processes of the command have to exit once
+// asked to terminate.
+func (command *RunningCommand) gracePeriod() time.Duration {
+	return command.Ctx.Cfg.GracePeriod
+}
+
+// stop asks the process group of the command to terminate, see stopGroup.
+func (command *RunningCommand) stop() error {
+	return stopGroup(command.Cmd.Process, command.gracePeriod())
+}
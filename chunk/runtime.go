@@ -0,0 +1,175 @@
+// Package chunk provides the core data structures for the markdown runner. It
+// defines the ExecutableChunk, which represents a runnable code block, and the
+// RunningCommand, which represents a single command to be executed.
+package chunk
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Runtime executes the content of the chunks whose "runtime" property is the
+// name it's registered with, see RegisterRuntime.
+//
+// Most runtimes execute the content through commands: their Prepare method
+// adds them to the chunk with AddCommandToExecute, and they embed
+// CommandRuntime for the commands to be executed like the ones of the builtin
+// runtimes, sequentially, in parallel or in the background.
+type Runtime interface {
+	// Prepare sets the chunk up for execution, creating its commands and the
+	// files they need. It's called before every attempt, in dry-run mode too.
+	//
+	// tmpDirs is the map of temporary directories for runtime directory
+	// resolution, see GetOrCreateRuntimeDirectory.
+	Prepare(chunk *ExecutableChunk, tmpDirs map[string]string) error
+	// Execute executes a sequential chunk once prepared. It returns an error
+	// if the execution failed.
+	Execute(chunk *ExecutableChunk) error
+	// DryRun reports what Execute would do, without doing it.
+	DryRun(chunk *ExecutableChunk) error
+	// SkipMessage returns the message reporting that the chunk is skipped due
+	// to previous errors.
+	SkipMessage(chunk *ExecutableChunk) string
+}
+
+// CommandRuntime implements the methods of a Runtime that are common to the
+// runtimes executing the content of the chunks through commands. It's meant
+// to be embedded, the runtime only implementing Prepare.
+type CommandRuntime struct{}
+
+// Execute implements Runtime, executing the commands of the chunk one after
+// the other and stopping at the first one failing.
+func (CommandRuntime) Execute(chunk *ExecutableChunk) error {
+	return chunk.executeCommands()
+}
+
+// DryRun implements Runtime, the commands reporting themselves without being
+// executed in dry-run mode.
+func (CommandRuntime) DryRun(chunk *ExecutableChunk) error {
+	return chunk.executeCommands()
+}
+
+// SkipMessage implements Runtime
+func (CommandRuntime) SkipMessage(chunk *ExecutableChunk) string {
+	return "Skip " + chunk.Runtime + " chunk '" + chunk.Label + "' due to previous errors"
+}
+
+// runtimeNameMatcher validates the names the runtimes are registered with
+var runtimeNameMatcher = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+var (
+	runtimesMutex sync.RWMutex
+	// runtimes are the registered runtimes by name, the classical one, which
+	// executes every line of the content as a command, having an empty name
+	runtimes = map[string]Runtime{
+		"":        classicalRuntime{},
+		"bash":    bashRuntime{},
+		"writer":  writerRuntime{},
+		"sh":      interpretedRuntimes["sh"],
+		"python3": interpretedRuntimes["python3"],
+		"node":    interpretedRuntimes["node"],
+		"go":      goRuntime{},
+		"exec":    execRuntime{},
+	}
+)
+
+// RegisterRuntime makes a runtime available to the chunks under the given
+// name, on top of the builtin ones. It must be called before the markdown files
+// get parsed, the "runtime" property being validated against the registered
+// runtimes. It returns an error if the name is invalid or already taken.
+func RegisterRuntime(name string, runtime Runtime) error {
+	if !runtimeNameMatcher.MatchString(name) {
+		return fmt.Errorf("invalid runtime name '%s', it can only contain letters, digits, '_' and '-'", name)
+	}
+	runtimesMutex.Lock()
+	defer runtimesMutex.Unlock()
+	if _, exists := runtimes[name]; exists {
+		return fmt.Errorf("the runtime '%s' is already registered", name)
+	}
+	runtimes[name] = runtime
+	return nil
+}
+
+// RuntimeNames returns the sorted names the runtimes are registered with, that
+// can be used as the "runtime" property of a chunk.
+func RuntimeNames() []string {
+	runtimesMutex.RLock()
+	defer runtimesMutex.RUnlock()
+	var names []string
+	for _, name := range slices.Sorted(maps.Keys(runtimes)) {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// runtime returns the runtime of the chunk.
+func (chunk *ExecutableChunk) runtime() (Runtime, error) {
+	runtimesMutex.RLock()
+	defer runtimesMutex.RUnlock()
+	runtime, exists := runtimes[chunk.Runtime]
+	if !exists {
+		return nil, fmt.Errorf("unknown runtime '%s'", chunk.Runtime)
+	}
+	return runtime, nil
+}
+
+// classicalRuntime executes every line of the content of the chunk as a
+// command, see prepareClassical.
+type classicalRuntime struct {
+	CommandRuntime
+}
+
+// Prepare implements Runtime
+func (classicalRuntime) Prepare(chunk *ExecutableChunk, tmpDirs map[string]string) error {
+	return chunk.prepareClassical(tmpDirs)
+}
+
+// SkipMessage implements Runtime
+func (classicalRuntime) SkipMessage(chunk *ExecutableChunk) string {
+	var messages []string
+	for _, command := range chunk.Content {
+		messages = append(messages, "Skip command '"+command+"' due to previous errors")
+	}
+	return strings.Join(messages, "\n")
+}
+
+// bashRuntime executes the content of the chunk as a bash script, see
+// prepareBashChunkForExecution.
+type bashRuntime struct {
+	CommandRuntime
+}
+
+// Prepare implements Runtime
+func (bashRuntime) Prepare(chunk *ExecutableChunk, tmpDirs map[string]string) error {
+	return chunk.prepareBashChunkForExecution(tmpDirs)
+}
+
+// writerRuntime writes the content of the chunk to its destination. The file
+// is written as soon as the chunk is prepared, in dry-run mode too.
+type writerRuntime struct{}
+
+// Prepare implements Runtime, writing the file
+func (writerRuntime) Prepare(chunk *ExecutableChunk, tmpDirs map[string]string) error {
+	return chunk.applyWriter(tmpDirs)
+}
+
+// Execute implements Runtime, there's nothing left to do
+func (writerRuntime) Execute(chunk *ExecutableChunk) error {
+	return nil
+}
+
+// DryRun implements Runtime, there's nothing left to do
+func (writerRuntime) DryRun(chunk *ExecutableChunk) error {
+	return nil
+}
+
+// SkipMessage implements Runtime
+func (writerRuntime) SkipMessage(chunk *ExecutableChunk) string {
+	return "Skip writer chunk '" + chunk.Label + "' due to previous errors"
+}
@@ -0,0 +1,73 @@
+package chunk_test
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/arkmq-org/markdown-runner/chunk"
+	"github.com/arkmq-org/markdown-runner/config"
+	"github.com/arkmq-org/markdown-runner/runnercontext"
+	"github.com/arkmq-org/markdown-runner/view"
+	"github.com/stretchr/testify/assert"
+)
+
+// shoutRuntime is a runtime registered from outside of the package, upper
+// casing the content of the chunk
+type shoutRuntime struct {
+	chunk.CommandRuntime
+}
+
+func (shoutRuntime) Prepare(c *chunk.ExecutableChunk, tmpDirs map[string]string) error {
+	dir, err := c.GetOrCreateRuntimeDirectory(tmpDirs)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path.Join(dir, "input"), []byte(strings.Join(c.Content, "\n")+"\n"), 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = c.AddCommandToExecute("sh -c 'tr a-z A-Z < input'", tmpDirs)
+	return err
+}
+
+func TestRuntime(t *testing.T) {
+	t.Run("it should execute a registered runtime", func(t *testing.T) {
+		assert.NoError(t, chunk.RegisterRuntime("test-shout", shoutRuntime{}))
+		assert.Contains(t, chunk.RuntimeNames(), "test-shout")
+
+		ui := view.NewView("mock")
+		c := &chunk.ExecutableChunk{
+			Runtime: "test-shout",
+			RootDir: t.TempDir(),
+			Content: []string{"hello"},
+			Context: &runnercontext.Context{Cfg: &config.Config{MinutesToTimeout: 1}, RView: ui},
+		}
+		assert.NoError(t, c.PrepareForExecution(make(map[string]string)))
+		assert.NoError(t, c.ExecuteSequential())
+		assert.Equal(t, "HELLO\n", c.Commands[0].Stdout)
+
+		c.Skip()
+		assert.Equal(t, []any{"Skip test-shout chunk '' due to previous errors"}, ui.(*view.MockRunnerView).Calls["Info"][0])
+	})
+	t.Run("it should reject invalid and taken names", func(t *testing.T) {
+		assert.Error(t, chunk.RegisterRuntime("bash", shoutRuntime{}))
+		assert.Error(t, chunk.RegisterRuntime("", shoutRuntime{}))
+		assert.Error(t, chunk.RegisterRuntime("with space", shoutRuntime{}))
+	})
+	t.Run("it should list the builtin runtimes", func(t *testing.T) {
+		names := chunk.RuntimeNames()
+		for _, name := range []string{"bash", "writer", "sh", "python3", "node", "go", "exec"} {
+			assert.Contains(t, names, name)
+		}
+		assert.NotContains(t, names, "", "Expected the classical runtime not to be listed")
+	})
+	t.Run("it should fail on unknown runtimes", func(t *testing.T) {
+		c := &chunk.ExecutableChunk{
+			Runtime: "unknown",
+			Context: &runnercontext.Context{Cfg: &config.Config{MinutesToTimeout: 1}, RView: view.NewView("mock")},
+		}
+		assert.Error(t, c.PrepareForExecution(make(map[string]string)))
+	})
+}
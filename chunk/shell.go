@@ -0,0 +1,276 @@
+// Package chunk provides the core data structures for the markdown runner. It
+// defines the ExecutableChunk, which represents a runnable code block, and the
+// RunningCommand, which represents a single command to be executed.
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/arkmq-org/markdown-runner/runnercontext"
+	"github.com/google/uuid"
+)
+
+const (
+	// ShellPerFile feeds the bash chunks to one shell for the whole file
+	ShellPerFile = "file"
+	// ShellPerRootdir feeds the bash chunks to one shell per runtime directory
+	ShellPerRootdir = "rootdir"
+)
+
+// shellSentinelPrefix starts the line a persistent shell prints on both its
+// stdout and stderr once a chunk is done, followed by a unique id and the exit
+// status of the chunk.
+const shellSentinelPrefix = "__markdown_runner_done_"
+
+// shellScriptPrelude starts the scripts sourced by a persistent shell. As the
+// shell has to survive a failing chunk, set -e is emulated by returning from
+// the script on the first error, functions included.
+const shellScriptPrelude = "set -E -o pipefail\ntrap 'return $? 2>/dev/null' ERR\n"
+
+// shellSession is a long-lived bash process the chunks of a persistent shell
+// are fed to one after the other, as if they were pasted in a terminal: the
+// working directory, the variables, the functions and the aliases of a chunk
+// are the ones of the next chunks.
+type shellSession struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *sentinelWriter
+	stderr *sentinelWriter
+	exited chan struct{}
+	// gracePeriod is the time the shell has to exit once asked to terminate
+	gracePeriod time.Duration
+}
+
+// shellKey returns the key of the persistent shell a chunk is fed to, among
+// the shells of its file.
+//
+// dir is the runtime directory of the chunk.
+func (chunk *ExecutableChunk) shellKey(dir string) string {
+	if chunk.Shell == ShellPerRootdir {
+		return ShellPerRootdir + ":" + dir
+	}
+	return ShellPerFile
+}
+
+// shellFor returns the persistent shell of the given key, starting it in dir
+// when it isn't running, either because it's the first chunk fed to it or
+// because the previous one ended it.
+func shellFor(ctx *runnercontext.Context, key string, dir string) (*shellSession, error) {
+	if session, exists := ctx.Shells[key].(*shellSession); exists && !session.hasExited() {
+		return session, nil
+	}
+	session, err := startShellSession(dir, ctx.Env, ctx.ShellDefinitions, ctx.Cfg.GracePeriod)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Shells == nil {
+		ctx.Shells = make(map[string]io.Closer)
+	}
+	ctx.Shells[key] = session
+	return session, nil
+}
+
+// startShellSession starts a bash process reading the chunks from its stdin.
+//
+// dir is the directory the shell starts in.
+// env is the environment of the shell.
+// definitions are the functions and aliases defined by the previous chunks.
+// gracePeriod is the time the shell has to exit once asked to terminate.
+func startShellSession(dir string, env []string, definitions string, gracePeriod time.Duration) (*shellSession, error) {
+	cmd := exec.Command("bash", "--noprofile", "--norc")
+	cmd.Dir = dir
+	cmd.Env = env
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	session := &shellSession{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: &sentinelWriter{},
+		stderr: &sentinelWriter{},
+		exited: make(chan struct{}),
+
+		gracePeriod: gracePeriod,
+	}
+	cmd.Stdout = session.stdout
+	cmd.Stderr = session.stderr
+	startInGroup(cmd, gracePeriod)
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	registerGroup(cmd.Process)
+	go func() {
+		waitGroup(cmd)
+		close(session.exited)
+	}()
+	_, err = io.WriteString(stdin, "shopt -s expand_aliases\n"+definitions)
+	if err != nil {
+		session.kill()
+		return nil, err
+	}
+	return session, nil
+}
+
+// hasExited reports whether the shell is over.
+func (session *shellSession) hasExited() bool {
+	select {
+	case <-session.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// run feeds a script to the shell, its output going to the writers of the
+// command until the shell reports it's done. The state of the shell is dumped
+// to the state files once the script succeeded, for the chunks executed out of
+// the shell.
+//
+// scriptPath and statePath are absolute, the script may change the directory.
+func (session *shellSession) run(command *RunningCommand, scriptPath string, statePath string) error {
+	sentinel := shellSentinelPrefix + uuid.New().String()
+	session.stdout.expect(sentinel, command.Cmd.Stdout)
+	session.stderr.expect(sentinel, command.Cmd.Stderr)
+	var line bytes.Buffer
+	// the script must not read the next chunks from the stdin of the shell
+	fmt.Fprintf(&line, "source %s < /dev/null; __markdown_runner_status=$?; trap - ERR; ", shellQuote(scriptPath))
+	if command.Ctx.Cfg.Verbose {
+		line.WriteString("{ set +x; } 2>/dev/null; ")
+	}
+	fmt.Fprintf(&line, "if [ $__markdown_runner_status -eq 0 ]; then env -0 > %s; { declare -f; alias -p; } > %s; fi; ",
+		shellQuote(statePath+envStateSuffix), shellQuote(statePath+shellStateSuffix))
+	fmt.Fprintf(&line, "printf '%%s:%%d\\n' %s $__markdown_runner_status; printf '%%s:%%d\\n' %s $__markdown_runner_status >&2\n", sentinel, sentinel)
+	_, err := session.stdin.Write(line.Bytes())
+	return err
+}
+
+// wait waits for the script fed to the shell to be done, returning its exit
+// status. The shell is terminated when the deadline is exceeded first.
+func (session *shellSession) wait(deadline context.Context) *exitStatus {
+	var code int
+	for _, stream := range []*sentinelWriter{session.stdout, session.stderr} {
+		select {
+		case code = <-stream.done:
+		case <-deadline.Done():
+			session.terminate()
+			return &exitStatus{exited: false, code: -1, description: "signal: terminated"}
+		case <-session.exited:
+			// the script may have ended the shell right after being done
+			select {
+			case code = <-stream.done:
+				continue
+			default:
+			}
+			session.stdout.flush()
+			session.stderr.flush()
+			state := session.cmd.ProcessState
+			return &exitStatus{exited: state.Exited(), code: state.ExitCode(), description: state.String()}
+		}
+	}
+	return &exitStatus{exited: true, code: code, description: fmt.Sprintf("exit status %d", code)}
+}
+
+// kill kills the shell along with the processes the chunks started, and waits
+// for it to be over.
+func (session *shellSession) kill() {
+	killGroup(session.cmd.Process)
+	<-session.exited
+}
+
+// terminate stops the shell along with the processes the chunks started, see
+// stopGroup, and waits for it to be gone.
+func (session *shellSession) terminate() {
+	stopGroup(session.cmd.Process, session.gracePeriod)
+	<-session.exited
+}
+
+// Close implements io.Closer. It lets the shell exit by itself once the last
+// chunk is done, killing it after the grace period.
+func (session *shellSession) Close() error {
+	session.stdin.Close()
+	select {
+	case <-session.exited:
+	case <-time.After(session.gracePeriod):
+		session.kill()
+	}
+	return nil
+}
+
+// sentinelWriter receives an output stream of a persistent shell, handing it
+// over to the command being executed until the sentinel marking its end shows
+// up.
+type sentinelWriter struct {
+	mutex    sync.Mutex
+	pending  []byte
+	target   io.Writer
+	sentinel []byte
+	done     chan int
+}
+
+// expect hands the next output over to target, until the given sentinel.
+func (writer *sentinelWriter) expect(sentinel string, target io.Writer) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.sentinel = []byte(sentinel + ":")
+	writer.target = target
+	writer.done = make(chan int, 1)
+}
+
+// Write implements io.Writer
+func (writer *sentinelWriter) Write(p []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.pending = append(writer.pending, p...)
+	// the output produced between two chunks goes to the next one
+	if writer.sentinel == nil {
+		return len(p), nil
+	}
+	index := bytes.Index(writer.pending, writer.sentinel)
+	if index < 0 {
+		// hand everything over but what could be the beginning of the sentinel
+		writer.handOver(max(0, len(writer.pending)-len(writer.sentinel)+1))
+		return len(p), nil
+	}
+	end := bytes.IndexByte(writer.pending[index:], '\n')
+	if end < 0 {
+		writer.handOver(index)
+		return len(p), nil
+	}
+	code, err := strconv.Atoi(string(writer.pending[index+len(writer.sentinel) : index+end]))
+	if err != nil {
+		code = -1
+	}
+	writer.handOver(index)
+	writer.pending = writer.pending[end+1:]
+	writer.sentinel = nil
+	writer.target = nil
+	writer.done <- code
+	return len(p), nil
+}
+
+// handOver writes the first n pending bytes to the target.
+func (writer *sentinelWriter) handOver(n int) {
+	if n == 0 {
+		return
+	}
+	writer.target.Write(writer.pending[:n])
+	writer.pending = append([]byte(nil), writer.pending[n:]...)
+}
+
+// flush hands everything pending over to the target, once the shell is over.
+func (writer *sentinelWriter) flush() {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.target != nil {
+		writer.handOver(len(writer.pending))
+	}
+}
@@ -0,0 +1,73 @@
+// Package chunk provides the core data structures for the markdown runner. It
+// defines the ExecutableChunk, which represents a runnable code block, and the
+// RunningCommand, which represents a single command to be executed.
+package chunk
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/arkmq-org/markdown-runner/view"
+)
+
+// lineWriter forwards the output of a command to the view line by line, as
+// it's written.
+type lineWriter struct {
+	mutex   sync.Mutex
+	view    view.RunnerView
+	id      string
+	stderr  bool
+	pending []byte
+}
+
+// Write implements io.Writer
+func (writer *lineWriter) Write(p []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.pending = append(writer.pending, p...)
+	for {
+		index := bytes.IndexByte(writer.pending, '\n')
+		if index < 0 {
+			break
+		}
+		writer.forward(writer.pending[:index])
+		writer.pending = writer.pending[index+1:]
+	}
+	return len(p), nil
+}
+
+// flush forwards the last line, once the command is done without ending it.
+func (writer *lineWriter) flush() {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if len(writer.pending) > 0 {
+		writer.forward(writer.pending)
+		writer.pending = nil
+	}
+}
+
+func (writer *lineWriter) forward(line []byte) {
+	writer.view.CommandOutput(writer.id, writer.stderr, strings.TrimSuffix(string(line), "\r"))
+}
+
+// outputWriters returns the writers the output of the command goes to: its
+// buffers, and the view as well when the output is streamed.
+func (command *RunningCommand) outputWriters() (io.Writer, io.Writer) {
+	if !command.details.Streamed {
+		return &command.Outb, &command.Errb
+	}
+	command.streams = []*lineWriter{
+		{view: command.Ctx.RView, id: command.id},
+		{view: command.Ctx.RView, id: command.id, stderr: true},
+	}
+	return io.MultiWriter(&command.Outb, command.streams[0]), io.MultiWriter(&command.Errb, command.streams[1])
+}
+
+// flushStreams forwards what remains of the output of the command to the view.
+func (command *RunningCommand) flushStreams() {
+	for _, stream := range command.streams {
+		stream.flush()
+	}
+}
@@ -14,6 +14,8 @@ declare -gA FLAG_EQUIV=(
     [-s]="--start-from"  [--start-from]="-s"
     [-B]="--break-at"    [--break-at]="-B"
     [-t]="--timeout"     [--timeout]="-t"
+    [-j]="--jobs"        [--jobs]="-j"
+    [-e]="--env"         [--env]="-e"
     [-u]="--update-files" [--update-files]="-u"
     [-f]="--filter"      [--filter]="-f"
     [-r]="--recursive"   [--recursive]="-r"
@@ -26,9 +28,17 @@ declare -gA FLAG_EQUIV=(
 declare -gA FLAGS_WITH_VALUES=(
     [-B]=1 [--break-at]=1
     [-s]=1 [--start-from]=1
+    [--tags]=1
+    [--skip-tags]=1
     [-t]=1 [--timeout]=1
+    [--grace-period]=1
+    [-j]=1 [--jobs]=1
+    [-e]=1 [--env]=1
     [-f]=1 [--filter]=1
     [--view]=1
+    [--normalize]=1
+    [--report-junit]=1
+    [--state-file]=1
 )
 
 # Flag incompatibilities
@@ -37,33 +47,42 @@ declare -ga FLAG_INCOMPATIBILITIES=(
     # Mutual exclusions
     "-v:-q,--quiet"
     "-q:-v,--verbose"
-    "-d:-u,--update-files"
+    "-d:-u,--update-files,--update-hidden"
     "-u:-d,--dry-run"
-    "--dry-run:-u,--update-files"
+    "--dry-run:-u,--update-files,--update-hidden"
     "--update-files:-d,--dry-run"
+    "--update-hidden:-d,--dry-run"
     "-B:--ignore-breakpoints"
     "--break-at:--ignore-breakpoints"
     "--ignore-breakpoints:-B,--break-at"
 
     # Help excludes everything
-    "-h:-d,--dry-run,-l,--list,-i,--interactive,-s,--start-from,-B,--break-at,-t,--timeout,-u,--update-files,--ignore-breakpoints,-f,--filter,-r,--recursive,--view,-v,--verbose,-q,--quiet,--no-styling"
-    "--help:-d,--dry-run,-l,--list,-i,--interactive,-s,--start-from,-B,--break-at,-t,--timeout,-u,--update-files,--ignore-breakpoints,-f,--filter,-r,--recursive,--view,-v,--verbose,-q,--quiet,--no-styling"
+    "-h:--check,-d,--dry-run,-l,--list,-i,--interactive,-s,--start-from,--tags,--skip-tags,-B,--break-at,-t,--timeout,--grace-period,-u,--update-files,--update-hidden,--ignore-breakpoints,-e,--env,--share-env,-j,--jobs,--rerun-failed,--resume,--state-file,-f,--filter,-r,--recursive,--normalize,--view,--report-junit,-v,--verbose,--stream,-q,--quiet,--no-styling"
+    "--help:--check,-d,--dry-run,-l,--list,-i,--interactive,-s,--start-from,--tags,--skip-tags,-B,--break-at,-t,--timeout,--grace-period,-u,--update-files,--update-hidden,--ignore-breakpoints,-e,--env,--share-env,-j,--jobs,--rerun-failed,--resume,--state-file,-f,--filter,-r,--recursive,--normalize,--view,--report-junit,-v,--verbose,--stream,-q,--quiet,--no-styling"
 
     # List mode excludes execution flags
-    "-l:-i,--interactive,-B,--break-at,-s,--start-from,-d,--dry-run,-t,--timeout,-u,--update-files"
-    "--list:-i,--interactive,-B,--break-at,-s,--start-from,-d,--dry-run,-t,--timeout,-u,--update-files"
+    "-l:-i,--interactive,-B,--break-at,-s,--start-from,--tags,--skip-tags,-d,--dry-run,--check,-t,--timeout,--grace-period,-u,--update-files,--update-hidden,-e,--env,--share-env,-j,--jobs,--rerun-failed,--resume"
+    "--list:-i,--interactive,-B,--break-at,-s,--start-from,--tags,--skip-tags,-d,--dry-run,--check,-t,--timeout,--grace-period,-u,--update-files,--update-hidden,-e,--env,--share-env,-j,--jobs,--rerun-failed,--resume"
 
     # Interactive flags exclude list/help
-    "-i:--view,-l,--list,-h,--help"
-    "--interactive:--view,-l,--list,-h,--help"
-    "-B:--view,-l,--list,-h,--help"
-    "--break-at:--view,-l,--list,-h,--help"
-    "-s:--view,-l,--list,-h,--help"
-    "--start-from:--view,-l,--list,-h,--help"
+    "-i:--view,-l,--list,-h,--help,-j,--jobs"
+    "--interactive:--view,-l,--list,-h,--help,-j,--jobs"
+    "-B:--view,-l,--list,-h,--help,-j,--jobs"
+    "--break-at:--view,-l,--list,-h,--help,-j,--jobs"
+    "-s:--view,-l,--list,-h,--help,-j,--jobs,--resume"
+    "--start-from:--view,-l,--list,-h,--help,-j,--jobs,--resume"
+
+    # Concurrent files can't be followed step by step
+    "-j:-i,--interactive,-s,--start-from,-B,--break-at,--share-env"
+    "--jobs:-i,--interactive,-s,--start-from,-B,--break-at,--share-env"
+    "--share-env:-j,--jobs"
+
+    # Resuming chooses where every file starts from
+    "--resume:-s,--start-from"
 )
 
 # All available flags
-ALL_FLAGS="-d --dry-run -l --list -i --interactive -s --start-from -B --break-at -t --timeout -u --update-files --ignore-breakpoints -f --filter -r --recursive --view -v --verbose -q --quiet --no-styling -h --help"
+ALL_FLAGS="-d --dry-run -l --list --check -i --interactive -s --start-from --tags --skip-tags -B --break-at -t --timeout --grace-period -u --update-files --update-hidden --ignore-breakpoints -e --env --share-env -j --jobs --rerun-failed --resume --state-file -f --filter -r --recursive --normalize --view --report-junit -v --verbose --stream -q --quiet --no-styling -h --help"
 
 # ============================================================================
 # Utility Functions
@@ -671,13 +690,37 @@ _markdown_runner_completion() {
             COMPREPLY=( $(compgen -W "1 5 10 30 60" -- "$cur") )
             return
             ;;
+        --grace-period)
+            COMPREPLY=( $(compgen -W "0s 5s 10s 30s 1m" -- "$cur") )
+            return
+            ;;
+        -j|--jobs)
+            COMPREPLY=( $(compgen -W "1 2 4 8" -- "$cur") )
+            return
+            ;;
         --view)
-            COMPREPLY=( $(compgen -W "default ci" -- "$cur") )
+            COMPREPLY=( $(compgen -W "default ci json" -- "$cur") )
+            return
+            ;;
+        --normalize)
+            COMPREPLY=( $(compgen -W "ansi timestamp tmpdir trailing_whitespace uuid" -- "$cur") )
+            return
+            ;;
+        --report-junit|--state-file)
+            COMPREPLY=( $(compgen -f -- "$cur") )
             return
             ;;
         -f|--filter)
             return  # No completion for regex
             ;;
+        -e|--env)
+            COMPREPLY=( $(compgen -e -S = -- "$cur") )
+            compopt -o nospace 2>/dev/null
+            return
+            ;;
+        --tags|--skip-tags)
+            return  # No completion for tag expressions
+            ;;
     esac
 
     # Handle flag completion
@@ -705,16 +748,30 @@ _markdown_runner_completion() {
             done <<EOF
 -d|--dry-run|(dry-run)
 -l|--list|(list files)
+|--check|(check output)
 -i|--interactive|(interactive)
 -s|--start-from|(start from stage)
+|--tags|(select by tags)
+|--skip-tags|(skip by tags)
 -B|--break-at|(break at stage)
 -t|--timeout|(timeout)
+|--grace-period|(grace period)
 -u|--update-files|(update files)
+|--update-hidden|(update hidden chunks)
 |--ignore-breakpoints|(ignore breakpoints)
+-e|--env|(environment variable)
+|--share-env|(share environment)
+-j|--jobs|(concurrent files)
+|--rerun-failed|(rerun failed files)
+|--resume|(resume failed files)
+|--state-file|(state file)
+|--normalize|(output normalizers)
 -f|--filter|(filter)
 -r|--recursive|(recursive)
 |--view|(view mode)
+|--report-junit|(junit report)
 -v|--verbose|(verbose)
+|--stream|(stream output)
 -q|--quiet|(quiet)
 |--no-styling|(no styling)
 -h|--help|(help)
@@ -736,22 +793,36 @@ EOF
             "Modes:"
             "  -d, --dry-run              Just list what would be executed without doing it"
             "  -l, --list                 Just list the files found"
+            "      --check                Fail when a chunk output differs from the documented one"
             ""
             "Execution Control:"
             "  -i, --interactive          Prompt to press enter between each chunk"
             "  -s, --start-from string    Start from a specific stage (stage or file@stage)"
+            "      --tags string          Run only the chunks whose tags match the expression (e.g. 'smoke && !slow')"
+            "      --skip-tags string     Skip the chunks whose tags match the expression"
             "  -B, --break-at string      Start debugging from a specific stage or chunk"
             "  -t, --timeout int          The timeout in minutes for every executed command"
+            "      --grace-period duration  The time a stopped command has to exit after SIGTERM before getting killed"
             "  -u, --update-files         Update the chunk output section in the markdown files"
+            "      --update-hidden        Also update the output of the chunks hidden in HTML comments"
             "      --ignore-breakpoints   Ignore the breakpoints"
+            "  -e, --env strings          Set an environment variable for the chunks (KEY=VALUE)"
+            "      --share-env            Pass the variables exported by a file on to the next files"
+            "  -j, --jobs int             The number of files executed concurrently"
+            "      --rerun-failed         Execute only the files that didn't pass in the previous execution"
+            "      --resume               Like --rerun-failed, continuing the files from their failing chunk"
+            "      --state-file string    Keep the outcome of the execution, exported variables included, in the file"
+            "      --normalize strings    Normalizers applied to every chunk output"
             ""
             "File Selection:"
             "  -f, --filter string        Run only the files matching the regex"
             "  -r, --recursive            Search for markdown files recursively"
             ""
             "Output & Logging:"
-            "      --view string          UI to be used, can be 'default' or 'ci'"
+            "      --view string          UI to be used, can be 'default', 'ci' or 'json'"
+            "      --report-junit string  Write a JUnit XML report of the execution to the given file"
             "  -v, --verbose              Print more logs"
+            "      --stream               Show the output of the commands as it's written"
             "  -q, --quiet                Disable output"
             "      --no-styling           Disable spinners in CLI"
             ""
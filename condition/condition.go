@@ -0,0 +1,444 @@
+// Package condition parses and evaluates the expressions deciding whether a
+// chunk gets executed, such as `env('KUBECONFIG') && command('kubectl')`.
+//
+// An expression combines, with `!`, `&&`, `||` and parentheses:
+//   - env('NAME'): the value of an environment variable, empty if unset
+//   - command('name'): whether an executable is found in the PATH
+//   - os and arch: the platform the runner is executed on, such as 'linux' or 'amd64'
+//   - succeeded('stage/id') and failed('stage/id'): the outcome of another chunk
+//   - output('stage/id'): the output of another chunk
+//   - string literals between single or double quotes, true and false
+//
+// Values are compared with `==`, `!=` and `=~`, the latter matching a regex. A
+// string is true when it's not empty.
+//
+// Tag expressions, such as `smoke && !slow`, select chunks by their tags. They
+// only combine tags with `!`, `&&`, `||` and parentheses.
+package condition
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Resolver gives access to the state an expression depends on.
+type Resolver interface {
+	// Getenv returns the value of an environment variable, empty if unset.
+	Getenv(name string) string
+	// ChunkSucceeded reports whether the referenced chunk executed correctly.
+	ChunkSucceeded(ref string) (bool, error)
+	// ChunkFailed reports whether the referenced chunk executed and failed.
+	ChunkFailed(ref string) (bool, error)
+	// ChunkOutput returns the output of the referenced chunk.
+	ChunkOutput(ref string) (string, error)
+}
+
+// Expression is a parsed condition, ready to be evaluated.
+type Expression struct {
+	source string
+	root   node
+}
+
+// Parse parses the source of an expression. It returns an error if the syntax
+// is invalid or if it uses unknown functions.
+func Parse(source string) (*Expression, error) {
+	tokens, err := tokenize(source, tokenMatcher)
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition '%s': %w", source, err)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && !p.done() {
+		err = fmt.Errorf("unexpected '%s'", p.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition '%s': %w", source, err)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Evaluate computes the expression against the resolver. It returns an error if
+// a referenced chunk doesn't exist or a regex is invalid.
+func (e *Expression) Evaluate(resolver Resolver) (bool, error) {
+	result, err := e.root.evaluate(&scope{resolver: resolver})
+	if err != nil {
+		return false, fmt.Errorf("cannot evaluate condition '%s': %w", e.source, err)
+	}
+	return result.isTrue(), nil
+}
+
+// ChunkReferences returns the references to other chunks, such as
+// 'stageName/id', passed to succeeded, failed and output in the expression.
+func (e *Expression) ChunkReferences() []string {
+	return chunkReferences(e.root, nil)
+}
+
+// chunkReferences appends the references to other chunks found in the node
+// and its operands to refs.
+func chunkReferences(n node, refs []string) []string {
+	switch n := n.(type) {
+	case notNode:
+		return chunkReferences(n.operand, refs)
+	case logicalNode:
+		return chunkReferences(n.right, chunkReferences(n.left, refs))
+	case comparisonNode:
+		return chunkReferences(n.right, chunkReferences(n.left, refs))
+	case callNode:
+		if n.function == "succeeded" || n.function == "failed" || n.function == "output" {
+			return append(refs, n.argument)
+		}
+	}
+	return refs
+}
+
+// ParseTags parses an expression selecting chunks by their tags, such as
+// `smoke && !slow`, where every identifier is a tag. Only `!`, `&&`, `||` and
+// parentheses are supported.
+func ParseTags(source string) (*Expression, error) {
+	tokens, err := tokenize(source, tagTokenMatcher)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag expression '%s': %w", source, err)
+	}
+	p := &parser{tokens: tokens, isTags: true}
+	root, err := p.parseOr()
+	if err == nil && !p.done() {
+		err = fmt.Errorf("unexpected '%s'", p.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag expression '%s': %w", source, err)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// MatchTags reports whether the tags satisfy an expression parsed with
+// ParseTags.
+func (e *Expression) MatchTags(tags []string) bool {
+	// tag expressions only hold tags and logical operators, they can't fail
+	result, _ := e.root.evaluate(&scope{tags: tags})
+	return result.isTrue()
+}
+
+// scope is what the nodes are evaluated against
+type scope struct {
+	resolver Resolver
+	tags     []string
+}
+
+// value is the result of a node, either a string or a boolean.
+type value struct {
+	text   string
+	isBool bool
+	bool   bool
+}
+
+func stringValue(text string) value {
+	return value{text: text}
+}
+
+func boolValue(b bool) value {
+	return value{isBool: true, bool: b}
+}
+
+func (v value) isTrue() bool {
+	if v.isBool {
+		return v.bool
+	}
+	return v.text != ""
+}
+
+func (v value) String() string {
+	if v.isBool {
+		return strconv.FormatBool(v.bool)
+	}
+	return v.text
+}
+
+// node is an element of the expression tree.
+type node interface {
+	evaluate(s *scope) (value, error)
+}
+
+type literalNode struct {
+	value value
+}
+
+func (n literalNode) evaluate(s *scope) (value, error) {
+	return n.value, nil
+}
+
+type tagNode struct {
+	tag string
+}
+
+func (n tagNode) evaluate(s *scope) (value, error) {
+	return boolValue(slices.Contains(s.tags, n.tag)), nil
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n notNode) evaluate(s *scope) (value, error) {
+	operand, err := n.operand.evaluate(s)
+	if err != nil {
+		return value{}, err
+	}
+	return boolValue(!operand.isTrue()), nil
+}
+
+type logicalNode struct {
+	operator    string
+	left, right node
+}
+
+func (n logicalNode) evaluate(s *scope) (value, error) {
+	left, err := n.left.evaluate(s)
+	if err != nil {
+		return value{}, err
+	}
+	// short-circuit so that the right side may depend on the left one
+	if n.operator == "&&" && !left.isTrue() {
+		return boolValue(false), nil
+	}
+	if n.operator == "||" && left.isTrue() {
+		return boolValue(true), nil
+	}
+	right, err := n.right.evaluate(s)
+	if err != nil {
+		return value{}, err
+	}
+	return boolValue(right.isTrue()), nil
+}
+
+type comparisonNode struct {
+	operator    string
+	left, right node
+}
+
+func (n comparisonNode) evaluate(s *scope) (value, error) {
+	left, err := n.left.evaluate(s)
+	if err != nil {
+		return value{}, err
+	}
+	right, err := n.right.evaluate(s)
+	if err != nil {
+		return value{}, err
+	}
+	switch n.operator {
+	case "==":
+		return boolValue(left.String() == right.String()), nil
+	case "!=":
+		return boolValue(left.String() != right.String()), nil
+	default:
+		regex, err := regexp.Compile(right.String())
+		if err != nil {
+			return value{}, err
+		}
+		return boolValue(regex.MatchString(left.String())), nil
+	}
+}
+
+type callNode struct {
+	function string
+	argument string
+}
+
+func (n callNode) evaluate(s *scope) (value, error) {
+	switch n.function {
+	case "env":
+		return stringValue(s.resolver.Getenv(n.argument)), nil
+	case "command":
+		_, err := exec.LookPath(n.argument)
+		return boolValue(err == nil), nil
+	case "succeeded":
+		succeeded, err := s.resolver.ChunkSucceeded(n.argument)
+		return boolValue(succeeded), err
+	case "failed":
+		failed, err := s.resolver.ChunkFailed(n.argument)
+		return boolValue(failed), err
+	default:
+		output, err := s.resolver.ChunkOutput(n.argument)
+		return stringValue(output), err
+	}
+}
+
+// functions lists the functions that can be called, all of them taking a single
+// string argument.
+var functions = []string{"env", "command", "succeeded", "failed", "output"}
+
+// identifiers are the values that can be referenced by name.
+var identifiers = map[string]value{
+	"os":    stringValue(runtime.GOOS),
+	"arch":  stringValue(runtime.GOARCH),
+	"true":  boolValue(true),
+	"false": boolValue(false),
+}
+
+// token is a lexical element of an expression.
+type token struct {
+	kind string // "ident", "string" or the operator itself
+	text string
+}
+
+var (
+	tokenMatcher    = regexp.MustCompile(`^(?:\s+|&&|\|\||==|!=|=~|[!()]|[a-zA-Z_][a-zA-Z0-9_]*|'[^']*'|"[^"]*")`)
+	tagTokenMatcher = regexp.MustCompile(`^(?:\s+|&&|\|\||[!()]|[a-zA-Z0-9_][a-zA-Z0-9_-]*)`)
+)
+
+// tokenize splits the source of an expression in tokens with the matcher,
+// dropping the spaces.
+func tokenize(source string, matcher *regexp.Regexp) ([]token, error) {
+	var tokens []token
+	for rest := source; rest != ""; {
+		match := matcher.FindString(rest)
+		if match == "" {
+			return nil, fmt.Errorf("unexpected '%s'", rest)
+		}
+		rest = rest[len(match):]
+		switch {
+		case strings.TrimSpace(match) == "":
+			continue
+		case match[0] == '\'' || match[0] == '"':
+			tokens = append(tokens, token{kind: "string", text: match[1 : len(match)-1]})
+		case match[0] == '_' || unicode.IsLetter(rune(match[0])) || unicode.IsDigit(rune(match[0])):
+			tokens = append(tokens, token{kind: "ident", text: match})
+		default:
+			tokens = append(tokens, token{kind: match, text: match})
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty expression")
+	}
+	return tokens, nil
+}
+
+// parser is a recursive descent parser over the tokens of an expression.
+type parser struct {
+	tokens   []token
+	position int
+	// isTags is set when parsing a tag expression, where identifiers are tags
+	isTags bool
+}
+
+func (p *parser) done() bool {
+	return p.position >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: "end", text: "end of expression"}
+	}
+	return p.tokens[p.position]
+}
+
+func (p *parser) expect(kind string) (token, error) {
+	next := p.peek()
+	if next.kind != kind {
+		return next, fmt.Errorf("expected '%s' instead of '%s'", kind, next.text)
+	}
+	p.position++
+	return next, nil
+}
+
+// parseOr parses `and ('||' and)*`
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peek().kind == "||" {
+		p.position++
+		var right node
+		right, err = p.parseAnd()
+		left = logicalNode{operator: "||", left: left, right: right}
+	}
+	return left, err
+}
+
+// parseAnd parses `unary ('&&' unary)*`
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.peek().kind == "&&" {
+		p.position++
+		var right node
+		right, err = p.parseUnary()
+		left = logicalNode{operator: "&&", left: left, right: right}
+	}
+	return left, err
+}
+
+// parseUnary parses `'!' unary | comparison`
+func (p *parser) parseUnary() (node, error) {
+	if p.peek().kind == "!" {
+		p.position++
+		operand, err := p.parseUnary()
+		return notNode{operand: operand}, err
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses `primary (('==' | '!=' | '=~') primary)?`
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	operator := p.peek().kind
+	if p.isTags || (operator != "==" && operator != "!=" && operator != "=~") {
+		return left, nil
+	}
+	p.position++
+	right, err := p.parsePrimary()
+	return comparisonNode{operator: operator, left: left, right: right}, err
+}
+
+// parsePrimary parses `'(' or ')' | string | identifier | function '(' string ')'`
+func (p *parser) parsePrimary() (node, error) {
+	next := p.peek()
+	switch next.kind {
+	case "(":
+		p.position++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(")")
+		return inner, err
+	case "string":
+		p.position++
+		return literalNode{value: stringValue(next.text)}, nil
+	case "ident":
+		p.position++
+		if p.isTags {
+			return tagNode{tag: next.text}, nil
+		}
+		if p.peek().kind != "(" {
+			identifier, exists := identifiers[next.text]
+			if !exists {
+				return nil, fmt.Errorf("unknown identifier '%s'", next.text)
+			}
+			return literalNode{value: identifier}, nil
+		}
+		if !slices.Contains(functions, next.text) {
+			return nil, fmt.Errorf("unknown function '%s', use one of %s", next.text, strings.Join(functions, ", "))
+		}
+		p.position++
+		argument, err := p.expect("string")
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(")")
+		return callNode{function: next.text, argument: argument.text}, err
+	default:
+		return nil, fmt.Errorf("unexpected '%s'", next.text)
+	}
+}
@@ -0,0 +1,157 @@
+package condition
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeResolver resolves the chunks from maps
+type fakeResolver struct {
+	env       map[string]string
+	succeeded map[string]bool
+	outputs   map[string]string
+}
+
+func (r *fakeResolver) Getenv(name string) string {
+	return r.env[name]
+}
+
+func (r *fakeResolver) ChunkSucceeded(ref string) (bool, error) {
+	succeeded, exists := r.succeeded[ref]
+	if !exists {
+		return false, errors.New("unknown chunk " + ref)
+	}
+	return succeeded, nil
+}
+
+func (r *fakeResolver) ChunkFailed(ref string) (bool, error) {
+	succeeded, err := r.ChunkSucceeded(ref)
+	return !succeeded, err
+}
+
+func (r *fakeResolver) ChunkOutput(ref string) (string, error) {
+	return r.outputs[ref], nil
+}
+
+func TestCondition(t *testing.T) {
+	resolver := &fakeResolver{
+		env:       map[string]string{"KUBECONFIG": "/home/user/.kube/config", "EMPTY": ""},
+		succeeded: map[string]bool{"setup/ok": true, "setup/ko": false},
+		outputs:   map[string]string{"setup/ok": "version v2.3.1\n"},
+	}
+	t.Run("evaluate", func(t *testing.T) {
+		testCases := []struct {
+			source   string
+			expected bool
+		}{
+			{"true", true},
+			{"false", false},
+			{"env('KUBECONFIG')", true},
+			{"env(\"KUBECONFIG\")", true},
+			{"env('EMPTY')", false},
+			{"env('UNSET')", false},
+			{"!env('UNSET')", true},
+			{"env('KUBECONFIG') =~ '\\.kube'", true},
+			{"env('KUBECONFIG') == '/home/user/.kube/config'", true},
+			{"env('KUBECONFIG') != '/home/user/.kube/config'", false},
+			{"os == '" + runtime.GOOS + "'", true},
+			{"arch == '" + runtime.GOARCH + "'", true},
+			{"os == 'plan9' || arch == 'mips'", runtime.GOOS == "plan9" || runtime.GOARCH == "mips"},
+			{"command('sh')", true},
+			{"command('this-command-does-not-exist')", false},
+			{"succeeded('setup/ok') && failed('setup/ko')", true},
+			{"succeeded('setup/ko')", false},
+			{"output('setup/ok') =~ 'v2\\.[0-9]+'", true},
+			{"!(succeeded('setup/ok') && env('UNSET')) && true", true},
+			{"false && succeeded('setup/unknown')", false},
+			{"true || succeeded('setup/unknown')", true},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.source, func(t *testing.T) {
+				expression, err := Parse(tc.source)
+				assert.NoError(t, err)
+				result, err := expression.Evaluate(resolver)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, result)
+			})
+		}
+	})
+	t.Run("parse errors", func(t *testing.T) {
+		for _, source := range []string{
+			"",
+			"env('HOME') &&",
+			"env('HOME'",
+			"env(HOME)",
+			"unknown('x')",
+			"platform == 'linux'",
+			"(true",
+			"true true",
+			"'unterminated",
+			"env('HOME') = 'x'",
+		} {
+			t.Run(source, func(t *testing.T) {
+				_, err := Parse(source)
+				assert.Error(t, err)
+			})
+		}
+	})
+	t.Run("evaluation errors", func(t *testing.T) {
+		for _, source := range []string{
+			"succeeded('setup/unknown')",
+			"env('HOME') =~ '('",
+		} {
+			t.Run(source, func(t *testing.T) {
+				expression, err := Parse(source)
+				assert.NoError(t, err)
+				_, err = expression.Evaluate(resolver)
+				assert.Error(t, err)
+			})
+		}
+	})
+	t.Run("chunk references", func(t *testing.T) {
+		expression, err := Parse("!succeeded('setup/ok') && (failed('setup/ko') || output('main/x') == env('X'))")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"setup/ok", "setup/ko", "main/x"}, expression.ChunkReferences())
+		expression, err = Parse("env('X') && command('sh')")
+		assert.NoError(t, err)
+		assert.Empty(t, expression.ChunkReferences())
+	})
+	t.Run("match tags", func(t *testing.T) {
+		tags := []string{"smoke", "k8s-1-30", "2024"}
+		for _, tc := range []struct {
+			source string
+			match  bool
+		}{
+			{"smoke", true},
+			{"slow", false},
+			{"smoke && !slow", true},
+			{"!smoke || slow", false},
+			{"k8s-1-30 && 2024", true},
+			{"(slow || nightly) && smoke", false},
+		} {
+			t.Run(tc.source, func(t *testing.T) {
+				expression, err := ParseTags(tc.source)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.match, expression.MatchTags(tags))
+			})
+		}
+	})
+	t.Run("tags parse errors", func(t *testing.T) {
+		for _, source := range []string{
+			"",
+			"smoke &&",
+			"smoke slow",
+			"smoke == 'x'",
+			"env('HOME')",
+			"-smoke",
+		} {
+			t.Run(source, func(t *testing.T) {
+				_, err := ParseTags(source)
+				assert.Error(t, err)
+			})
+		}
+	})
+}
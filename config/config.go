@@ -3,16 +3,22 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/arkmq-org/markdown-runner/condition"
+	"github.com/arkmq-org/markdown-runner/view"
 	"github.com/pterm/pterm"
 	"github.com/spf13/pflag"
 )
 
 // Config holds all the configuration for the markdown-runner.
 type Config struct {
+	Check             bool
 	DryRun            bool
 	Help              bool
 	IgnoreBreakpoints bool
@@ -31,11 +37,71 @@ type Config struct {
 	DebugFromStage    string
 	DebugFromChunk    string
 	MinutesToTimeout  int
+	GracePeriod       time.Duration
 	UpdateFile        bool
+	UpdateHidden      bool
 	Verbose           bool
+	Stream            bool
 	View              string
 	Env               []string
 	Rootdir           string
+	Normalize         []string
+	ReportJUnit       string
+	Tags              string
+	SkipTags          string
+	Jobs              int
+	ShareEnv          bool
+	StateFile         string
+	RerunFailed       bool
+	Resume            bool
+	// ResumePoints are where the files resume from when resuming the previous
+	// execution, by file. They're read from the state file.
+	ResumePoints map[string]*ResumePoint
+	// RunnerView is the view the feedback about every file goes to, instead
+	// of the one named by View. It's meant for the programs embedding the
+	// runner, and must support concurrent calls when Jobs is greater than 1.
+	RunnerView view.RunnerView
+}
+
+// ResumePoint is where the execution of a file resumes from, along with the
+// environment the chunks had at that point.
+type ResumePoint struct {
+	// Stage is the name of the stage the file resumes from, the previous ones
+	// being skipped
+	Stage string
+	// Chunk is the index of the chunk of the stage the file resumes from, the
+	// previous ones being skipped
+	Chunk int
+	// Env holds the variables the previous chunks set, as KEY=VALUE
+	Env []string
+	// Unset holds the names of the variables the previous chunks unset
+	Unset []string
+	// ShellDefinitions holds the functions and aliases the previous chunks
+	// defined
+	ShellDefinitions string
+}
+
+// DefaultStateFile is the file the outcome of the execution is persisted to
+// when re-running the failed files or resuming them, unless configured
+// otherwise. The outcome isn't persisted by default, it holds the variables
+// the chunks exported.
+const DefaultStateFile = ".markdown-runner-state.json"
+
+// DefaultGracePeriod is the time a stopped command has to exit by itself
+// before getting killed, unless configured otherwise.
+const DefaultGracePeriod = 10 * time.Second
+
+// Defaults returns the configuration used when no flag is set, for the
+// programs embedding the runner to start from.
+func Defaults() *Config {
+	return &Config{
+		MarkdownDir:      "./",
+		Rootdir:          "./",
+		MinutesToTimeout: 10,
+		GracePeriod:      DefaultGracePeriod,
+		View:             "default",
+		Jobs:             1,
+	}
 }
 
 // NewConfig creates a new Config object and parses the command-line flags.
@@ -51,22 +117,36 @@ The default path is the current directory.
 Modes:
   -d, --dry-run              Just list what would be executed without doing it
   -l, --list                 Just list the files found
+      --check                Fail when a chunk output differs from the documented one
 
 Execution Control:
   -i, --interactive          Prompt to press enter between each chunk
   -s, --start-from string    Start from a specific stage (stage or file@stage)
+      --tags string          Run only the chunks whose tags match the expression (e.g. 'smoke && !slow')
+      --skip-tags string     Skip the chunks whose tags match the expression
   -B, --break-at string      Start debugging from a specific stage or chunk (stage, stage/chunkID, or file@stage/chunkID)
   -t, --timeout int          The timeout in minutes for every executed command (default 10)
+      --grace-period duration  The time a stopped command has to exit after SIGTERM before getting killed (default 10s)
   -u, --update-files         Update the chunk output section in the markdown files
+      --update-hidden        Also update the output of the chunks hidden in HTML comments
+      --normalize strings    Normalizers applied to every chunk output (builtin name or 'regex=>replacement')
       --ignore-breakpoints   Ignore the breakpoints
+  -e, --env strings          Set an environment variable for the chunks (KEY=VALUE)
+      --share-env            Pass the variables exported by a file on to the next files
+  -j, --jobs int             The number of files executed concurrently (default 1)
+      --rerun-failed         Execute only the files that didn't pass in the previous execution
+      --resume               Like --rerun-failed, continuing the files from their failing chunk
+      --state-file string    Keep the outcome of the execution, exported variables included, in the file
 
 File Selection:
   -f, --filter string        Run only the files matching the regex
   -r, --recursive            Search for markdown files recursively
 
 Output & Logging:
-      --view string          UI to be used, can be 'default' or 'ci'
+      --view string          UI to be used, can be 'default', 'ci' or 'json'
+      --report-junit string  Write a JUnit XML report of the execution to the given file
   -v, --verbose              Print more logs
+      --stream               Show the output of the commands as it's written
   -q, --quiet                Disable output
       --no-styling           Disable spinners in CLI
 
@@ -76,6 +156,7 @@ Help:
 		fmt.Fprint(os.Stderr, helpText)
 	}
 
+	pflag.BoolVarP(&cfg.Check, "check", "", false, "Fail when a chunk output differs from the documented one")
 	pflag.BoolVarP(&cfg.DryRun, "dry-run", "d", false, "Just list what would be executed without doing it")
 	pflag.BoolVarP(&cfg.Help, "help", "h", false, "Show this help message")
 	pflag.BoolVarP(&cfg.IgnoreBreakpoints, "ignore-breakpoints", "", false, "Ignore the breakpoints")
@@ -86,21 +167,98 @@ Help:
 	pflag.BoolVarP(&cfg.Quiet, "quiet", "q", false, "Disable output")
 	pflag.BoolVarP(&cfg.Recursive, "recursive", "r", false, "Search for markdown files recursively")
 	pflag.StringVarP(&cfg.StartFrom, "start-from", "s", "", "Start from a specific stage (stage or file@stage)")
+	pflag.StringVar(&cfg.Tags, "tags", "", "Run only the chunks whose tags match the expression (e.g. 'smoke && !slow')")
+	pflag.StringVar(&cfg.SkipTags, "skip-tags", "", "Skip the chunks whose tags match the expression")
 	pflag.StringVarP(&cfg.DebugFrom, "break-at", "B", "", "Start debugging from a specific stage or chunk (stage, stage/chunkID, or file@stage/chunkID)")
 	pflag.IntVarP(&cfg.MinutesToTimeout, "timeout", "t", 10, "The timeout in minutes for every executed command")
+	pflag.DurationVar(&cfg.GracePeriod, "grace-period", DefaultGracePeriod, "The time a stopped command has to exit after SIGTERM before getting killed")
 	pflag.BoolVarP(&cfg.UpdateFile, "update-files", "u", false, "Update the chunk output section in the markdown files")
+	pflag.BoolVar(&cfg.UpdateHidden, "update-hidden", false, "Also update the output of the chunks hidden in HTML comments")
+	pflag.StringArrayVar(&cfg.Normalize, "normalize", nil, "Normalizers applied to every chunk output (builtin name or 'regex=>replacement')")
 	pflag.BoolVarP(&cfg.Verbose, "verbose", "v", false, "Print more logs")
-	pflag.StringVar(&cfg.View, "view", "default", "UI to be used, can be 'default' or 'ci'")
+	pflag.BoolVar(&cfg.Stream, "stream", false, "Show the output of the commands as it's written")
+	pflag.StringVar(&cfg.ReportJUnit, "report-junit", "", "Write a JUnit XML report of the execution to the given file")
+	pflag.StringArrayVarP(&cfg.Env, "env", "e", nil, "Set an environment variable for the chunks (KEY=VALUE)")
+	pflag.BoolVar(&cfg.ShareEnv, "share-env", false, "Pass the variables exported by a file on to the next files")
+	pflag.IntVarP(&cfg.Jobs, "jobs", "j", 1, "The number of files executed concurrently")
+	pflag.BoolVar(&cfg.RerunFailed, "rerun-failed", false, "Execute only the files that didn't pass in the previous execution")
+	pflag.BoolVar(&cfg.Resume, "resume", false, "Like --rerun-failed, continuing the files from their failing chunk")
+	pflag.StringVar(&cfg.StateFile, "state-file", "", "Keep the outcome of the execution, exported variables included, in the file")
+	pflag.StringVar(&cfg.View, "view", "default", "UI to be used, can be 'default', 'ci' or 'json'")
 
 	pflag.Parse()
 
+	if len(pflag.Args()) > 1 {
+		pterm.Fatal.Println("Too many positional arguments, please specify only one directory.")
+	}
+
+	if len(pflag.Args()) == 1 {
+		cfg.MarkdownDir = pflag.Arg(0)
+	} else {
+		cfg.MarkdownDir = "./"
+	}
+	cfg.Rootdir = "./"
+
+	if err := cfg.Validate(); err != nil {
+		pterm.Fatal.Println(err)
+	}
+	return cfg
+}
+
+// Validate checks the consistency of the configuration, and splits the
+// start-from and break-at locations into their parts. It returns an error
+// describing the first problem found.
+func (cfg *Config) Validate() error {
+	if cfg.GracePeriod < 0 {
+		return errors.New("Invalid grace period, it can't be negative.")
+	}
+	if cfg.Jobs < 1 {
+		return errors.New("Invalid jobs value, at least one file has to be executed at a time.")
+	}
+	// Following a file step by step doesn't make sense while other files are executed
+	if cfg.Jobs > 1 && (cfg.Interactive || cfg.StartFrom != "" || cfg.DebugFrom != "") {
+		return errors.New("--jobs can't be combined with --interactive, --start-from or --break-at.")
+	}
+
+	// The files are executed in no particular order, they can't pass variables on to each other
+	if cfg.Jobs > 1 && cfg.ShareEnv {
+		return errors.New("--jobs can't be combined with --share-env.")
+	}
+	// The previous execution is known from the state file
+	if (cfg.RerunFailed || cfg.Resume) && cfg.StateFile == "" {
+		cfg.StateFile = DefaultStateFile
+	}
+	// The output of the hidden chunks is written along with the other ones
+	if cfg.UpdateHidden && !cfg.UpdateFile {
+		return errors.New("--update-hidden needs --update-files.")
+	}
+	// Resuming chooses where every file starts from
+	if cfg.Resume && cfg.StartFrom != "" {
+		return errors.New("--resume can't be combined with --start-from.")
+	}
+	for _, variable := range cfg.Env {
+		if key, _, found := strings.Cut(variable, "="); !found || key == "" {
+			return fmt.Errorf("Invalid environment variable '%s', use KEY=VALUE.", variable)
+		}
+	}
+
+	// Validate the tag expressions early, they are used for every file
+	for _, tags := range []string{cfg.Tags, cfg.SkipTags} {
+		if tags == "" {
+			continue
+		}
+		if _, err := condition.ParseTags(tags); err != nil {
+			return err
+		}
+	}
+
 	// Parse start-from format: stage or file@stage
 	if cfg.StartFrom != "" {
 		// Check for file@stage format
 		if strings.Contains(cfg.StartFrom, "@") {
 			atParts := strings.Split(cfg.StartFrom, "@")
 			if len(atParts) != 2 {
-				pterm.Fatal.Println("Invalid start-from format. Use 'stage' or 'file@stage'.")
+				return errors.New("Invalid start-from format. Use 'stage' or 'file@stage'.")
 			}
 			cfg.StartFromFile = atParts[0]
 			cfg.StartFromStage = atParts[1]
@@ -117,7 +275,7 @@ Help:
 		if strings.Contains(cfg.DebugFrom, "@") {
 			atParts := strings.Split(cfg.DebugFrom, "@")
 			if len(atParts) != 2 {
-				pterm.Fatal.Println("Invalid break-at format. Use 'stage', 'stage/chunkID', or 'file@stage/chunkID'.")
+				return errors.New("Invalid break-at format. Use 'stage', 'stage/chunkID', or 'file@stage/chunkID'.")
 			}
 			cfg.DebugFromFile = atParts[0]
 			debugPart = atParts[1]
@@ -135,20 +293,18 @@ Help:
 			cfg.DebugFromStage = parts[0]
 			cfg.DebugFromChunk = parts[1]
 		} else {
-			pterm.Fatal.Println("Invalid break-at format. Use 'stage', 'stage/chunkID', or 'file@stage/chunkID'.")
+			return errors.New("Invalid break-at format. Use 'stage', 'stage/chunkID', or 'file@stage/chunkID'.")
 		}
 	}
+	return nil
+}
 
-	if len(pflag.Args()) > 1 {
-		pterm.Fatal.Println("Too many positional arguments, please specify only one directory.")
-	}
-
-	if len(pflag.Args()) == 1 {
-		cfg.MarkdownDir = pflag.Arg(0)
-	} else {
-		cfg.MarkdownDir = "./"
-	}
-	cfg.Rootdir = "./"
-
-	return cfg
+// ForFile returns a copy of the configuration for a file executed alongside
+// other ones, so that the changes made while executing it, such as to the
+// environment, don't affect the others.
+func (cfg *Config) ForFile() *Config {
+	copy := *cfg
+	copy.Env = slices.Clone(cfg.Env)
+	copy.Normalize = slices.Clone(cfg.Normalize)
+	return &copy
 }
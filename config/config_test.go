@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
@@ -13,6 +14,7 @@ func TestNewConfig(t *testing.T) {
 		testCases := []struct {
 			name              string
 			args              []string
+			check             bool
 			dryRun            bool
 			interactive       bool
 			verbose           bool
@@ -24,13 +26,26 @@ func TestNewConfig(t *testing.T) {
 			filter            string
 			ignoreBreakpoints bool
 			updateFile        bool
+			updateHidden      bool
 			justList          bool
 			noStyling         bool
 			quiet             bool
+			normalize         []string
+			reportJUnit       string
+			tags              string
+			skipTags          string
+			jobs              int
+			gracePeriod       time.Duration
+			env               []string
+			shareEnv          bool
+			stateFile         string
+			rerunFailed       bool
+			resume            bool
 		}{
 			{
 				name:              "long-form flags",
-				args:              []string{"cmd", "--dry-run", "--interactive=true", "--verbose", "--recursive", "--timeout=5", "--start-from=stage2", "--break-at=stage3", "--filter=test.md", "--ignore-breakpoints", "--update-files", "--list", "--no-styling", "--quiet", "/tmp"},
+				args:              []string{"cmd", "--check", "--dry-run", "--interactive=true", "--verbose", "--recursive", "--timeout=5", "--start-from=stage2", "--break-at=stage3", "--filter=test.md", "--ignore-breakpoints", "--update-files", "--update-hidden", "--list", "--no-styling", "--quiet", "--normalize=uuid", "--normalize=[0-9]+ms=>Xms", "--report-junit=report.xml", "--tags=smoke && !slow", "--skip-tags=flaky", "--env=A=1", "--env=B=2=3", "--share-env", "--grace-period=30s", "/tmp"},
+				check:             true,
 				dryRun:            true,
 				interactive:       true,
 				verbose:           true,
@@ -42,13 +57,22 @@ func TestNewConfig(t *testing.T) {
 				filter:            "test.md",
 				ignoreBreakpoints: true,
 				updateFile:        true,
+				updateHidden:      true,
 				justList:          true,
 				noStyling:         true,
 				quiet:             true,
+				normalize:         []string{"uuid", "[0-9]+ms=>Xms"},
+				reportJUnit:       "report.xml",
+				tags:              "smoke && !slow",
+				skipTags:          "flaky",
+				jobs:              1,
+				gracePeriod:       30 * time.Second,
+				env:               []string{"A=1", "B=2=3"},
+				shareEnv:          true,
 			},
 			{
 				name:              "shorthand flags",
-				args:              []string{"cmd", "-d", "-i=true", "-v", "-r", "-t=5", "-s=stage2", "-f=test.md", "-u", "-l", "-q", "/tmp"},
+				args:              []string{"cmd", "-d", "-i=true", "-v", "-r", "-t=5", "-s=stage2", "-f=test.md", "-u", "-l", "-q", "-j=1", "-e=A=1", "/tmp"},
 				dryRun:            true,
 				interactive:       true,
 				verbose:           true,
@@ -63,6 +87,28 @@ func TestNewConfig(t *testing.T) {
 				justList:          true,
 				noStyling:         false,
 				quiet:             true,
+				jobs:              1,
+				gracePeriod:       DefaultGracePeriod,
+				env:               []string{"A=1"},
+			},
+			{
+				name:        "jobs",
+				args:        []string{"cmd", "--jobs=4", "/tmp"},
+				timeout:     10,
+				markdownDir: "/tmp",
+				jobs:        4,
+				gracePeriod: DefaultGracePeriod,
+			},
+			{
+				name:        "previous execution",
+				args:        []string{"cmd", "--rerun-failed", "--resume", "--state-file=state.json", "/tmp"},
+				timeout:     10,
+				markdownDir: "/tmp",
+				jobs:        1,
+				gracePeriod: DefaultGracePeriod,
+				stateFile:   "state.json",
+				rerunFailed: true,
+				resume:      true,
 			},
 		}
 
@@ -77,6 +123,7 @@ func TestNewConfig(t *testing.T) {
 
 				cfg := NewConfig()
 
+				assert.Equal(t, tc.check, cfg.Check)
 				assert.Equal(t, tc.dryRun, cfg.DryRun)
 				assert.Equal(t, tc.interactive, cfg.Interactive)
 				assert.Equal(t, tc.verbose, cfg.Verbose)
@@ -88,9 +135,21 @@ func TestNewConfig(t *testing.T) {
 				assert.Equal(t, tc.filter, cfg.Filter)
 				assert.Equal(t, tc.ignoreBreakpoints, cfg.IgnoreBreakpoints)
 				assert.Equal(t, tc.updateFile, cfg.UpdateFile)
+				assert.Equal(t, tc.updateHidden, cfg.UpdateHidden)
 				assert.Equal(t, tc.justList, cfg.JustList)
 				assert.Equal(t, tc.noStyling, cfg.NoStyling)
 				assert.Equal(t, tc.quiet, cfg.Quiet)
+				assert.Equal(t, tc.normalize, cfg.Normalize)
+				assert.Equal(t, tc.reportJUnit, cfg.ReportJUnit)
+				assert.Equal(t, tc.tags, cfg.Tags)
+				assert.Equal(t, tc.skipTags, cfg.SkipTags)
+				assert.Equal(t, tc.jobs, cfg.Jobs)
+				assert.Equal(t, tc.gracePeriod, cfg.GracePeriod)
+				assert.Equal(t, tc.env, cfg.Env)
+				assert.Equal(t, tc.shareEnv, cfg.ShareEnv)
+				assert.Equal(t, tc.stateFile, cfg.StateFile)
+				assert.Equal(t, tc.rerunFailed, cfg.RerunFailed)
+				assert.Equal(t, tc.resume, cfg.Resume)
 			})
 		}
 	})
@@ -106,6 +165,7 @@ func TestNewConfig(t *testing.T) {
 
 		cfg := NewConfig()
 
+		assert.False(t, cfg.Check, "Expected Check to be false by default")
 		assert.False(t, cfg.DryRun, "Expected DryRun to be false by default")
 		assert.False(t, cfg.Interactive, "Expected Interactive to be false by default")
 		assert.False(t, cfg.Verbose, "Expected Verbose to be false by default")
@@ -120,5 +180,72 @@ func TestNewConfig(t *testing.T) {
 		assert.False(t, cfg.NoStyling, "Expected NoStyling to be false by default")
 		assert.False(t, cfg.Quiet, "Expected Quiet to be false by default")
 		assert.False(t, cfg.Recursive, "Expected Recursive to be false by default")
+		assert.Equal(t, 1, cfg.Jobs, "Expected Jobs to be 1 by default")
+	})
+
+	t.Run("programmatic defaults", func(t *testing.T) {
+		oldArgs := os.Args
+		defer func() {
+			os.Args = oldArgs
+			pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+		}()
+
+		os.Args = []string{"cmd"}
+
+		assert.Equal(t, NewConfig(), Defaults(), "Expected the defaults to match the ones of the flags")
+		assert.NoError(t, Defaults().Validate())
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		cfg := Defaults()
+		cfg.Jobs = 0
+		assert.Error(t, cfg.Validate())
+
+		cfg = Defaults()
+		cfg.GracePeriod = -time.Second
+		assert.Error(t, cfg.Validate())
+
+		cfg = Defaults()
+		assert.Empty(t, cfg.StateFile, "Expected the outcome of the execution not to be kept by default")
+		cfg.RerunFailed = true
+		assert.NoError(t, cfg.Validate())
+		assert.Equal(t, DefaultStateFile, cfg.StateFile)
+
+		cfg = Defaults()
+		cfg.Resume = true
+		cfg.StartFrom = "stage"
+		assert.Error(t, cfg.Validate())
+
+		cfg = Defaults()
+		cfg.UpdateHidden = true
+		assert.Error(t, cfg.Validate())
+
+		cfg = Defaults()
+		cfg.Env = []string{"=value"}
+		assert.Error(t, cfg.Validate())
+
+		cfg = Defaults()
+		cfg.Tags = "smoke &&"
+		assert.Error(t, cfg.Validate())
+
+		cfg = Defaults()
+		cfg.DebugFrom = "file@stage/chunk"
+		assert.NoError(t, cfg.Validate())
+		assert.Equal(t, "file", cfg.DebugFromFile)
+		assert.Equal(t, "stage", cfg.DebugFromStage)
+		assert.Equal(t, "chunk", cfg.DebugFromChunk)
+	})
+
+	t.Run("for file", func(t *testing.T) {
+		cfg := &Config{Env: []string{"A=1"}, Normalize: []string{"uuid"}, View: "ci"}
+		fileCfg := cfg.ForFile()
+		fileCfg.Env = append(fileCfg.Env[:0], "A=2")
+		fileCfg.Normalize[0] = "ansi"
+		fileCfg.Interactive = true
+
+		assert.Equal(t, []string{"A=1"}, cfg.Env, "Expected the environment to be copied")
+		assert.Equal(t, []string{"uuid"}, cfg.Normalize, "Expected the normalizers to be copied")
+		assert.False(t, cfg.Interactive)
+		assert.Equal(t, "ci", fileCfg.View)
 	})
 }
@@ -1,117 +1,96 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"os"
-	"path"
-	"path/filepath"
-	"regexp"
-	"slices"
-	"sort"
+	"os/signal"
+	"syscall"
 
+	"github.com/arkmq-org/markdown-runner/chunk"
 	"github.com/arkmq-org/markdown-runner/config"
+	"github.com/arkmq-org/markdown-runner/mdrunner"
+	"github.com/arkmq-org/markdown-runner/report"
 	"github.com/arkmq-org/markdown-runner/runner"
 	"github.com/pterm/pterm"
 	"github.com/spf13/pflag"
 )
 
-// findMarkdownFiles recursively finds all files in a given path. If the
-// provided path is a file, it will be returned directly. If the path is a
-// directory and recursive is true, it will traverse into subdirectories.
-func findMarkdownFiles(path string, recursive bool) ([]string, error) {
-	info, statErr := os.Stat(path)
-	if statErr != nil {
-		return nil, statErr
-	}
-	if !info.IsDir() {
-		return []string{path}, nil
-	}
-	var files []string
-	dirEntries, err := os.ReadDir(path)
-	if err != nil {
-		return nil, err
-	}
-	for _, entry := range dirEntries {
-		info, statErr := os.Stat(filepath.Join(path, entry.Name()))
-		if statErr != nil {
-			return nil, statErr
-		}
-		if info.IsDir() && !recursive {
-			continue
-		}
-		subDirFiles, err := findMarkdownFiles(filepath.Join(path, entry.Name()), recursive)
-		if err != nil {
-			return nil, err
-		}
-		files = append(files, subDirFiles...)
-	}
-	sort.Strings(files)
-	return files, nil
-}
-
 // main is the entrypoint for the markdown-runner application. It handles
 // command-line flag parsing, finds the markdown files to be executed, and
 // orchestrates the execution process by calling the runner.
 func main() {
 	if err := run(); err != nil {
 		pterm.Error.PrintOnError(err)
+		if errors.Is(err, mdrunner.ErrInterrupted) {
+			os.Exit(130)
+		}
 		os.Exit(1)
 	}
 }
 
-func run() error {
+// interruptContext returns a context done at the first interruption of the
+// process, for the runner to cancel the running commands and execute the
+// teardown stages. The process exits right away at the second interruption,
+// killing the commands still running along with the processes they started,
+// which run in their own process group and don't get the interruption.
+// The returned function stops listening for the interruptions.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-signals; !ok {
+			return
+		}
+		pterm.Warning.Println("Interrupted, executing the teardown stages. Interrupt again to exit right away.")
+		cancel()
+		if _, ok := <-signals; ok {
+			chunk.KillProcessGroups()
+			os.Exit(130)
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		close(signals)
+		cancel()
+	}
+}
+
+func run() (err error) {
 	cfg := config.NewConfig()
 	if cfg.Help {
 		pflag.Usage()
 		return nil
 	}
-	validExtensions := []string{".md", ".MD", ".Markdown", ".markdown"}
-
-	markdown_files, err := findMarkdownFiles(cfg.MarkdownDir, cfg.Recursive)
-	if err != nil {
-		return err
+	var recorders []runner.Recorder
+	if cfg.ReportJUnit != "" {
+		junitReport := report.NewJUnitReport()
+		recorders = append(recorders, junitReport)
+		// the report is written even when a file fails to keep track of what happened
+		defer func() {
+			err = errors.Join(err, junitReport.WriteFile(cfg.ReportJUnit))
+		}()
 	}
 
 	if cfg.NoStyling {
 		pterm.DisableStyling()
 	}
-	if cfg.Quiet {
+	// the json view owns stdout, any other output would break its stream
+	if cfg.Quiet || cfg.View == "json" {
 		pterm.DisableOutput()
 	}
-	cfg.Env = append(cfg.Env, os.Environ()...)
-	workding_directory, err := os.Getwd()
-	if err != nil {
-		return err
-	}
-	cfg.Env = append(cfg.Env, "WORKING_DIR="+workding_directory)
-
-	for _, file := range markdown_files {
-		// avoid parsing files that aren't markdown
-		extension := path.Ext(file)
-		if !slices.Contains(validExtensions, extension) {
-			continue
-		}
-
-		if cfg.Filter != "" {
-			matched, err := regexp.MatchString(cfg.Filter, file)
-			if err != nil {
-				return err
-			}
-			if !matched {
-				pterm.Info.Println("Ignoring", file, "as it does not match the filter:", cfg.Filter)
-				continue
-			}
-		}
-
-		// only list the available files
-		if cfg.JustList {
+	// only list the available files
+	if cfg.JustList {
+		files, err := mdrunner.ListFiles(cfg, cfg.MarkdownDir)
+		for _, file := range files {
 			pterm.Info.Println(file)
-			continue
-		}
-		// parse and execute if possible
-		err := runner.RunMD(cfg, file)
-		if err != nil {
-			return err
 		}
+		return err
 	}
-	return nil
+	// parse and execute if possible
+	ctx, stop := interruptContext()
+	defer stop()
+	_, err = mdrunner.Run(ctx, mdrunner.Options{Config: cfg, Recorders: recorders})
+	return err
 }
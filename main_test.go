@@ -80,6 +80,50 @@ func TestRun(t *testing.T) {
 		pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
 	})
 
+	t.Run("should write a junit report", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "test")
+		assert.NoError(t, err, "Failed to create temp dir")
+		defer os.RemoveAll(tmpDir)
+
+		file1 := filepath.Join(tmpDir, "test.md")
+		err = os.WriteFile(file1, []byte("```bash {\"stage\":\"test\"}\nexit 1\n```\n"), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		reportFile := filepath.Join(tmpDir, "report.xml")
+		os.Args = []string{"markdown-runner", "-q", "--report-junit", reportFile, file1}
+		err = run()
+		assert.Error(t, err, "Expected the failing chunk to fail the run")
+		pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+
+		content, err := os.ReadFile(reportFile)
+		assert.NoError(t, err, "Expected the report to be written despite the failure")
+		assert.Contains(t, string(content), `<testsuite name="`+file1+`"`)
+		assert.Contains(t, string(content), `failures="1"`)
+	})
+
+	t.Run("should isolate the environment of the files", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "test")
+		assert.NoError(t, err, "Failed to create temp dir")
+		defer os.RemoveAll(tmpDir)
+
+		file1 := filepath.Join(tmpDir, "a.md")
+		err = os.WriteFile(file1, []byte("```bash {\"stage\":\"test\", \"runtime\":\"bash\"}\nexport EXPORTED=a\n```\n"), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+		file2 := filepath.Join(tmpDir, "b.md")
+		err = os.WriteFile(file2, []byte("```bash {\"stage\":\"test\", \"runtime\":\"bash\"}\n[ -z \"${EXPORTED:-}\" ]\n[ \"$GIVEN\" = user ]\n```\n"), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		os.Args = []string{"markdown-runner", "-q", "-e", "GIVEN=user", tmpDir}
+		err = run()
+		assert.NoError(t, err, "Expected the variables exported by a file to stay in the file")
+		pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+
+		os.Args = []string{"markdown-runner", "-q", "-e", "GIVEN=user", "--share-env", tmpDir}
+		err = run()
+		assert.Error(t, err, "Expected the variables exported by a file to be shared with the next ones")
+		pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+	})
+
 	t.Run("should not fail with invalid extension", func(t *testing.T) {
 		tmpDir, err := os.MkdirTemp("", "test")
 		assert.NoError(t, err, "Failed to create temp dir")
@@ -106,73 +150,3 @@ func TestMainFunc(t *testing.T) {
 	os.Args = []string{"markdown-runner", "-h"}
 	main()
 }
-
-func TestFindMarkdownFiles(t *testing.T) {
-	// Create a temporary directory for testing
-	tmpDir, err := os.MkdirTemp("", "test")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
-
-	// Create test files and directories
-	//-tmpDir
-	//  |-test.md
-	//  |-subdir
-	//    |-subtest.md
-
-	file1 := filepath.Join(tmpDir, "test.md")
-	if err := os.WriteFile(file1, []byte(""), 0o644); err != nil {
-		t.Fatalf("Failed to write file: %v", err)
-	}
-
-	subDir := filepath.Join(tmpDir, "subdir")
-	if err := os.Mkdir(subDir, 0o755); err != nil {
-		t.Fatalf("Failed to create subdir: %v", err)
-	}
-
-	file2 := filepath.Join(subDir, "subtest.md")
-	if err := os.WriteFile(file2, []byte(""), 0o644); err != nil {
-		t.Fatalf("Failed to write file: %v", err)
-	}
-
-	// Test cases
-	testCases := []struct {
-		name      string
-		path      string
-		recursive bool
-		expected  []string
-	}{
-		{
-			name:      "Single file",
-			path:      file1,
-			recursive: false,
-			expected:  []string{file1},
-		},
-		{
-			name:      "Directory with recursion",
-			path:      tmpDir,
-			recursive: true,
-			expected:  []string{file2, file1},
-		},
-		{
-			name:      "Directory without recursion",
-			path:      tmpDir,
-			recursive: false,
-			expected:  []string{file1},
-		},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			result, err := findMarkdownFiles(tc.path, tc.recursive)
-			assert.NoError(t, err, "findMarkdownFiles returned an error")
-			assert.ElementsMatch(t, tc.expected, result, "The returned files are not as expected")
-		})
-	}
-
-	t.Run("should return error for invalid path", func(t *testing.T) {
-		_, err := findMarkdownFiles("/invalid/path", false)
-		assert.Error(t, err, "Expected an error for an invalid path")
-	})
-}
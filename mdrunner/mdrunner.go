@@ -0,0 +1,242 @@
+// Package mdrunner is the entrypoint for the programs embedding the markdown
+// runner. It executes markdown files from a configuration built in code
+// rather than from the command line, and returns what happened to every
+// chunk.
+package mdrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arkmq-org/markdown-runner/config"
+	"github.com/arkmq-org/markdown-runner/runner"
+	"github.com/arkmq-org/markdown-runner/runnercontext"
+	"github.com/arkmq-org/markdown-runner/stage"
+	"github.com/arkmq-org/markdown-runner/state"
+	"github.com/arkmq-org/markdown-runner/view"
+	"github.com/pterm/pterm"
+)
+
+// MarkdownExtensions are the extensions of the files considered as markdown
+// when looking for the files to execute in a directory.
+var MarkdownExtensions = []string{".md", ".MD", ".Markdown", ".markdown"}
+
+// ErrInterrupted is the error of the files whose execution got interrupted.
+var ErrInterrupted = runnercontext.ErrInterrupted
+
+// Options configures a run.
+type Options struct {
+	// Config is the configuration of the run, config.Defaults() when nil. It
+	// is validated, and left untouched by the run.
+	Config *config.Config
+	// View receives the feedback about every file, instead of the view named
+	// by Config.View.
+	View view.RunnerView
+	// Recorders receive the outcome of every file on top of the Result.
+	Recorders []runner.Recorder
+}
+
+// Result is the outcome of a run.
+type Result struct {
+	mutex sync.Mutex
+	// Files are the outcomes of the executed files, in the order they were
+	// done.
+	Files []*FileResult
+}
+
+// FileResult is the outcome of a markdown file.
+type FileResult struct {
+	// File is the path of the markdown file
+	File string
+	// Stages are the stages of the file along with their chunks, nil if the
+	// file could not be parsed
+	Stages []*stage.Stage
+	// Duration is the time the file took to execute
+	Duration time.Duration
+	// Err is the error the file failed with, if any
+	Err error
+}
+
+// RecordFile implements runner.Recorder
+func (result *Result) RecordFile(file string, stages []*stage.Stage, duration time.Duration, err error) {
+	result.mutex.Lock()
+	defer result.mutex.Unlock()
+	result.Files = append(result.Files, &FileResult{File: file, Stages: stages, Duration: duration, Err: err})
+}
+
+// Run executes the markdown files found in the paths, Config.MarkdownDir when
+// none is given. The chunks inherit the environment of the process, extended
+// with WORKING_DIR and Config.Env.
+//
+// The outcome of the files is persisted to Config.StateFile unless empty, for
+// Config.RerunFailed to leave out the files that passed and Config.Resume to
+// continue the other ones from their failing chunk. It holds the variables the
+// chunks exported, and is left as is by the dry runs.
+//
+// It returns the outcome of the executed files along with the error of the
+// first failing one. The run doesn't start if ctx is already done, and gets
+// interrupted once it's done: the running commands are cancelled, the teardown
+// stages executed, and the files fail with ErrInterrupted.
+func Run(ctx context.Context, opts Options, paths ...string) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cfg := config.Defaults()
+	if opts.Config != nil {
+		// the run consumes some of the settings, such as the start-from stage
+		copied := *opts.Config
+		cfg = &copied
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.View != nil {
+		cfg.RunnerView = opts.View
+	}
+	if len(paths) == 0 {
+		paths = []string{cfg.MarkdownDir}
+	}
+	files, err := ListFiles(cfg, paths...)
+	if err != nil {
+		return nil, err
+	}
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	// every file starts from the same environment, the variables given by the user winning over the others
+	env := append(os.Environ(), "WORKING_DIR="+workingDirectory)
+	cfg.Env = append(env, cfg.Env...)
+
+	result := &Result{}
+	recorders := append([]runner.Recorder{result}, opts.Recorders...)
+	// a dry run doesn't tell whether the files pass
+	if cfg.StateFile != "" && !cfg.DryRun {
+		var runState *state.State
+		runState, files, err = previousExecution(cfg, files)
+		if err != nil {
+			return nil, err
+		}
+		recorders = append(recorders, runState)
+	}
+	return result, runner.RunMDFiles(ctx, cfg, files, recorders...)
+}
+
+// previousExecution returns the state the outcome of the files is recorded
+// to. When re-running the files that failed, it's the state of the previous
+// execution, and the files that passed in it are left out, all the files
+// being executed when there is none. When resuming, the resume points of the
+// files get filled as well.
+func previousExecution(cfg *config.Config, files []string) (*state.State, []string, error) {
+	if !cfg.RerunFailed && !cfg.Resume {
+		runState := state.New(cfg.StateFile, cfg.Env)
+		// the state of the previous execution no longer applies
+		return runState, files, runState.Save()
+	}
+	runState, err := state.Load(cfg.StateFile, cfg.Env)
+	if errors.Is(err, fs.ErrNotExist) {
+		// nothing was executed yet
+		runState = state.New(cfg.StateFile, cfg.Env)
+		return runState, files, runState.Save()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	ui := cfg.RunnerView
+	if ui == nil {
+		ui = view.NewView(cfg.View)
+	}
+	var failed []string
+	for _, file := range files {
+		if runState.Passed(file) {
+			ui.Info(fmt.Sprintf("Ignoring %s as it passed in the previous execution", file))
+			continue
+		}
+		failed = append(failed, file)
+	}
+	if cfg.Resume {
+		cfg.ResumePoints = make(map[string]*config.ResumePoint)
+		for _, file := range failed {
+			if point := runState.ResumePoint(file); point != nil {
+				cfg.ResumePoints[file] = point
+			}
+		}
+	}
+	return runState, failed, nil
+}
+
+// ListFiles returns the markdown files Run would execute for the paths, taking
+// Config.Recursive and Config.Filter into account.
+func ListFiles(cfg *config.Config, paths ...string) ([]string, error) {
+	var filter *regexp.Regexp
+	if cfg.Filter != "" {
+		var err error
+		filter, err = regexp.Compile(cfg.Filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var files []string
+	for _, markdownPath := range paths {
+		found, err := FindMarkdownFiles(markdownPath, cfg.Recursive)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range found {
+			// avoid parsing files that aren't markdown
+			if !slices.Contains(MarkdownExtensions, path.Ext(file)) {
+				continue
+			}
+			if filter != nil && !filter.MatchString(file) {
+				pterm.Info.Println("Ignoring", file, "as it does not match the filter:", cfg.Filter)
+				continue
+			}
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// FindMarkdownFiles recursively finds all files in a given path. If the
+// provided path is a file, it will be returned directly. If the path is a
+// directory and recursive is true, it will traverse into subdirectories.
+func FindMarkdownFiles(path string, recursive bool) ([]string, error) {
+	info, statErr := os.Stat(path)
+	if statErr != nil {
+		return nil, statErr
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range dirEntries {
+		info, statErr := os.Stat(filepath.Join(path, entry.Name()))
+		if statErr != nil {
+			return nil, statErr
+		}
+		if info.IsDir() && !recursive {
+			continue
+		}
+		subDirFiles, err := FindMarkdownFiles(filepath.Join(path, entry.Name()), recursive)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, subDirFiles...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
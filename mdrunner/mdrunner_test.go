@@ -0,0 +1,250 @@
+package mdrunner_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arkmq-org/markdown-runner/config"
+	"github.com/arkmq-org/markdown-runner/mdrunner"
+	"github.com/arkmq-org/markdown-runner/view"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	passing := filepath.Join(tmpDir, "a.md")
+	err := os.WriteFile(passing, []byte("```bash {\"stage\":\"test\", \"runtime\":\"bash\"}\necho $GIVEN\n```\n"), 0o644)
+	assert.NoError(t, err, "Failed to write to temp file")
+	failing := filepath.Join(tmpDir, "b.md")
+	err = os.WriteFile(failing, []byte("```bash {\"stage\":\"test\"}\nexit 1\n```\n"), 0o644)
+	assert.NoError(t, err, "Failed to write to temp file")
+
+	t.Run("it should execute the files with the given view", func(t *testing.T) {
+		cfg := config.Defaults()
+		cfg.Env = []string{"GIVEN=value"}
+		ui := view.NewView("mock")
+		result, err := mdrunner.Run(context.Background(), mdrunner.Options{Config: cfg, View: ui}, passing)
+		assert.NoError(t, err)
+		assert.Len(t, result.Files, 1)
+		assert.Equal(t, passing, result.Files[0].File)
+		assert.NoError(t, result.Files[0].Err)
+		assert.Equal(t, "test", result.Files[0].Stages[0].Name)
+		assert.Equal(t, "value\n", result.Files[0].Stages[0].Chunks[0].Commands[0].Stdout)
+		assert.NotEmpty(t, ui.(*view.MockRunnerView).Calls["StartRun"])
+		assert.Equal(t, []string{"GIVEN=value"}, cfg.Env, "Expected the configuration to be left untouched")
+		assert.NoFileExists(t, config.DefaultStateFile, "Expected the outcome of the execution not to be kept by default")
+	})
+	t.Run("it should return the error of the failing file", func(t *testing.T) {
+		cfg := config.Defaults()
+		cfg.Env = []string{"GIVEN=value"}
+		result, err := mdrunner.Run(context.Background(), mdrunner.Options{Config: cfg, View: view.NewView("mock")}, tmpDir)
+		assert.Error(t, err)
+		assert.Len(t, result.Files, 2)
+		assert.Equal(t, err, result.Files[1].Err)
+	})
+	t.Run("it should reject an invalid configuration", func(t *testing.T) {
+		cfg := config.Defaults()
+		cfg.Jobs = 0
+		_, err := mdrunner.Run(context.Background(), mdrunner.Options{Config: cfg}, passing)
+		assert.Error(t, err)
+	})
+	t.Run("it should not start once the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := mdrunner.Run(ctx, mdrunner.Options{View: view.NewView("mock")}, passing)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestPreviousExecution(t *testing.T) {
+	tmpDir := t.TempDir()
+	passing := filepath.Join(tmpDir, "a.md")
+	err := os.WriteFile(passing, []byte("```bash {\"stage\":\"test\", \"runtime\":\"bash\"}\ntouch $OUT/a.done\n```\n"), 0o644)
+	assert.NoError(t, err, "Failed to write to temp file")
+	failing := filepath.Join(tmpDir, "b.md")
+	err = os.WriteFile(failing, []byte(strings.Join([]string{
+		"```bash {\"stage\":\"init\", \"runtime\":\"bash\"}",
+		"export GREETING=hello",
+		"greet() { echo \"$GREETING $1\"; }",
+		"echo init >> $OUT/b.log",
+		"```",
+		"```bash {\"stage\":\"test\", \"runtime\":\"bash\"}",
+		"echo first >> $OUT/b.log",
+		"```",
+		"```bash {\"stage\":\"test\", \"runtime\":\"bash\"}",
+		"greet world >> $OUT/b.log",
+		"test -f $OUT/fixed",
+		"```",
+		"```bash {\"stage\":\"teardown\", \"runtime\":\"bash\"}",
+		"echo teardown >> $OUT/b.log",
+		"```",
+		"",
+	}, "\n")), 0o644)
+	assert.NoError(t, err, "Failed to write to temp file")
+	newConfig := func() *config.Config {
+		cfg := config.Defaults()
+		cfg.StateFile = filepath.Join(tmpDir, "state.json")
+		cfg.Env = []string{"OUT=" + tmpDir}
+		return cfg
+	}
+	readLog := func() string {
+		content, _ := os.ReadFile(filepath.Join(tmpDir, "b.log"))
+		return string(content)
+	}
+
+	t.Run("it should execute all the files without a previous execution", func(t *testing.T) {
+		cfg := newConfig()
+		cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+		cfg.RerunFailed = true
+		result, err := mdrunner.Run(context.Background(), mdrunner.Options{Config: cfg, View: view.NewView("mock")}, tmpDir)
+		assert.Error(t, err)
+		assert.Len(t, result.Files, 2)
+		assert.FileExists(t, cfg.StateFile)
+		os.Remove(filepath.Join(tmpDir, "b.log"))
+	})
+	t.Run("it should record the outcome of the files", func(t *testing.T) {
+		_, err := mdrunner.Run(context.Background(), mdrunner.Options{Config: newConfig(), View: view.NewView("mock")}, tmpDir)
+		assert.Error(t, err)
+		assert.Equal(t, "init\nfirst\nhello world\nteardown\n", readLog())
+		assert.FileExists(t, filepath.Join(tmpDir, "state.json"))
+	})
+	t.Run("it should leave the outcome as is in a dry run", func(t *testing.T) {
+		recorded, err := os.ReadFile(filepath.Join(tmpDir, "state.json"))
+		assert.NoError(t, err)
+		cfg := newConfig()
+		cfg.DryRun = true
+		_, err = mdrunner.Run(context.Background(), mdrunner.Options{Config: cfg, View: view.NewView("mock")}, tmpDir)
+		assert.NoError(t, err)
+		content, err := os.ReadFile(filepath.Join(tmpDir, "state.json"))
+		assert.NoError(t, err)
+		assert.Equal(t, string(recorded), string(content))
+	})
+	t.Run("it should re-run the files that failed", func(t *testing.T) {
+		os.Remove(filepath.Join(tmpDir, "b.log"))
+		cfg := newConfig()
+		cfg.RerunFailed = true
+		ui := view.NewView("mock")
+		result, err := mdrunner.Run(context.Background(), mdrunner.Options{Config: cfg, View: ui}, tmpDir)
+		assert.Error(t, err)
+		assert.Len(t, result.Files, 1)
+		assert.Equal(t, failing, result.Files[0].File)
+		assert.Contains(t, ui.(*view.MockRunnerView).Calls["Info"], []any{"Ignoring " + passing + " as it passed in the previous execution"})
+		assert.Equal(t, "init\nfirst\nhello world\nteardown\n", readLog(), "Expected the file to be executed from the beginning")
+	})
+	t.Run("it should resume the files from their failing chunk", func(t *testing.T) {
+		os.Remove(filepath.Join(tmpDir, "b.log"))
+		assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "fixed"), nil, 0o644))
+		cfg := newConfig()
+		cfg.Resume = true
+		result, err := mdrunner.Run(context.Background(), mdrunner.Options{Config: cfg, View: view.NewView("mock")}, tmpDir)
+		assert.NoError(t, err)
+		assert.Len(t, result.Files, 1)
+		assert.Equal(t, "hello world\nteardown\n", readLog(), "Expected the environment and the functions to be restored")
+		assert.True(t, result.Files[0].Stages[1].Chunks[0].IsSkipped, "Expected the chunk that passed to be skipped")
+	})
+	t.Run("it should have nothing left to re-run", func(t *testing.T) {
+		cfg := newConfig()
+		cfg.RerunFailed = true
+		result, err := mdrunner.Run(context.Background(), mdrunner.Options{Config: cfg, View: view.NewView("mock")}, tmpDir)
+		assert.NoError(t, err)
+		assert.Empty(t, result.Files)
+	})
+}
+
+func TestListFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.md", "b.markdown", "c.txt"} {
+		err := os.WriteFile(filepath.Join(tmpDir, name), []byte(""), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+	}
+
+	t.Run("it should only list the markdown files", func(t *testing.T) {
+		files, err := mdrunner.ListFiles(config.Defaults(), tmpDir)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(tmpDir, "a.md"), filepath.Join(tmpDir, "b.markdown")}, files)
+	})
+	t.Run("it should apply the filter", func(t *testing.T) {
+		cfg := config.Defaults()
+		cfg.Filter = "b\\."
+		files, err := mdrunner.ListFiles(cfg, tmpDir)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(tmpDir, "b.markdown")}, files)
+
+		cfg.Filter = "["
+		_, err = mdrunner.ListFiles(cfg, tmpDir)
+		assert.Error(t, err, "Expected an error for an invalid regex")
+	})
+}
+
+func TestFindMarkdownFiles(t *testing.T) {
+	// Create a temporary directory for testing
+	tmpDir, err := os.MkdirTemp("", "test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Create test files and directories
+	//-tmpDir
+	//  |-test.md
+	//  |-subdir
+	//    |-subtest.md
+
+	file1 := filepath.Join(tmpDir, "test.md")
+	if err := os.WriteFile(file1, []byte(""), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	subDir := filepath.Join(tmpDir, "subdir")
+	if err := os.Mkdir(subDir, 0o755); err != nil {
+		t.Fatalf("Failed to create subdir: %v", err)
+	}
+
+	file2 := filepath.Join(subDir, "subtest.md")
+	if err := os.WriteFile(file2, []byte(""), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// Test cases
+	testCases := []struct {
+		name      string
+		path      string
+		recursive bool
+		expected  []string
+	}{
+		{
+			name:      "Single file",
+			path:      file1,
+			recursive: false,
+			expected:  []string{file1},
+		},
+		{
+			name:      "Directory with recursion",
+			path:      tmpDir,
+			recursive: true,
+			expected:  []string{file2, file1},
+		},
+		{
+			name:      "Directory without recursion",
+			path:      tmpDir,
+			recursive: false,
+			expected:  []string{file1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := mdrunner.FindMarkdownFiles(tc.path, tc.recursive)
+			assert.NoError(t, err, "FindMarkdownFiles returned an error")
+			assert.ElementsMatch(t, tc.expected, result, "The returned files are not as expected")
+		})
+	}
+
+	t.Run("should return error for invalid path", func(t *testing.T) {
+		_, err := mdrunner.FindMarkdownFiles("/invalid/path", false)
+		assert.Error(t, err, "Expected an error for an invalid path")
+	})
+}
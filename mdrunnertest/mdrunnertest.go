@@ -0,0 +1,98 @@
+// Package mdrunnertest verifies markdown files from go tests, so that the
+// documentation gets executed by go test alongside the unit tests.
+package mdrunnertest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/arkmq-org/markdown-runner/chunk"
+	"github.com/arkmq-org/markdown-runner/config"
+	"github.com/arkmq-org/markdown-runner/mdrunner"
+	"github.com/arkmq-org/markdown-runner/report"
+	"github.com/arkmq-org/markdown-runner/view"
+)
+
+// Run executes a markdown file with the default configuration and the ci
+// view, see RunWith.
+func Run(t *testing.T, file string) *mdrunner.Result {
+	t.Helper()
+	return RunWith(t, mdrunner.Options{}, file)
+}
+
+// RunWith executes a markdown file, relative to the directory of the package
+// under test, and reports every stage as a subtest of t and every chunk as a
+// subtest of its stage. A chunk that failed fails its subtest with the output
+// of the failing command, a chunk that wasn't executed is skipped.
+//
+// The ci view replaces the default one.
+func RunWith(t *testing.T, opts mdrunner.Options, file string) *mdrunner.Result {
+	t.Helper()
+	// the default view redraws spinners, which doesn't suit the output of go test
+	if opts.View == nil && (opts.Config == nil || opts.Config.View == config.Defaults().View) {
+		opts.View = view.NewView("ci")
+	}
+	result, err := mdrunner.Run(context.Background(), opts, file)
+	if result == nil || len(result.Files) == 0 {
+		if err == nil {
+			err = fmt.Errorf("%s is not a markdown file", file)
+		}
+		t.Fatal(err)
+	}
+	if result.Files[0].Stages == nil {
+		t.Fatal(result.Files[0].Err)
+	}
+	check := opts.Config != nil && opts.Config.Check
+	for _, currentStage := range result.Files[0].Stages {
+		t.Run(currentStage.Name, func(t *testing.T) {
+			for index, currentChunk := range currentStage.Chunks {
+				t.Run(subtestName(index, currentChunk), func(t *testing.T) {
+					reportChunk(t, currentStage.Name, index, currentChunk, check)
+				})
+			}
+		})
+	}
+	// the file can fail outside of its chunks, such as when stopping the background ones
+	if err != nil && !t.Failed() {
+		t.Error(err)
+	}
+	return result
+}
+
+// reportChunk fails or skips the subtest of a chunk according to its outcome,
+// the same way the junit report does.
+func reportChunk(t *testing.T, stageName string, index int, currentChunk *chunk.ExecutableChunk, check bool) {
+	testCase := report.NewJUnitTestCase(stageName, index, currentChunk)
+	for _, failure := range testCase.FlakyFailures {
+		t.Logf("flaky: %s\n%s", failure.Message, failure.Content)
+	}
+	switch {
+	case testCase.Skipped != nil:
+		t.Skip(testCase.Skipped.Message)
+	case testCase.Error != nil:
+		t.Error(testCase.Error.Message)
+	case testCase.Failure != nil:
+		for _, failure := range testCase.RerunFailures {
+			t.Logf("previous attempt: %s\n%s", failure.Message, failure.Content)
+		}
+		t.Errorf("%s\nstdout:\n%s\nstderr:\n%s", testCase.Failure.Message, testCase.SystemOut, testCase.SystemErr)
+	case check:
+		if err := currentChunk.CheckOutput(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// subtestName names the subtest of a chunk after its label, its id, or its
+// position within its stage.
+func subtestName(index int, currentChunk *chunk.ExecutableChunk) string {
+	if currentChunk.Label != "" {
+		return currentChunk.Label
+	}
+	if currentChunk.Id != "" {
+		return currentChunk.Id
+	}
+	return strconv.Itoa(index)
+}
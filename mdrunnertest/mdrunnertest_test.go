@@ -0,0 +1,15 @@
+package mdrunnertest_test
+
+import (
+	"testing"
+
+	"github.com/arkmq-org/markdown-runner/mdrunnertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	result := mdrunnertest.Run(t, "testdata/example.md")
+	assert.Len(t, result.Files, 1)
+	assert.NoError(t, result.Files[0].Err)
+	assert.Equal(t, "hello\n", result.Files[0].Stages[1].Chunks[0].Commands[0].Stdout)
+}
@@ -0,0 +1,467 @@
+package parser
+
+import (
+	"strings"
+)
+
+// codeBlock is a fenced code block of a markdown file, recognised the way
+// CommonMark does: opened by at least 3 backticks or tildes indented by up to 3
+// spaces, possibly inside list items and blockquotes, and closed by a fence of
+// the same character at least as long, or by the end of its containers.
+type codeBlock struct {
+	// Fence is the opening fence, such as ``` or ~~~~
+	Fence string
+	// Info is the info string following the opening fence
+	Info string
+	// Prefix precedes the lines of the block in the file: the markers of its
+	// blockquotes, the indentation of its list items and the one of its fence,
+	// such as "> " or "   "
+	Prefix string
+	// Start is the index of the line of the opening fence, End is the index
+	// of the line of the closing fence, or of the line ending the containers
+	// of the block, or the number of lines when the file ends first
+	Start, End int
+	// Closed is set when the block ends with a closing fence on a line of its
+	// own, one followed by the end of an HTML comment leaves it unclosed
+	Closed bool
+	// Hidden is set when the block is in a markdown-runner HTML comment, see
+	// htmlComment
+	Hidden bool
+	// Content holds the lines of the block, without their Prefix
+	Content []string
+}
+
+// hiddenDirective starts the HTML comments holding hidden code blocks:
+//
+//	<!-- markdown-runner
+//	```bash {"stage":"test"}
+//	kubectl wait --for=condition=Ready pod/broker-0
+//	```
+//	-->
+const hiddenDirective = "markdown-runner"
+
+// htmlComment is an HTML comment of a markdown file, which the renderers hide.
+// The code blocks of the ones starting with the hidden directive are parsed,
+// the ones of the other comments are ignored.
+type htmlComment struct {
+	// hidden is set when the comment starts with the hidden directive
+	hidden bool
+	// prefix precedes the lines of the comment in the file, see codeBlock
+	prefix string
+	// start is the index of the line opening the comment
+	start int
+	// closed is set when the comment ends with -->
+	closed bool
+	// lines holds the content of the comment, the first one being the text
+	// following the directive and the last one the text preceding -->
+	lines []string
+}
+
+// container is a blockquote or a list item holding the lines being parsed
+type container struct {
+	quote bool
+	// indent is the indentation of the content of a list item
+	indent int
+}
+
+// parseCodeBlocks returns the fenced code blocks of the given lines, in the
+// order they appear. The blocks held by a block that is neither a chunk nor an
+// output block are returned as well, right after it, for the chunks shown in a
+// ````markdown block to still be executed. The structure of the lines is found
+// with their tabs expanded, see expandTabs, their content keeping them.
+func parseCodeBlocks(lines []string) []*codeBlock {
+	var blocks []*codeBlock
+	var containers []container
+	var current *codeBlock
+	var comment *htmlComment
+	fenceIndent := 0 // the indentation of the opening fence, removed from the content
+	paragraph := false
+	for index, original := range lines {
+		line := expandTabs(original)
+		matched, pos := matchContainers(containers, line)
+		if current != nil {
+			if matched == len(containers) {
+				if isClosingFence(line[pos:], current.Fence) {
+					current.End, current.Closed = index, true
+					blocks = append(blocks, nestedCodeBlocks(current)...)
+					current = nil
+					continue
+				}
+				indent := min(leadingSpaces(line[pos:]), fenceIndent)
+				current.Content = append(current.Content, fromColumn(original, pos+indent))
+				continue
+			}
+			// the containers of the block end, and the block with them
+			current.End = index
+			blocks = append(blocks, nestedCodeBlocks(current)...)
+			current = nil
+		}
+		if comment != nil {
+			if matched == len(containers) {
+				text, closed := fromColumn(original, pos), false
+				if end := strings.Index(text, "-->"); end >= 0 {
+					text, closed = text[:end], true
+				}
+				comment.lines = append(comment.lines, text)
+				if closed {
+					comment.closed = true
+					blocks = append(blocks, comment.codeBlocks()...)
+					comment = nil
+				}
+				continue
+			}
+			// the containers of the comment end, and the comment with them
+			blocks = append(blocks, comment.codeBlocks()...)
+			comment = nil
+		}
+		rest := line[pos:]
+		if matched < len(containers) {
+			if paragraph && !isBlank(rest) && !startsBlock(rest) {
+				// a lazy continuation of the paragraph keeps the containers open
+				continue
+			}
+			containers = containers[:matched]
+		}
+		containers, pos = openContainers(containers, line, pos, paragraph)
+		rest = line[pos:]
+		if fence, info, indent, ok := openingFence(rest); ok {
+			current = &codeBlock{
+				Fence:  fence,
+				Info:   info,
+				Prefix: continuationPrefix(untilColumn(original, pos+indent)),
+				Start:  index,
+				End:    len(lines),
+			}
+			fenceIndent = indent
+			blocks = append(blocks, current)
+			paragraph = false
+			continue
+		}
+		if opened, ok := openingComment(fromColumn(original, pos), continuationPrefix(untilColumn(original, pos)), index); ok {
+			if opened.closed {
+				blocks = append(blocks, opened.codeBlocks()...)
+			} else {
+				comment = opened
+			}
+			paragraph = false
+			continue
+		}
+		paragraph = !isBlank(rest) && !isHeading(rest)
+	}
+	if current != nil {
+		blocks = append(blocks, nestedCodeBlocks(current)...)
+	}
+	if comment != nil {
+		blocks = append(blocks, comment.codeBlocks()...)
+	}
+	return blocks
+}
+
+// openingComment parses the HTML comment the text starts with, the text
+// following the hidden directive or <!-- being its first line.
+func openingComment(text string, prefix string, index int) (*htmlComment, bool) {
+	indent := leadingSpaces(text)
+	if indent > 3 || !strings.HasPrefix(text[indent:], "<!--") {
+		return nil, false
+	}
+	comment := &htmlComment{prefix: prefix, start: index}
+	first := text[indent+len("<!--"):]
+	if directive, found := strings.CutPrefix(strings.TrimLeft(first, " \t"), hiddenDirective); found && (directive == "" || directive[0] == ' ' || directive[0] == '\t') {
+		comment.hidden = true
+		first = strings.TrimLeft(directive, " \t")
+	}
+	if end := strings.Index(first, "-->"); end >= 0 {
+		first, comment.closed = first[:end], true
+	}
+	comment.lines = []string{first}
+	return comment, true
+}
+
+// codeBlocks returns the code blocks of a comment starting with the hidden
+// directive, located in the file.
+func (comment *htmlComment) codeBlocks() []*codeBlock {
+	if !comment.hidden {
+		return nil
+	}
+	blocks := parseCodeBlocks(comment.lines)
+	for _, block := range blocks {
+		if block.Closed && comment.closed && block.End == len(comment.lines)-1 {
+			// the output of the block can't be written between its closing
+			// fence and the end of the comment
+			block.Closed = false
+			block.End++
+		}
+		block.Prefix = comment.prefix + block.Prefix
+		block.Start += comment.start
+		block.End += comment.start
+		block.Hidden = true
+	}
+	return blocks
+}
+
+// lastLine returns the index of the last line of the block
+func (block *codeBlock) lastLine() int {
+	if block.Closed {
+		return block.End
+	}
+	return block.End - 1
+}
+
+// nestedCodeBlocks returns the code blocks held by the content of a block that
+// is neither a chunk nor an output block, located in the file.
+func nestedCodeBlocks(block *codeBlock) []*codeBlock {
+	if isOutputBlock(block) || isChunkBlock(block) {
+		return nil
+	}
+	nested := parseCodeBlocks(block.Content)
+	for _, nestedBlock := range nested {
+		nestedBlock.Prefix = block.Prefix + nestedBlock.Prefix
+		nestedBlock.Start += block.Start + 1
+		nestedBlock.End += block.Start + 1
+	}
+	return nested
+}
+
+// matchContainers returns how many of the containers the line continues, along
+// with the position of the line the content of the last one starts at.
+func matchContainers(containers []container, line string) (int, int) {
+	pos := 0
+	for index, current := range containers {
+		rest := line[pos:]
+		if current.quote {
+			spaces := leadingSpaces(rest)
+			if spaces > 3 || spaces == len(rest) || rest[spaces] != '>' {
+				return index, pos
+			}
+			pos += spaces + 1
+			if pos < len(line) && line[pos] == ' ' {
+				pos++
+			}
+			continue
+		}
+		switch {
+		case isBlank(rest):
+			pos += min(len(rest), current.indent)
+		case leadingSpaces(rest) >= current.indent:
+			pos += current.indent
+		default:
+			return index, pos
+		}
+	}
+	return len(containers), pos
+}
+
+// openContainers opens the blockquotes and list items starting at the given
+// position of the line, and returns the position their content starts at.
+func openContainers(containers []container, line string, pos int, paragraph bool) ([]container, int) {
+	for {
+		rest := line[pos:]
+		spaces := leadingSpaces(rest)
+		if spaces > 3 {
+			return containers, pos
+		}
+		if spaces < len(rest) && rest[spaces] == '>' {
+			containers = append(containers, container{quote: true})
+			pos += spaces + 1
+			if pos < len(line) && line[pos] == ' ' {
+				pos++
+			}
+			paragraph = false
+			continue
+		}
+		width := listMarkerWidth(rest[spaces:], paragraph)
+		if width == 0 {
+			return containers, pos
+		}
+		after := rest[spaces+width:]
+		padding := leadingSpaces(after)
+		if isBlank(after) || padding > 4 {
+			// the content starts on the next line, or is an indented code block
+			padding = 1
+		}
+		containers = append(containers, container{indent: spaces + width + padding})
+		pos += spaces + width + min(padding, len(after))
+		paragraph = false
+	}
+}
+
+// listMarkerWidth returns the width of the list item marker the text starts
+// with, such as "-" or "12.", or 0 when it doesn't start a list item. Only
+// non empty items numbered 1 can interrupt a paragraph.
+func listMarkerWidth(text string, paragraph bool) int {
+	width := 0
+	if text != "" && strings.ContainsRune("-+*", rune(text[0])) {
+		width = 1
+	} else {
+		digits := 0
+		for digits < len(text) && digits < 9 && text[digits] >= '0' && text[digits] <= '9' {
+			digits++
+		}
+		if digits == 0 || digits == len(text) || (text[digits] != '.' && text[digits] != ')') {
+			return 0
+		}
+		if paragraph && text[:digits] != "1" {
+			return 0
+		}
+		width = digits + 1
+	}
+	after := text[width:]
+	if after != "" && after[0] != ' ' && after[0] != '\t' {
+		return 0
+	}
+	if paragraph && isBlank(after) {
+		return 0
+	}
+	return width
+}
+
+// openingFence parses the opening fence the text starts with, returning the
+// fence, its info string and its indentation.
+func openingFence(text string) (fence string, info string, indent int, ok bool) {
+	indent = leadingSpaces(text)
+	if indent > 3 || indent == len(text) || (text[indent] != '`' && text[indent] != '~') {
+		return "", "", 0, false
+	}
+	length := fenceLength(text[indent:])
+	if length < 3 {
+		return "", "", 0, false
+	}
+	fence = text[indent : indent+length]
+	info = strings.TrimSpace(text[indent+length:])
+	if fence[0] == '`' && strings.Contains(info, "`") {
+		return "", "", 0, false
+	}
+	return fence, info, indent, true
+}
+
+// isClosingFence returns whether the text closes the code block opened with
+// the given fence.
+func isClosingFence(text string, fence string) bool {
+	indent := leadingSpaces(text)
+	if indent > 3 || !strings.HasPrefix(text[indent:], fence[:1]) {
+		return false
+	}
+	length := fenceLength(text[indent:])
+	return length >= len(fence) && isBlank(text[indent+length:])
+}
+
+// fenceLength returns how many times the first character of the text repeats
+func fenceLength(text string) int {
+	length := 0
+	for length < len(text) && text[length] == text[0] {
+		length++
+	}
+	return length
+}
+
+// startsBlock returns whether the text starts a code block, an HTML comment, a
+// blockquote or a list item rather than continuing a paragraph.
+func startsBlock(text string) bool {
+	if _, _, _, ok := openingFence(text); ok {
+		return true
+	}
+	if _, ok := openingComment(text, "", 0); ok {
+		return true
+	}
+	spaces := leadingSpaces(text)
+	if spaces > 3 || spaces == len(text) {
+		return false
+	}
+	return text[spaces] == '>' || listMarkerWidth(text[spaces:], true) > 0
+}
+
+// isHeading returns whether the text is an ATX heading, such as "## Usage"
+func isHeading(text string) bool {
+	text = text[min(leadingSpaces(text), 3):]
+	level := 0
+	for level < len(text) && text[level] == '#' {
+		level++
+	}
+	return level >= 1 && level <= 6 && (level == len(text) || text[level] == ' ' || text[level] == '\t')
+}
+
+// continuationPrefix turns the containers opening a line into the ones
+// continuing them on the next lines, the list item markers becoming spaces.
+func continuationPrefix(prefix string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '>' || r == ' ' || r == '\t' {
+			return r
+		}
+		return ' '
+	}, prefix)
+}
+
+// tabStop is the width of the tab stops of the lines, used to expand their tabs
+const tabStop = 4
+
+// expandTabs replaces the tabs of a line with the spaces reaching the next tab
+// stop, for the indentation of the containers and the fences to be counted in
+// columns the way CommonMark does. A column is a byte of the expanded line.
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var expanded strings.Builder
+	for index := 0; index < len(line); index++ {
+		if line[index] == '\t' {
+			expanded.WriteString(strings.Repeat(" ", tabStop-expanded.Len()%tabStop))
+			continue
+		}
+		expanded.WriteByte(line[index])
+	}
+	return expanded.String()
+}
+
+// fromColumn returns the part of a line starting at the given column of its
+// expanded version, see expandTabs. A tab the column is in the middle of is
+// replaced with the spaces remaining up to its tab stop.
+func fromColumn(line string, column int) string {
+	current := 0
+	for index := 0; index < len(line); index++ {
+		if current >= column {
+			return line[index:]
+		}
+		width := 1
+		if line[index] == '\t' {
+			width = tabStop - current%tabStop
+		}
+		if current+width > column {
+			return strings.Repeat(" ", current+width-column) + line[index+1:]
+		}
+		current += width
+	}
+	return ""
+}
+
+// untilColumn returns the part of a line preceding the given column of its
+// expanded version, see expandTabs. A tab the column is in the middle of is
+// replaced with the spaces it spans up to the column.
+func untilColumn(line string, column int) string {
+	current := 0
+	for index := 0; index < len(line); index++ {
+		if current >= column {
+			return line[:index]
+		}
+		width := 1
+		if line[index] == '\t' {
+			width = tabStop - current%tabStop
+		}
+		if current+width > column {
+			return line[:index] + strings.Repeat(" ", column-current)
+		}
+		current += width
+	}
+	return line
+}
+
+func leadingSpaces(text string) int {
+	spaces := 0
+	for spaces < len(text) && text[spaces] == ' ' {
+		spaces++
+	}
+	return spaces
+}
+
+func isBlank(text string) bool {
+	return strings.TrimSpace(text) == ""
+}
@@ -0,0 +1,351 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/arkmq-org/markdown-runner/chunk"
+	"github.com/arkmq-org/markdown-runner/runnercontext"
+	"github.com/arkmq-org/markdown-runner/stage"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Document is a parsed markdown file. It locates every chunk along with its
+// output block, so that the file gets rewritten with the output of the very
+// chunks that were executed.
+type Document struct {
+	// File is the path of the markdown file
+	File string
+	// FrontMatter holds the settings of the file
+	FrontMatter *FrontMatter
+	// Stages holds the chunks of the file grouped by stage
+	Stages []*stage.Stage
+	// Chunks locates the chunks in the file, in the order they are written
+	Chunks []*DocumentChunk
+	// content is the file as it was parsed, lines being its lines without
+	// their line ending, and offsets the position of every line in content
+	content []byte
+	lines   []string
+	offsets []int
+	// outputs locates every output block, the ones not following a chunk
+	// included, they are all replaced when the file is rewritten, and
+	// hiddenOutputs the ones in HTML comments, replaced only when asked to
+	outputs       []Span
+	hiddenOutputs []Span
+}
+
+// Span locates a part of a markdown file.
+type Span struct {
+	// StartLine and EndLine are the first and the last lines, starting at 1
+	StartLine, EndLine int
+	// Start and End are the positions of the first byte and of the byte
+	// following the last line
+	Start, End int
+}
+
+// DocumentChunk locates a chunk in its markdown file.
+type DocumentChunk struct {
+	Chunk *chunk.ExecutableChunk
+	// Fence is the opening fence of the chunk, such as ``` or ~~~~
+	Fence string
+	// Prefix precedes the lines of the chunk in the file, such as "> " in a
+	// blockquote or "   " in a list item
+	Prefix string
+	// Span covers the chunk from its opening fence to its closing one
+	Span Span
+	// Unterminated is set when the chunk has no closing fence, ending with the
+	// file or with its blockquote, list item or HTML comment, no output is
+	// written after it
+	Unterminated bool
+	// Hidden is set when the chunk is in a markdown-runner HTML comment, which
+	// the renderers hide, its output is only written when asked to
+	Hidden bool
+	// Output covers the output block following the chunk, nil if it has none
+	Output *Span
+}
+
+// ParseDocument reads a markdown file from disk, scans it for executable code
+// chunks, and groups them by their defined stage. Code blocks that were
+// previously generated as output by this tool are not executed, their content
+// is attached to the preceding chunk as its expected output instead. The
+// defaults and the tags of the front matter of the file are merged into the
+// metadata of every chunk. The chunks hidden in HTML comments starting with
+// markdown-runner are parsed like the other ones. The chunks including the
+// ones of other files are replaced by them, see includeChunks.
+//
+// file is the name of the markdown file to parse.
+// markdownDir is the directory containing the markdown file.
+// It returns the parsed document, and an error citing the line of the faulty
+// chunk if parsing fails.
+func ParseDocument(ctx *runnercontext.Context, file string, markdownDir string) (*Document, error) {
+	document, err := parseDocument(ctx, file, markdownDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return document, document.checkConditionReferences()
+}
+
+// checkConditionReferences returns an error if the conditions of a chunk refer
+// to a chunk that isn't in the document, once the included chunks are known.
+func (document *Document) checkConditionReferences() error {
+	for _, s := range document.Stages {
+		for _, executableChunk := range s.Chunks {
+			for _, ref := range executableChunk.ConditionReferences() {
+				stageName, chunkId, found := strings.Cut(ref, "/")
+				if !found {
+					return fmt.Errorf("condition error in %s: invalid chunk reference '%s', expected 'stageName/chunkId'", executableChunk.Source, ref)
+				}
+				if stage.FindChunkById(document.Stages, stageName, chunkId) == nil {
+					return fmt.Errorf("condition error in %s: no chunk with the id '%s' in the stage '%s'", executableChunk.Source, chunkId, stageName)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseDocument parses a markdown file included by the given ones, see
+// ParseDocument.
+func parseDocument(ctx *runnercontext.Context, file string, markdownDir string, including []string) (*Document, error) {
+	filePath := path.Join(markdownDir, file)
+	including, err := checkIncludeCycle(including, filePath)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	document := &Document{File: filePath, content: content}
+	document.splitLines()
+	frontMatter, frontMatterLines, err := parseFrontMatter(file, document.lines)
+	if err != nil {
+		return nil, err
+	}
+	document.FrontMatter = frontMatter
+
+	sch, err := jsonschema.CompileString("schema.json", chunkSchema())
+	if err != nil {
+		return nil, err
+	}
+	var chunkStages [][]*chunk.ExecutableChunk
+	var currentStageName string = ""
+	addToStage := func(executableChunk *chunk.ExecutableChunk) {
+		if currentStageName != executableChunk.Stage {
+			chunkStages = append(chunkStages, []*chunk.ExecutableChunk{})
+			currentStageName = executableChunk.Stage
+		}
+		chunkStages[len(chunkStages)-1] = append(chunkStages[len(chunkStages)-1], executableChunk)
+	}
+	var current *DocumentChunk
+	for _, block := range parseCodeBlocks(document.lines[frontMatterLines:]) {
+		block.Start += frontMatterLines
+		block.End += frontMatterLines
+		if isOutputBlock(block) {
+			span := document.span(block.Start, block.lastLine())
+			if block.Hidden {
+				document.hiddenOutputs = append(document.hiddenOutputs, span)
+			} else {
+				document.outputs = append(document.outputs, span)
+			}
+			// only the first output block following a chunk is its expected
+			// output, a hidden chunk getting its output from a hidden block
+			if current != nil && current.Output == nil && current.Hidden == block.Hidden {
+				current.Output = &span
+				current.Chunk.ExpectedOutput = append([]string{}, block.Content...)
+			}
+			continue
+		}
+		if !isChunkBlock(block) {
+			continue
+		}
+		source := chunk.Source{File: filePath, Line: block.Start + 1}
+		params := block.Info[strings.Index(block.Info, "{"):]
+		var v interface{}
+		if err := json.Unmarshal([]byte(params), &v); err != nil {
+			return nil, fmt.Errorf("JSON unmarshal error in %s at line %d: %w in %s", file, source.Line, err, params)
+		}
+		if err := checkIncludeMetadata(v); err != nil {
+			return nil, fmt.Errorf("include error in %s at line %d: %w", file, source.Line, err)
+		}
+		if metadata, ok := v.(map[string]any); ok && (len(frontMatter.Defaults) > 0 || len(frontMatter.Tags) > 0) {
+			frontMatter.applyTo(metadata)
+			merged, err := json.Marshal(metadata)
+			if err != nil {
+				return nil, fmt.Errorf("invalid defaults in the front matter of %s: %w", file, err)
+			}
+			// the defaults are validated as if they were written in JSON
+			params = string(merged)
+			v = nil
+			if err := json.Unmarshal(merged, &v); err != nil {
+				return nil, err
+			}
+		}
+		if err = sch.Validate(v); err != nil {
+			return nil, fmt.Errorf("JSON validation error in %s at line %d: %w in %s", file, source.Line, err, params)
+		}
+		executableChunk, err := initChunk(ctx, params)
+		if err != nil {
+			return nil, fmt.Errorf("chunk initialization error in %s at line %d: %w in %s", file, source.Line, err, params)
+		}
+		if executableChunk.Include != "" {
+			included, err := includeChunks(ctx, filePath, frontMatter, executableChunk.Include, including)
+			if err != nil {
+				return nil, fmt.Errorf("include error in %s at line %d: %w", file, source.Line, err)
+			}
+			for _, includedChunk := range included {
+				addToStage(includedChunk)
+			}
+			// the output blocks following an include belong to none of its chunks
+			current = nil
+			continue
+		}
+		executableChunk.Content = append(executableChunk.Content, block.Content...)
+		executableChunk.Source = source
+		current = &DocumentChunk{
+			Chunk:        executableChunk,
+			Fence:        block.Fence,
+			Prefix:       block.Prefix,
+			Span:         document.span(block.Start, block.lastLine()),
+			Unterminated: !block.Closed,
+			Hidden:       block.Hidden,
+		}
+		document.Chunks = append(document.Chunks, current)
+		addToStage(executableChunk)
+	}
+	for _, chunks := range chunkStages {
+		if s := stage.NewStage(ctx, chunks); s != nil {
+			if !s.IsParallelismConsistent() {
+				return nil, errors.New("inconsistent parallelism found in stage " + s.Name)
+			}
+			document.Stages = append(document.Stages, s)
+		}
+	}
+	return document, nil
+}
+
+// UpdateChunkOutput writes the markdown file as it was parsed, with the
+// captured output of each executed chunk in a new output block directly after
+// it, inside the same blockquotes and list items. The output of the hidden
+// chunks is only written when hidden is set, inside their HTML comment. It
+// writes to a temporary ".out" file next to it and expects the caller to
+// rename it.
+//
+// It returns an error if the file changed since it was parsed, for the changes
+// not to be lost, or if any file operations fail.
+func (document *Document) UpdateChunkOutput(hidden bool) error {
+	current, err := os.ReadFile(document.File)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(current, document.content) {
+		return fmt.Errorf("%s changed during the execution, the output of its chunks is not written", document.File)
+	}
+
+	// write to a temporary file
+	outFile, err := os.Create(document.File + ".out")
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+	writer := bufio.NewWriter(outFile)
+
+	// the previous output blocks are dropped, the new ones are written after
+	// the last line of their chunk
+	dropped := make(map[int]bool)
+	replaced := document.outputs
+	if hidden {
+		replaced = append(slices.Clone(replaced), document.hiddenOutputs...)
+	}
+	for _, output := range replaced {
+		for line := output.StartLine; line <= output.EndLine; line++ {
+			dropped[line] = true
+		}
+	}
+	outputs := make(map[int][]*DocumentChunk)
+	for _, documentChunk := range document.Chunks {
+		if !documentChunk.Unterminated && (hidden || !documentChunk.Hidden) {
+			outputs[documentChunk.Span.EndLine] = append(outputs[documentChunk.Span.EndLine], documentChunk)
+		}
+	}
+
+	for index, line := range document.lines {
+		if !dropped[index+1] {
+			if _, err := writer.WriteString(line + "\n"); err != nil {
+				return err
+			}
+		}
+		for _, documentChunk := range outputs[index+1] {
+			if err := documentChunk.writeOutput(writer); err != nil {
+				return err
+			}
+		}
+	}
+	return writer.Flush()
+}
+
+// writeOutput writes the output of the chunk in a new output block, with the
+// prefix of the chunk on every line.
+func (documentChunk *DocumentChunk) writeOutput(writer *bufio.Writer) error {
+	if !documentChunk.Chunk.HasOutput() {
+		return nil
+	}
+	var output bytes.Buffer
+	outputWriter := bufio.NewWriter(&output)
+	if err := documentChunk.Chunk.WriteOutputTo(len(documentChunk.Fence), outputWriter); err != nil {
+		return err
+	}
+	if err := outputWriter.Flush(); err != nil {
+		return err
+	}
+	for _, line := range strings.SplitAfter(output.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		prefix := documentChunk.Prefix
+		if line == "\n" {
+			prefix = strings.TrimRight(prefix, " \t")
+		}
+		if _, err := writer.WriteString(prefix + line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitLines splits the content of the document in lines, without their line
+// ending, keeping the position of every line.
+func (document *Document) splitLines() {
+	start := 0
+	for start < len(document.content) {
+		end := bytes.IndexByte(document.content[start:], '\n')
+		next := start + end + 1
+		if end < 0 {
+			end = len(document.content) - start
+			next = len(document.content)
+		}
+		line := strings.TrimSuffix(string(document.content[start:start+end]), "\r")
+		document.lines = append(document.lines, line)
+		document.offsets = append(document.offsets, start)
+		start = next
+	}
+	document.offsets = append(document.offsets, len(document.content))
+}
+
+// span returns the span going from the first to the last of the given line
+// indexes.
+func (document *Document) span(first int, last int) Span {
+	return Span{
+		StartLine: first + 1,
+		EndLine:   last + 1,
+		Start:     document.offsets[first],
+		End:       document.offsets[last+1],
+	}
+}
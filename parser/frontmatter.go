@@ -0,0 +1,143 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatterKey is the key of the YAML front matter holding the settings of
+// the runner, the other keys are left to the other tools.
+const frontMatterKey = "markdown-runner"
+
+// FrontMatter holds the settings of a markdown file, set in its YAML front
+// matter under the markdown-runner key:
+//
+//	---
+//	markdown-runner:
+//	  exclusive: true
+//	  defaults: {runtime: bash, rootdir: $tmpdir.main}
+//	---
+type FrontMatter struct {
+	// Exclusive prevents the file from being executed alongside other files,
+	// for instance when it uses resources shared with them.
+	Exclusive bool `yaml:"exclusive"`
+	// Description tells what the file is about, it's shown when the file is
+	// executed.
+	Description string `yaml:"description"`
+	// Tools lists the commands the file requires, it fails right away when
+	// one of them can't be found.
+	Tools []string `yaml:"tools"`
+	// Skip prevents the chunks of the file from being executed.
+	Skip bool `yaml:"skip"`
+	// Timeout bounds the execution of the whole file, which gets interrupted
+	// once it's elapsed.
+	Timeout time.Duration `yaml:"timeout"`
+	// Env sets variables for the chunks of the file.
+	Env map[string]string `yaml:"env"`
+	// Defaults holds metadata merged into the one of every chunk, the chunks
+	// overriding it.
+	Defaults map[string]any `yaml:"defaults"`
+	// Tags are added to the tags of every chunk.
+	Tags []string `yaml:"tags"`
+}
+
+// ReadFrontMatter reads the front matter of a markdown file. It returns an
+// empty FrontMatter if the file has none.
+func ReadFrontMatter(file string) (*FrontMatter, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	frontMatter, _, err := parseFrontMatter(file, strings.Split(string(content), "\n"))
+	return frontMatter, err
+}
+
+// parseFrontMatter parses the front matter the lines of a markdown file start
+// with, returning it along with the number of lines it spans.
+func parseFrontMatter(file string, lines []string) (*FrontMatter, int, error) {
+	frontMatter := &FrontMatter{}
+	// the front matter has to start on the very first line
+	if len(lines) == 0 || strings.TrimRight(lines[0], " \t\r") != "---" {
+		return frontMatter, 0, nil
+	}
+	end := slices.IndexFunc(lines[1:], func(line string) bool {
+		trimmed := strings.TrimRight(line, " \t\r")
+		return trimmed == "---" || trimmed == "..."
+	})
+	// without a closing line, the first line is a horizontal rule
+	if end < 0 {
+		return frontMatter, 0, nil
+	}
+
+	var document map[string]yaml.Node
+	if err := yaml.Unmarshal([]byte(strings.Join(lines[1:end+1], "\n")), &document); err != nil {
+		return nil, 0, fmt.Errorf("%s: invalid front matter: %w", file, err)
+	}
+	settings, exists := document[frontMatterKey]
+	if !exists {
+		return frontMatter, end + 2, nil
+	}
+	if err := settings.Decode(frontMatter); err != nil {
+		return nil, 0, fmt.Errorf("%s: invalid %s front matter: %w", file, frontMatterKey, err)
+	}
+	if frontMatter.Timeout < 0 {
+		return nil, 0, fmt.Errorf("%s: invalid %s front matter: the timeout can't be negative", file, frontMatterKey)
+	}
+	return frontMatter, end + 2, nil
+}
+
+// include adds the variables and the tools of the front matter of an included
+// file to this one, for its chunks to run as they do in their own file. It
+// returns an error if the files set a variable to different values.
+//
+// file is the path of the included file.
+func (frontMatter *FrontMatter) include(included *FrontMatter, file string) error {
+	for name, value := range included.Env {
+		if current, exists := frontMatter.Env[name]; exists && current != value {
+			return fmt.Errorf("%s sets %s to '%s' in its front matter rather than '%s'", file, name, value, current)
+		}
+		if frontMatter.Env == nil {
+			frontMatter.Env = make(map[string]string)
+		}
+		frontMatter.Env[name] = value
+	}
+	for _, tool := range included.Tools {
+		if !slices.Contains(frontMatter.Tools, tool) {
+			frontMatter.Tools = append(frontMatter.Tools, tool)
+		}
+	}
+	return nil
+}
+
+// applyTo merges the defaults and the tags of the file into the metadata of a
+// chunk.
+func (frontMatter *FrontMatter) applyTo(metadata map[string]any) {
+	for key, value := range frontMatter.Defaults {
+		if _, exists := metadata[key]; !exists {
+			metadata[key] = value
+		}
+	}
+	if len(frontMatter.Tags) == 0 {
+		return
+	}
+	chunkTags, ok := metadata["tags"].([]any)
+	if !ok && metadata["tags"] != nil {
+		// left for the schema to report
+		return
+	}
+	var tags []any
+	for _, tag := range frontMatter.Tags {
+		tags = append(tags, tag)
+	}
+	for _, tag := range chunkTags {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	metadata["tags"] = tags
+}
@@ -0,0 +1,98 @@
+package parser
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/arkmq-org/markdown-runner/chunk"
+	"github.com/arkmq-org/markdown-runner/runnercontext"
+)
+
+// includeChunks parses the markdown file an include chunk references and
+// returns the chunks it selects, which keep the location they are written at.
+// The path of the file is relative to the one of the including file, and the
+// part following # selects a stage, a chunk by its stage/id or by its id alone,
+// all the chunks of the file but its teardown ones being returned without it,
+// for them not to be executed in the middle of the including file. The
+// variables and the tools of the front matter of the file are added to the
+// ones of the including file, see FrontMatter.include.
+//
+// from is the path of the including file, and frontMatter its front matter.
+// including holds the files being parsed, to detect the include cycles.
+func includeChunks(ctx *runnercontext.Context, from string, frontMatter *FrontMatter, reference string, including []string) ([]*chunk.ExecutableChunk, error) {
+	target, fragment, _ := strings.Cut(reference, "#")
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(from), target)
+	}
+	document, err := parseDocument(ctx, filepath.Base(target), filepath.Dir(target), including)
+	if err != nil {
+		return nil, err
+	}
+	if err := frontMatter.include(document.FrontMatter, target); err != nil {
+		return nil, err
+	}
+	var chunks []*chunk.ExecutableChunk
+	for _, currentStage := range document.Stages {
+		if currentStage.Name == fragment || fragment == "" && currentStage.Name != "teardown" {
+			chunks = append(chunks, currentStage.Chunks...)
+		}
+	}
+	if len(chunks) > 0 {
+		return chunks, nil
+	}
+	// not a stage, the fragment references a chunk
+	stageName, id, found := strings.Cut(fragment, "/")
+	if !found {
+		stageName, id = "", fragment
+	}
+	for _, currentStage := range document.Stages {
+		for _, currentChunk := range currentStage.Chunks {
+			if currentChunk.Id == id && (stageName == "" || currentChunk.Stage == stageName) {
+				return []*chunk.ExecutableChunk{currentChunk}, nil
+			}
+		}
+	}
+	if fragment == "" {
+		return nil, fmt.Errorf("%s has no chunk to include", target)
+	}
+	return nil, fmt.Errorf("%s has no stage or chunk %s", target, fragment)
+}
+
+// checkIncludeMetadata returns an error when the metadata of an include chunk
+// holds more than the include, which would be lost as the chunk is replaced by
+// the included ones, keeping their own metadata.
+func checkIncludeMetadata(v any) error {
+	metadata, ok := v.(map[string]any)
+	if !ok || metadata["include"] == nil {
+		return nil
+	}
+	var others []string
+	for key := range metadata {
+		if key != "include" {
+			others = append(others, key)
+		}
+	}
+	if len(others) > 0 {
+		slices.Sort(others)
+		return fmt.Errorf("an include chunk can't have other metadata, the included chunks keep theirs: %s", strings.Join(others, ", "))
+	}
+	return nil
+}
+
+// checkIncludeCycle returns an error when the file is one of the files
+// including it, along with the files including the ones it includes otherwise.
+func checkIncludeCycle(including []string, file string) ([]string, error) {
+	absolute, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	for index, includingFile := range including {
+		if includingFile == absolute {
+			cycle := append(slices.Clone(including[index:]), absolute)
+			return nil, fmt.Errorf("include cycle %s", strings.Join(cycle, " -> "))
+		}
+	}
+	return append(slices.Clone(including), absolute), nil
+}
@@ -5,6 +5,7 @@ import (
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/arkmq-org/markdown-runner/chunk"
 	"github.com/arkmq-org/markdown-runner/config"
@@ -13,6 +14,15 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// testRuntime is a runtime registered for the schema validation to accept it
+type testRuntime struct {
+	chunk.CommandRuntime
+}
+
+func (testRuntime) Prepare(c *chunk.ExecutableChunk, tmpDirs map[string]string) error {
+	return nil
+}
+
 func TestParser(t *testing.T) {
 	t.Run("extract stages", func(t *testing.T) {
 		tmpDir, err := os.MkdirTemp("", "test")
@@ -78,8 +88,11 @@ echo "world"
 		assert.Len(t, stages[0].Chunks, 2, "Expected 2 chunks in the stage")
 		assert.Equal(t, `echo "hello"`, stages[0].Chunks[0].Content[0])
 		assert.Equal(t, `echo "world"`, stages[0].Chunks[1].Content[0])
+		assert.Equal(t, []string{"previous output"}, stages[0].Chunks[0].ExpectedOutput, "Expected the output block to be attached to the chunk")
+		assert.Nil(t, stages[0].Chunks[1].ExpectedOutput, "Expected no output block for the second chunk")
 	})
 	t.Run("extract stages errors", func(t *testing.T) {
+		assert.NoError(t, chunk.RegisterRuntime("test-parser-sql", testRuntime{}))
 		testCases := []struct {
 			name        string
 			mdContent   string
@@ -110,6 +123,126 @@ echo "world"
 				mdContent:   "```bash {\"stage\":\"test\", \"runtime\":\"writer\"}\n```",
 				expectError: true,
 			},
+			{
+				name:        "Unknown normalizer",
+				mdContent:   "```bash {\"stage\":\"test\", \"normalize\":[\"unknown\"]}\n```",
+				expectError: true,
+			},
+			{
+				name:        "Invalid normalizer regex",
+				mdContent:   "```bash {\"stage\":\"test\", \"normalize\":[{\"regex\":\"(\"}]}\n```",
+				expectError: true,
+			},
+			{
+				name:        "Unknown runtime",
+				mdContent:   "```bash {\"stage\":\"test\", \"runtime\":\"unknown\"}\n```",
+				expectError: true,
+			},
+			{
+				name:        "Registered runtime",
+				mdContent:   "```sql {\"stage\":\"test\", \"runtime\":\"test-parser-sql\"}\n```",
+				expectError: false,
+			},
+			{
+				name:        "Interpreted runtime",
+				mdContent:   "```python {\"stage\":\"test\", \"runtime\":\"python3\"}\n```",
+				expectError: false,
+			},
+			{
+				name:        "Exec runtime without interpreter",
+				mdContent:   "```ruby {\"stage\":\"test\", \"runtime\":\"exec\"}\n```",
+				expectError: true,
+			},
+			{
+				name:        "Valid normalizers",
+				mdContent:   "```bash {\"stage\":\"test\", \"normalize\":[\"uuid\", {\"regex\":\"[0-9]+ms\", \"replacement\":\"Xms\"}]}\n```",
+				expectError: false,
+			},
+			{
+				name:        "Valid timeout and retries",
+				mdContent:   "```bash {\"stage\":\"test\", \"timeout\":\"1m30s\", \"retries\":3, \"retry_delay\":\"500ms\"}\n```",
+				expectError: false,
+			},
+			{
+				name:        "Invalid timeout",
+				mdContent:   "```bash {\"stage\":\"test\", \"timeout\":\"10\"}\n```",
+				expectError: true,
+			},
+			{
+				name:        "Zero timeout",
+				mdContent:   "```bash {\"stage\":\"test\", \"timeout\":\"0s\"}\n```",
+				expectError: true,
+			},
+			{
+				name:        "Negative retries",
+				mdContent:   "```bash {\"stage\":\"test\", \"retries\":-1}\n```",
+				expectError: true,
+			},
+			{
+				name:        "Valid background chunk",
+				mdContent:   "```bash {\"stage\":\"test\", \"background\":true, \"ready\":{\"tcp\":\"localhost:8080\", \"timeout\":\"2m\"}}\n```",
+				expectError: false,
+			},
+			{
+				name:        "Readiness probe with two checks",
+				mdContent:   "```bash {\"stage\":\"test\", \"background\":true, \"ready\":{\"tcp\":\"localhost:8080\", \"file\":\"ready\"}}\n```",
+				expectError: true,
+			},
+			{
+				name:        "Readiness probe without check",
+				mdContent:   "```bash {\"stage\":\"test\", \"background\":true, \"ready\":{\"timeout\":\"2m\"}}\n```",
+				expectError: true,
+			},
+			{
+				name:        "Readiness probe on a foreground chunk",
+				mdContent:   "```bash {\"stage\":\"test\", \"ready\":{\"file\":\"ready\"}}\n```",
+				expectError: true,
+			},
+			{
+				name:        "Valid exit expectations",
+				mdContent:   "```bash {\"stage\":\"test\", \"expect_exit\":[1, 2], \"expect_stderr\":\"not found\"}\n```\n```bash {\"stage\":\"test\", \"expect_exit\":\"nonzero\"}\n```",
+				expectError: false,
+			},
+			{
+				name:        "Invalid exit expectation",
+				mdContent:   "```bash {\"stage\":\"test\", \"expect_exit\":\"failure\"}\n```",
+				expectError: true,
+			},
+			{
+				name:        "Valid conditions",
+				mdContent:   "```bash {\"stage\":\"test\", \"if\":\"env('HOME') && os == 'linux'\", \"skip_if\":\"!command('kubectl')\"}\n```",
+				expectError: false,
+			},
+			{
+				name:        "Invalid condition",
+				mdContent:   "```bash {\"stage\":\"test\", \"if\":\"env('HOME') &&\"}\n```",
+				expectError: true,
+			},
+			{
+				name:        "Condition referring to an existing chunk",
+				mdContent:   "```bash {\"stage\":\"setup\", \"id\":\"ok\"}\n```\n```bash {\"stage\":\"test\", \"if\":\"succeeded('setup/ok')\", \"skip_if\":\"output('setup/ok') == 'x'\"}\n```",
+				expectError: false,
+			},
+			{
+				name:        "Condition referring to an unknown chunk",
+				mdContent:   "```bash {\"stage\":\"setup\", \"id\":\"ok\"}\n```\n```bash {\"stage\":\"test\", \"if\":\"succeeded('setup/ok') && !failed('nope/x')\"}\n```",
+				expectError: true,
+			},
+			{
+				name:        "Condition with an invalid chunk reference",
+				mdContent:   "```bash {\"stage\":\"test\", \"skip_if\":\"failed('setup')\"}\n```",
+				expectError: true,
+			},
+			{
+				name:        "Valid tags",
+				mdContent:   "```bash {\"stage\":\"test\", \"tags\":[\"smoke\", \"k8s-1-30\"]}\n```",
+				expectError: false,
+			},
+			{
+				name:        "Invalid tag",
+				mdContent:   "```bash {\"stage\":\"test\", \"tags\":[\"smoke && slow\"]}\n```",
+				expectError: true,
+			},
 			{
 				name:        "Missing stage",
 				mdContent:   "```bash {\"invalid_prop\":\"test\"}\n```",
@@ -172,13 +305,14 @@ echo "hello"
 			Cfg:   cfg,
 			RView: ui,
 		}
-		stages, err := ExtractStages(ctx, "test.md", tmpDir)
+		document, err := ParseDocument(ctx, "test.md", tmpDir)
 		assert.NoError(t, err, "Failed to extract stages")
+		stages := document.Stages
 		stages[0].Chunks[0].Commands = []*chunk.RunningCommand{
 			{Stdout: "hello\n"},
 		}
 
-		err = UpdateChunkOutput("test.md", tmpDir, stages)
+		err = document.UpdateChunkOutput(false)
 		assert.NoError(t, err, "Unexpected error")
 
 		updatedContent, err := os.ReadFile(path.Join(tmpDir, "test.md.out"))
@@ -214,8 +348,9 @@ i-will-fail
 			Cfg:   cfg,
 			RView: ui,
 		}
-		stages, err := ExtractStages(ctx, "test.md", tmpDir)
+		document, err := ParseDocument(ctx, "test.md", tmpDir)
 		assert.NoError(t, err, "Failed to extract stages")
+		stages := document.Stages
 		stages[0].Chunks[0].Commands = []*chunk.RunningCommand{
 			{
 				Stdout: "this is stdout",
@@ -223,7 +358,7 @@ i-will-fail
 			},
 		}
 
-		err = UpdateChunkOutput("test.md", tmpDir, stages)
+		err = document.UpdateChunkOutput(false)
 		assert.NoError(t, err, "Unexpected error")
 
 		updatedContent, err := os.ReadFile(path.Join(tmpDir, "test.md.out"))
@@ -256,15 +391,16 @@ this is an error on stderr
 			Cfg:   cfg,
 			RView: ui,
 		}
-		stages, err := ExtractStages(ctx, "test.md", tmpDir)
+		document, err := ParseDocument(ctx, "test.md", tmpDir)
 		assert.NoError(t, err, "Failed to extract stages")
+		stages := document.Stages
 		stages[0].Chunks[0].Commands = []*chunk.RunningCommand{
 			{
 				Stderr: "this is an error on stderr",
 			},
 		}
 
-		err = UpdateChunkOutput("test.md", tmpDir, stages)
+		err = document.UpdateChunkOutput(false)
 		assert.NoError(t, err, "Unexpected error")
 
 		updatedContent, err := os.ReadFile(path.Join(tmpDir, "test.md.out"))
@@ -295,15 +431,16 @@ echo "no output either" > /dev/null
 			Cfg:   cfg,
 			RView: ui,
 		}
-		stages, err := ExtractStages(ctx, "test.md", tmpDir)
+		document, err := ParseDocument(ctx, "test.md", tmpDir)
 		assert.NoError(t, err, "Failed to extract stages")
+		stages := document.Stages
 		stages[0].Chunks[0].Commands = []*chunk.RunningCommand{
 			{Stdout: ""},
 			{Stdout: "output\n"},
 			{Stdout: ""},
 		}
 
-		err = UpdateChunkOutput("test.md", tmpDir, stages)
+		err = document.UpdateChunkOutput(false)
 		assert.NoError(t, err, "Unexpected error")
 
 		updatedContent, err := os.ReadFile(path.Join(tmpDir, "test.md.out"))
@@ -345,15 +482,16 @@ old output
 			Cfg:   cfg,
 			RView: ui,
 		}
-		stages, err := ExtractStages(ctx, "test.md", tmpDir)
+		document, err := ParseDocument(ctx, "test.md", tmpDir)
 		assert.NoError(t, err, "Failed to extract stages")
+		stages := document.Stages
 
 		// Simulate command execution
 		stages[0].Chunks[0].Commands = []*chunk.RunningCommand{
 			{Stdout: "new output\n"},
 		}
 
-		err = UpdateChunkOutput("test.md", tmpDir, stages)
+		err = document.UpdateChunkOutput(false)
 		assert.NoError(t, err, "Unexpected error")
 
 		updatedContent, err := os.ReadFile(path.Join(tmpDir, "test.md.out"))
@@ -378,11 +516,14 @@ new output
 		mdFile := path.Join(tmpDir, "test.md")
 		err = os.WriteFile(mdFile, []byte(""), 0o644)
 		assert.NoError(t, err, "Failed to write to temp file")
+		document, err := ParseDocument(&runnercontext.Context{Cfg: &config.Config{}, RView: view.NewView("mock")}, "test.md", tmpDir)
+		assert.NoError(t, err, "Failed to parse the document")
 
-		err = os.Chmod(tmpDir, 0o444)
+		err = os.Chmod(tmpDir, 0o555)
 		assert.NoError(t, err, "Failed to change directory permissions")
+		defer os.Chmod(tmpDir, 0o755)
 
-		err = UpdateChunkOutput("test.md", tmpDir, nil)
+		err = document.UpdateChunkOutput(false)
 		assert.Error(t, err, "Expected an error when writing to a read-only directory")
 	})
 	t.Run("init chunk error", func(t *testing.T) {
@@ -447,41 +588,332 @@ echo "hello"
 		assert.Len(t, stages, 1, "Expected 1 stage")
 		assert.Len(t, stages[0].Chunks[0].Content, 2, "Should have two lines of content")
 	})
-	t.Run("count opening back quotes", func(t *testing.T) {
+	t.Run("parse code blocks", func(t *testing.T) {
 		testCases := []struct {
-			name     string
-			input    string
-			expected int
+			name      string
+			mdContent string
+			expected  []*codeBlock
 		}{
 			{
-				name:     "Three backticks",
-				input:    "```bash {\"stage\":\"test\"}",
-				expected: 3,
+				name:      "Backticks",
+				mdContent: "````bash {\"stage\":\"test\"}\necho\n```\n`````\n",
+				expected:  []*codeBlock{{Fence: "````", Info: `bash {"stage":"test"}`, Start: 0, End: 3, Closed: true, Content: []string{"echo", "```"}}},
+			},
+			{
+				name:      "Tildes",
+				mdContent: "~~~bash {\"stage\":\"test\"}\necho\n```\n~~~\n",
+				expected:  []*codeBlock{{Fence: "~~~", Info: `bash {"stage":"test"}`, Start: 0, End: 3, Closed: true, Content: []string{"echo", "```"}}},
+			},
+			{
+				name:      "Indented fence",
+				mdContent: "  ```bash\n   echo\n echo\n   ```\n",
+				expected:  []*codeBlock{{Fence: "```", Info: "bash", Prefix: "  ", Start: 0, End: 3, Closed: true, Content: []string{" echo", "echo"}}},
+			},
+			{
+				name:      "Indented code",
+				mdContent: "    ```bash\n    echo\n",
+			},
+			{
+				name:      "Backticks in the info string",
+				mdContent: "``` `bash`\n",
+			},
+			{
+				name:      "Numbered list",
+				mdContent: "1. Run\n\n   ```bash\n   echo\n\n     echo\n   ```\n2. Check\n",
+				expected:  []*codeBlock{{Fence: "```", Info: "bash", Prefix: "   ", Start: 2, End: 6, Closed: true, Content: []string{"echo", "", "  echo"}}},
+			},
+			{
+				name:      "Fence on the list item line",
+				mdContent: "10) ```bash\n    echo\n    ```\n",
+				expected:  []*codeBlock{{Fence: "```", Info: "bash", Prefix: "    ", Start: 0, End: 2, Closed: true, Content: []string{"echo"}}},
+			},
+			{
+				name:      "List item ending the block",
+				mdContent: "- ```bash\n  echo\nafter\n",
+				expected:  []*codeBlock{{Fence: "```", Info: "bash", Prefix: "  ", Start: 0, End: 2, Content: []string{"echo"}}},
+			},
+			{
+				name:      "Lazy continuation",
+				mdContent: "- Run\nthis\n  ```bash\n  echo\n  ```\n",
+				expected:  []*codeBlock{{Fence: "```", Info: "bash", Prefix: "  ", Start: 2, End: 4, Closed: true, Content: []string{"echo"}}},
+			},
+			{
+				name:      "Blockquote",
+				mdContent: "> [!NOTE]\n> ~~~bash\n> echo\n>\n>~~~\n",
+				expected:  []*codeBlock{{Fence: "~~~", Info: "bash", Prefix: "> ", Start: 1, End: 4, Closed: true, Content: []string{"echo", ""}}},
+			},
+			{
+				name:      "Blockquote ending the block",
+				mdContent: "> ```bash\n> echo\necho\n",
+				expected:  []*codeBlock{{Fence: "```", Info: "bash", Prefix: "> ", Start: 0, End: 2, Content: []string{"echo"}}},
+			},
+			{
+				name:      "List in a blockquote",
+				mdContent: "> 1. Run\n>    ```bash\n>    echo\n>    ```\n",
+				expected:  []*codeBlock{{Fence: "```", Info: "bash", Prefix: ">    ", Start: 1, End: 3, Closed: true, Content: []string{"echo"}}},
+			},
+			{
+				name:      "Unclosed",
+				mdContent: "```bash\necho\n",
+				expected:  []*codeBlock{{Fence: "```", Info: "bash", Start: 0, End: 2, Content: []string{"echo"}}},
 			},
 			{
-				name:     "Four backticks",
-				input:    "````bash {\"stage\":\"test\"}",
-				expected: 4,
+				name:      "Chunk shown in a markdown block",
+				mdContent: "- ````markdown\n  ```bash {\"stage\":\"test\"}\n  echo\n  ```\n  ````\n",
+				expected: []*codeBlock{
+					{Fence: "````", Info: "markdown", Prefix: "  ", Start: 0, End: 4, Closed: true, Content: []string{"```bash {\"stage\":\"test\"}", "echo", "```"}},
+					{Fence: "```", Info: `bash {"stage":"test"}`, Prefix: "  ", Start: 1, End: 3, Closed: true, Content: []string{"echo"}},
+				},
 			},
 			{
-				name:     "No backticks",
-				input:    "bash {\"stage\":\"test\"}",
-				expected: 0,
+				name:      "Code shown in a chunk",
+				mdContent: "````md {\"stage\":\"test\"}\n```bash\n```\n````\n",
+				expected:  []*codeBlock{{Fence: "````", Info: `md {"stage":"test"}`, Start: 0, End: 3, Closed: true, Content: []string{"```bash", "```"}}},
 			},
 			{
-				name:     "Backticks in the middle",
-				input:    "bash ``` {\"stage\":\"test\"}",
-				expected: 0,
+				name:      "Tab indented list item",
+				mdContent: "-\tRun\n\t```bash {\"stage\":\"test\"}\n\techo\n\t```\n",
+				expected:  []*codeBlock{{Fence: "```", Info: `bash {"stage":"test"}`, Prefix: "\t", Start: 1, End: 3, Closed: true, Content: []string{"echo"}}},
+			},
+			{
+				name:      "Tab in the middle of the indentation",
+				mdContent: "- ```bash\n \techo\n  ```\n",
+				expected:  []*codeBlock{{Fence: "```", Info: "bash", Prefix: "  ", Start: 0, End: 2, Closed: true, Content: []string{"  echo"}}},
+			},
+			{
+				name:      "Tab indented blockquote",
+				mdContent: ">\t```bash\n>\techo\n>\t```\n",
+				expected:  []*codeBlock{{Fence: "```", Info: "bash", Prefix: ">\t", Start: 0, End: 2, Closed: true, Content: []string{"echo"}}},
+			},
+			{
+				name:      "Tabs in the content",
+				mdContent: "```make\nbuild:\n\tgo build\n```\n",
+				expected:  []*codeBlock{{Fence: "```", Info: "make", Start: 0, End: 3, Closed: true, Content: []string{"build:", "\tgo build"}}},
+			},
+			{
+				name:      "Hidden",
+				mdContent: "<!-- markdown-runner\n```bash {\"stage\":\"test\"}\necho\n```\n-->\n",
+				expected:  []*codeBlock{{Fence: "```", Info: `bash {"stage":"test"}`, Start: 1, End: 3, Closed: true, Hidden: true, Content: []string{"echo"}}},
+			},
+			{
+				name:      "Hidden on the lines of the comment",
+				mdContent: "> <!-- markdown-runner ```bash {\"stage\":\"test\"}\n> echo\n> ``` -->\n",
+				expected:  []*codeBlock{{Fence: "```", Info: `bash {"stage":"test"}`, Prefix: "> ", Start: 0, End: 3, Hidden: true, Content: []string{"echo"}}},
+			},
+			{
+				name:      "Comment",
+				mdContent: "<!--\n```bash {\"stage\":\"test\"}\necho\n```\n-->\n",
+			},
+			{
+				name:      "Comment on one line",
+				mdContent: "<!-- markdown-runnerless -->\n```bash\n```\n",
+				expected:  []*codeBlock{{Fence: "```", Info: "bash", Start: 1, End: 2, Closed: true}},
 			},
 		}
 
 		for _, tc := range testCases {
 			t.Run(tc.name, func(t *testing.T) {
-				actual := countOpeningBackQuotes(tc.input)
-				assert.Equal(t, tc.expected, actual)
+				lines := strings.Split(strings.TrimSuffix(tc.mdContent, "\n"), "\n")
+				assert.Equal(t, tc.expected, parseCodeBlocks(lines))
 			})
 		}
 	})
+	t.Run("extract stages in containers", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "test")
+		assert.NoError(t, err, "Failed to create temp dir")
+		defer os.RemoveAll(tmpDir)
+
+		mdContent := `
+1. Say hello
+
+   ~~~bash {"stage":"test"}
+   echo "hello"
+   ~~~
+   ` + "```" + `shell markdown_runner
+   hello
+   ` + "```" + `
+
+> [!NOTE]
+> ` + "```" + `bash {"stage":"test"}
+> echo "world"
+> ` + "```" + `
+`
+		mdFile := path.Join(tmpDir, "test.md")
+		err = os.WriteFile(mdFile, []byte(mdContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		ctx := &runnercontext.Context{
+			Cfg:   &config.Config{},
+			RView: view.NewView("mock"),
+		}
+		document, err := ParseDocument(ctx, "test.md", tmpDir)
+		assert.NoError(t, err, "Failed to extract stages")
+		stages := document.Stages
+		assert.Len(t, stages, 1, "Expected 1 stage")
+		assert.Len(t, stages[0].Chunks, 2, "Expected the chunks of the list item and of the blockquote")
+		assert.Equal(t, []string{`echo "hello"`}, stages[0].Chunks[0].Content)
+		assert.Equal(t, []string{"hello"}, stages[0].Chunks[0].ExpectedOutput)
+		assert.Equal(t, []string{`echo "world"`}, stages[0].Chunks[1].Content)
+
+		stages[0].Chunks[0].Commands = []*chunk.RunningCommand{{Stdout: "hello\n\nagain\n"}}
+		stages[0].Chunks[1].Commands = []*chunk.RunningCommand{{Stdout: "world\n"}}
+		err = document.UpdateChunkOutput(false)
+		assert.NoError(t, err, "Unexpected error")
+
+		updatedContent, err := os.ReadFile(path.Join(tmpDir, "test.md.out"))
+		assert.NoError(t, err, "Failed to read updated file")
+		expectedContent := `
+1. Say hello
+
+   ~~~bash {"stage":"test"}
+   echo "hello"
+   ~~~
+   ` + "```" + `shell markdown_runner
+   hello
+
+   again
+   ` + "```" + `
+
+> [!NOTE]
+> ` + "```" + `bash {"stage":"test"}
+> echo "world"
+> ` + "```" + `
+> ` + "```" + `shell markdown_runner
+> world
+> ` + "```" + `
+`
+		assert.Equal(t, expectedContent, string(updatedContent))
+	})
+	t.Run("parse document", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		mdContent := "# Title\r\n" +
+			"```bash {\"stage\":\"test\"}\n" +
+			"echo \"hello\"\n" +
+			"```\n" +
+			"```shell markdown_runner\n" +
+			"hello\n" +
+			"```\n" +
+			"\n" +
+			"~~~~bash {\"stage\":\"test\", \"id\":\"unterminated\"}\n" +
+			"echo \"world\""
+		err := os.WriteFile(path.Join(tmpDir, "test.md"), []byte(mdContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		ctx := &runnercontext.Context{Cfg: &config.Config{}, RView: view.NewView("mock")}
+		document, err := ParseDocument(ctx, "test.md", tmpDir)
+		assert.NoError(t, err, "Failed to parse the document")
+		assert.Equal(t, path.Join(tmpDir, "test.md"), document.File)
+		assert.Len(t, document.Chunks, 2)
+
+		first := document.Chunks[0]
+		assert.Same(t, document.Stages[0].Chunks[0], first.Chunk)
+		assert.Equal(t, chunk.Source{File: document.File, Line: 2}, first.Chunk.Source)
+		assert.Equal(t, "```", first.Fence)
+		assert.Equal(t, 2, first.Span.StartLine)
+		assert.Equal(t, 4, first.Span.EndLine)
+		assert.Equal(t, "```bash {\"stage\":\"test\"}\necho \"hello\"\n```\n", mdContent[first.Span.Start:first.Span.End])
+		assert.Equal(t, 5, first.Output.StartLine)
+		assert.Equal(t, 7, first.Output.EndLine)
+		assert.Equal(t, "```shell markdown_runner\nhello\n```\n", mdContent[first.Output.Start:first.Output.End])
+		assert.False(t, first.Unterminated)
+
+		second := document.Chunks[1]
+		assert.Equal(t, "~~~~", second.Fence)
+		assert.Equal(t, 9, second.Span.StartLine)
+		assert.Equal(t, 10, second.Span.EndLine)
+		assert.Equal(t, "~~~~bash {\"stage\":\"test\", \"id\":\"unterminated\"}\necho \"world\"", mdContent[second.Span.Start:second.Span.End])
+		assert.Nil(t, second.Output)
+		assert.True(t, second.Unterminated)
+	})
+	t.Run("update chunk output of the parsed chunks", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		mdContent := `
+` + "```" + `bash {"stage":"test1"}
+echo "hello"
+` + "```" + `
+` + "```" + `shell markdown_runner
+old
+` + "```" + `
+` + "```" + `bash {"stage":"test2"}
+echo "world"
+` + "```" + `
+`
+		mdFile := path.Join(tmpDir, "test.md")
+		err := os.WriteFile(mdFile, []byte(mdContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		ctx := &runnercontext.Context{Cfg: &config.Config{}, RView: view.NewView("mock")}
+		document, err := ParseDocument(ctx, "test.md", tmpDir)
+		assert.NoError(t, err, "Failed to parse the document")
+		// the output goes to the chunk that produced it, whatever the stages
+		document.Stages = document.Stages[1:]
+		document.Stages[0].Chunks[0].Commands = []*chunk.RunningCommand{{Stdout: "world\n"}}
+
+		err = document.UpdateChunkOutput(false)
+		assert.NoError(t, err, "Unexpected error")
+		updatedContent, err := os.ReadFile(mdFile + ".out")
+		assert.NoError(t, err, "Failed to read updated file")
+		expectedContent := `
+` + "```" + `bash {"stage":"test1"}
+echo "hello"
+` + "```" + `
+` + "```" + `bash {"stage":"test2"}
+echo "world"
+` + "```" + `
+` + "```" + `shell markdown_runner
+world
+` + "```" + `
+`
+		assert.Equal(t, expectedContent, string(updatedContent))
+
+		err = os.WriteFile(mdFile, []byte(mdContent+"edited during the execution\n"), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+		err = document.UpdateChunkOutput(false)
+		assert.ErrorContains(t, err, "changed during the execution", "Expected the changes made to the file to be kept")
+	})
+	t.Run("update chunk output of the hidden chunks", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		mdContent := "<!-- markdown-runner\n" +
+			"```bash {\"stage\":\"test\"}\n" +
+			"echo \"hidden\"\n" +
+			"```\n" +
+			"```shell markdown_runner\n" +
+			"old\n" +
+			"```\n" +
+			"-->\n" +
+			"```bash {\"stage\":\"test\"}\n" +
+			"echo \"visible\"\n" +
+			"```\n"
+		mdFile := path.Join(tmpDir, "test.md")
+		err := os.WriteFile(mdFile, []byte(mdContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		ctx := &runnercontext.Context{Cfg: &config.Config{}, RView: view.NewView("mock")}
+		document, err := ParseDocument(ctx, "test.md", tmpDir)
+		assert.NoError(t, err, "Failed to parse the document")
+		assert.Len(t, document.Stages, 1)
+		assert.Len(t, document.Stages[0].Chunks, 2, "Expected the hidden chunk to be executed like the other ones")
+		hidden := document.Chunks[0]
+		assert.True(t, hidden.Hidden)
+		assert.Equal(t, []string{"echo \"hidden\""}, hidden.Chunk.Content)
+		assert.Equal(t, []string{"old"}, hidden.Chunk.ExpectedOutput)
+		assert.False(t, document.Chunks[1].Hidden)
+		hidden.Chunk.Commands = []*chunk.RunningCommand{{Stdout: "hidden\n"}}
+		document.Chunks[1].Chunk.Commands = []*chunk.RunningCommand{{Stdout: "visible\n"}}
+
+		err = document.UpdateChunkOutput(false)
+		assert.NoError(t, err, "Unexpected error")
+		updatedContent, err := os.ReadFile(mdFile + ".out")
+		assert.NoError(t, err, "Failed to read updated file")
+		visibleOutput := "```shell markdown_runner\nvisible\n```\n"
+		assert.Equal(t, mdContent+visibleOutput, string(updatedContent), "Expected the output of the hidden chunk to be left as is")
+
+		err = document.UpdateChunkOutput(true)
+		assert.NoError(t, err, "Unexpected error")
+		updatedContent, err = os.ReadFile(mdFile + ".out")
+		assert.NoError(t, err, "Failed to read updated file")
+		assert.Equal(t, strings.Replace(mdContent, "old", "hidden", 1)+visibleOutput, string(updatedContent))
+	})
 	t.Run("extract stages no chunks", func(t *testing.T) {
 		tmpDir, err := os.MkdirTemp("", "test")
 		assert.NoError(t, err, "Failed to create temp dir")
@@ -530,4 +962,241 @@ This is a markdown file with no executable chunks.
 		assert.Len(t, stages[0].Chunks, 1, "Expected 1 chunk in the stage")
 		assert.Empty(t, stages[0].Chunks[0].Content, "Expected the chunk content to be empty")
 	})
+	t.Run("extract stages with included chunks", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		assert.NoError(t, os.Mkdir(path.Join(tmpDir, "common"), 0o755))
+		setupContent := `---
+markdown-runner:
+  defaults: {runtime: bash}
+  env: {NAMESPACE: broker}
+  tools: [kubectl]
+---
+` + "```" + `bash {"stage":"install", "id":"tools"}
+echo "install tools"
+` + "```" + `
+` + "```" + `bash {"stage":"install"}
+echo "install broker"
+` + "```" + `
+` + "```" + `bash {"stage":"check", "id":"ready"}
+echo "check"
+` + "```" + `
+` + "```" + `bash {"stage":"teardown"}
+echo "uninstall"
+` + "```" + `
+`
+		err := os.WriteFile(path.Join(tmpDir, "common", "setup.md"), []byte(setupContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+		mdContent := `
+` + "```" + `bash {"stage":"install"}
+echo "install first"
+` + "```" + `
+` + "```" + `bash {"include":"common/setup.md#install"}
+# installs the prerequisites
+` + "```" + `
+` + "```" + `shell markdown_runner
+install tools
+` + "```" + `
+` + "```" + `bash {"include":"common/setup.md#ready"}
+` + "```" + `
+` + "```" + `bash {"stage":"test"}
+echo "test"
+` + "```" + `
+`
+		mdFile := path.Join(tmpDir, "test.md")
+		err = os.WriteFile(mdFile, []byte(mdContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		ctx := &runnercontext.Context{Cfg: &config.Config{}, RView: view.NewView("mock")}
+		document, err := ParseDocument(ctx, "test.md", tmpDir)
+		assert.NoError(t, err, "Failed to parse the document")
+		assert.Len(t, document.Stages, 3)
+		assert.Equal(t, "install", document.Stages[0].Name)
+		assert.Len(t, document.Stages[0].Chunks, 3, "Expected the included chunks to join the stage they're included in")
+		included := document.Stages[0].Chunks[1]
+		assert.Equal(t, []string{`echo "install tools"`}, included.Content)
+		assert.Equal(t, "bash", included.Runtime, "Expected the included chunks to follow the front matter of their file")
+		assert.Equal(t, chunk.Source{File: path.Join(tmpDir, "common", "setup.md"), Line: 7}, included.Source)
+		assert.Nil(t, included.ExpectedOutput)
+		assert.Equal(t, 1, included.Index)
+		assert.Equal(t, "check", document.Stages[1].Name)
+		assert.Equal(t, "ready", document.Stages[1].Chunks[0].Id)
+		assert.Equal(t, "test", document.Stages[2].Name)
+		assert.Len(t, document.Chunks, 2, "Expected only the chunks of the file to be written to")
+		assert.Equal(t, map[string]string{"NAMESPACE": "broker"}, document.FrontMatter.Env, "Expected the variables of the included file to be set")
+		assert.Equal(t, []string{"kubectl"}, document.FrontMatter.Tools, "Expected the tools of the included file to be required")
+
+		t.Run("all the chunks but the teardown ones", func(t *testing.T) {
+			err := os.WriteFile(mdFile, []byte("```bash {\"include\":\"common/setup.md\"}\n```\n"), 0o644)
+			assert.NoError(t, err, "Failed to write to temp file")
+			document, err := ParseDocument(ctx, "test.md", tmpDir)
+			assert.NoError(t, err, "Failed to parse the document")
+			var names []string
+			for _, currentStage := range document.Stages {
+				names = append(names, currentStage.Name)
+			}
+			assert.Equal(t, []string{"install", "check"}, names, "Expected the teardown stage not to be executed in the middle of the file")
+
+			err = os.WriteFile(mdFile, []byte("```bash {\"include\":\"common/setup.md#teardown\"}\n```\n"), 0o644)
+			assert.NoError(t, err, "Failed to write to temp file")
+			document, err = ParseDocument(ctx, "test.md", tmpDir)
+			assert.NoError(t, err, "Failed to parse the document")
+			assert.Equal(t, "teardown", document.Stages[0].Name, "Expected the teardown stage to be included when asked to")
+		})
+		t.Run("conflicting variables", func(t *testing.T) {
+			content := "---\nmarkdown-runner:\n  env: {NAMESPACE: other}\n---\n```bash {\"include\":\"common/setup.md#install\"}\n```\n"
+			err := os.WriteFile(mdFile, []byte(content), 0o644)
+			assert.NoError(t, err, "Failed to write to temp file")
+			_, err = ParseDocument(ctx, "test.md", tmpDir)
+			assert.ErrorContains(t, err, "sets NAMESPACE to 'broker' in its front matter rather than 'other'")
+		})
+		t.Run("metadata of the include chunk", func(t *testing.T) {
+			content := "```bash {\"include\":\"common/setup.md#install\", \"tags\":[\"smoke\"], \"if\":\"true\"}\n```\n"
+			err := os.WriteFile(mdFile, []byte(content), 0o644)
+			assert.NoError(t, err, "Failed to write to temp file")
+			_, err = ParseDocument(ctx, "test.md", tmpDir)
+			assert.ErrorContains(t, err, "include error in test.md at line 1")
+			assert.ErrorContains(t, err, "can't have other metadata, the included chunks keep theirs: if, tags")
+
+			content = "---\nmarkdown-runner:\n  defaults: {timeout: 1m}\n  tags: [smoke]\n---\n```bash {\"include\":\"common/setup.md#install\"}\n```\n"
+			err = os.WriteFile(mdFile, []byte(content), 0o644)
+			assert.NoError(t, err, "Failed to write to temp file")
+			_, err = ParseDocument(ctx, "test.md", tmpDir)
+			assert.NoError(t, err, "Expected the front matter of the including file not to be rejected")
+		})
+
+		testCases := []struct {
+			name          string
+			include       string
+			expectedError string
+		}{
+			{name: "missing stage or chunk", include: "common/setup.md#missing", expectedError: "has no stage or chunk missing"},
+			{name: "missing file", include: "missing.md", expectedError: "missing.md"},
+			{name: "cycle", include: "test.md#install", expectedError: "include cycle"},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				content := "```bash {\"include\":\"" + tc.include + "\"}\n```\n"
+				err := os.WriteFile(mdFile, []byte(content), 0o644)
+				assert.NoError(t, err, "Failed to write to temp file")
+				_, err = ParseDocument(ctx, "test.md", tmpDir)
+				assert.ErrorContains(t, err, "include error in test.md at line 1")
+				assert.ErrorContains(t, err, tc.expectedError)
+			})
+		}
+	})
+	t.Run("read front matter settings", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		mdContent := `---
+markdown-runner:
+  description: Install the broker
+  tools: [kubectl, helm]
+  skip: true
+  timeout: 5m
+  env:
+    NAMESPACE: broker
+    REPLICAS: 3
+  defaults: {runtime: bash, rootdir: $tmpdir.main}
+  tags: [slow]
+---
+`
+		mdFile := path.Join(tmpDir, "test.md")
+		err := os.WriteFile(mdFile, []byte(mdContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		frontMatter, err := ReadFrontMatter(mdFile)
+		assert.NoError(t, err)
+		assert.Equal(t, &FrontMatter{
+			Description: "Install the broker",
+			Tools:       []string{"kubectl", "helm"},
+			Skip:        true,
+			Timeout:     5 * time.Minute,
+			Env:         map[string]string{"NAMESPACE": "broker", "REPLICAS": "3"},
+			Defaults:    map[string]any{"runtime": "bash", "rootdir": "$tmpdir.main"},
+			Tags:        []string{"slow"},
+		}, frontMatter)
+	})
+	t.Run("extract stages with front matter defaults", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		mdContent := `---
+markdown-runner:
+  defaults: {runtime: bash, rootdir: $tmpdir.main, retries: 2}
+  tags: [slow]
+---
+` + "```" + `bash {"stage":"test"}
+echo "hello"
+` + "```" + `
+` + "```" + `bash {"stage":"test", "rootdir":"$initial_dir", "tags":["smoke", "slow"]}
+echo "world"
+` + "```" + `
+`
+		mdFile := path.Join(tmpDir, "test.md")
+		err := os.WriteFile(mdFile, []byte(mdContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		ctx := &runnercontext.Context{Cfg: &config.Config{}, RView: view.NewView("mock")}
+		document, err := ParseDocument(ctx, "test.md", tmpDir)
+		assert.NoError(t, err, "Failed to parse the document")
+		chunks := document.Stages[0].Chunks
+		assert.Equal(t, "bash", chunks[0].Runtime)
+		assert.Equal(t, "$tmpdir.main", chunks[0].RootDir)
+		assert.Equal(t, 2, chunks[0].Retries)
+		assert.Equal(t, []string{"slow"}, chunks[0].Tags)
+		assert.Equal(t, 6, chunks[0].Source.Line)
+		assert.Equal(t, "$initial_dir", chunks[1].RootDir, "Expected the chunk to override the defaults")
+		assert.Equal(t, []string{"slow", "smoke"}, chunks[1].Tags)
+
+		err = os.WriteFile(mdFile, []byte(strings.Replace(mdContent, "retries: 2", "retries: many", 1)), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+		_, err = ParseDocument(ctx, "test.md", tmpDir)
+		assert.ErrorContains(t, err, "JSON validation error in test.md at line 6", "Expected the defaults to be validated with the chunk")
+	})
+	t.Run("extract stages after a horizontal rule", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		mdContent := "---\n# Title\n```bash {\"stage\":\"test\"}\necho \"hello\"\n```\n"
+		err := os.WriteFile(path.Join(tmpDir, "test.md"), []byte(mdContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		ctx := &runnercontext.Context{Cfg: &config.Config{}, RView: view.NewView("mock")}
+		document, err := ParseDocument(ctx, "test.md", tmpDir)
+		assert.NoError(t, err, "Expected a first line never closed to be a horizontal rule")
+		assert.Len(t, document.Stages, 1)
+		assert.Equal(t, 3, document.Stages[0].Chunks[0].Source.Line)
+	})
+	t.Run("read front matter", func(t *testing.T) {
+		testCases := []struct {
+			name        string
+			mdContent   string
+			exclusive   bool
+			expectError bool
+		}{
+			{name: "no front matter", mdContent: "# Title\n---\nmarkdown-runner:\n  exclusive: true\n---\n"},
+			{name: "front matter of other tools", mdContent: "---\ntitle: Getting started\n---\n# Title\n"},
+			{name: "exclusive", mdContent: "---\ntitle: Getting started\nmarkdown-runner:\n  exclusive: true\n---\n# Title\n", exclusive: true},
+			{name: "closed with dots", mdContent: "---\nmarkdown-runner: {exclusive: true}\n...\n", exclusive: true},
+			{name: "horizontal rule", mdContent: "---\nmarkdown-runner:\n  exclusive: true\n"},
+			{name: "invalid yaml", mdContent: "---\nmarkdown-runner: [\n---\n", expectError: true},
+			{name: "invalid timeout", mdContent: "---\nmarkdown-runner:\n  timeout: soon\n---\n", expectError: true},
+			{name: "negative timeout", mdContent: "---\nmarkdown-runner:\n  timeout: -5m\n---\n", expectError: true},
+			{name: "invalid setting", mdContent: "---\nmarkdown-runner:\n  exclusive: maybe\n---\n", expectError: true},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				tmpDir, err := os.MkdirTemp("", "test")
+				assert.NoError(t, err, "Failed to create temp dir")
+				defer os.RemoveAll(tmpDir)
+
+				mdFile := path.Join(tmpDir, "test.md")
+				err = os.WriteFile(mdFile, []byte(tc.mdContent), 0o644)
+				assert.NoError(t, err, "Failed to write to temp file")
+
+				frontMatter, err := ReadFrontMatter(mdFile)
+				if tc.expectError {
+					assert.Error(t, err)
+					return
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, tc.exclusive, frontMatter.Exclusive)
+			})
+		}
+	})
 }
@@ -0,0 +1,257 @@
+// Package report builds machine-readable reports out of the executed markdown
+// files, so that the results can be ingested by CI systems.
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arkmq-org/markdown-runner/chunk"
+	"github.com/arkmq-org/markdown-runner/stage"
+)
+
+// JUnitTestSuites is the root element of a JUnit XML report.
+type JUnitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []JUnitTestSuite `xml:"testsuite"`
+}
+
+// JUnitTestSuite holds the results of a single markdown file.
+type JUnitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []JUnitTestCase `xml:"testcase"`
+}
+
+// JUnitTestCase holds the result of a single chunk. The failed attempts of a
+// retried chunk are reported as flaky failures when it eventually succeeded and
+// as rerun failures otherwise, like surefire does for rerun tests.
+type JUnitTestCase struct {
+	Name          string         `xml:"name,attr"`
+	ClassName     string         `xml:"classname,attr"`
+	File          string         `xml:"file,attr,omitempty"`
+	Line          int            `xml:"line,attr,omitempty"`
+	Time          string         `xml:"time,attr"`
+	Skipped       *JUnitMessage  `xml:"skipped,omitempty"`
+	Failure       *JUnitMessage  `xml:"failure,omitempty"`
+	Error         *JUnitMessage  `xml:"error,omitempty"`
+	FlakyFailures []JUnitMessage `xml:"flakyFailure,omitempty"`
+	RerunFailures []JUnitMessage `xml:"rerunFailure,omitempty"`
+	SystemOut     string         `xml:"system-out,omitempty"`
+	SystemErr     string         `xml:"system-err,omitempty"`
+}
+
+// JUnitMessage is the content of a skipped, failure or error element.
+type JUnitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Content string `xml:",chardata"`
+}
+
+// JUnitReport collects the results of every executed markdown file to write
+// them as a JUnit XML report. It is safe to record files concurrently.
+type JUnitReport struct {
+	mutex  sync.Mutex
+	suites []JUnitTestSuite
+}
+
+// NewJUnitReport returns an empty JUnit report.
+func NewJUnitReport() *JUnitReport {
+	return &JUnitReport{}
+}
+
+// RecordFile adds a test suite for the given markdown file, with a test case
+// for every chunk of its stages. When the file failed without any chunk being
+// at fault, for instance when it could not be parsed, the suite gets an extra
+// test case holding the error.
+func (r *JUnitReport) RecordFile(file string, stages []*stage.Stage, duration time.Duration, err error) {
+	suite := JUnitTestSuite{
+		Name:      file,
+		Time:      formatSeconds(duration),
+		Timestamp: time.Now().Add(-duration).Format(time.RFC3339),
+	}
+	for _, currentStage := range stages {
+		for index, currentChunk := range currentStage.Chunks {
+			suite.TestCases = append(suite.TestCases, NewJUnitTestCase(currentStage.Name, index, currentChunk))
+		}
+	}
+	for _, testCase := range suite.TestCases {
+		switch {
+		case testCase.Failure != nil:
+			suite.Failures++
+		case testCase.Error != nil:
+			suite.Errors++
+		case testCase.Skipped != nil:
+			suite.Skipped++
+		}
+	}
+	// errors that can't be attributed to a chunk, such as parsing errors, get
+	// their own test case so that the suite doesn't look successful
+	if err != nil && suite.Failures == 0 && suite.Errors == 0 {
+		suite.Errors++
+		suite.TestCases = append(suite.TestCases, JUnitTestCase{
+			Name:      "file",
+			ClassName: file,
+			Time:      formatSeconds(0),
+			Error:     &JUnitMessage{Message: err.Error(), Type: "Error"},
+		})
+	}
+	suite.Tests = len(suite.TestCases)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.suites = append(r.suites, suite)
+}
+
+// WriteFile writes the report as JUnit XML to the given path.
+func (r *JUnitReport) WriteFile(path string) error {
+	r.mutex.Lock()
+	root := JUnitTestSuites{Suites: r.suites}
+	r.mutex.Unlock()
+	var total float64
+	for _, suite := range root.Suites {
+		root.Tests += suite.Tests
+		root.Failures += suite.Failures
+		root.Errors += suite.Errors
+		root.Skipped += suite.Skipped
+		seconds, _ := strconv.ParseFloat(suite.Time, 64)
+		total += seconds
+	}
+	root.Time = strconv.FormatFloat(total, 'f', 3, 64)
+	content, err := xml.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(content, '\n')...), 0o644)
+}
+
+// NewJUnitTestCase builds the test case for a chunk, index being its position
+// within its stage.
+func NewJUnitTestCase(stageName string, index int, currentChunk *chunk.ExecutableChunk) JUnitTestCase {
+	testCase := JUnitTestCase{
+		Name:      testCaseName(stageName, index, currentChunk),
+		ClassName: stageName,
+		File:      currentChunk.Source.File,
+		Line:      currentChunk.Source.Line,
+	}
+	var duration time.Duration
+	var stdout, stderr strings.Builder
+	var attemptFailures []JUnitMessage
+	for _, commands := range currentChunk.PreviousAttempts {
+		for _, command := range commands {
+			duration += command.Duration
+		}
+		if failure := commandsFailure(commands); failure != nil {
+			attemptFailures = append(attemptFailures, *failure)
+		}
+	}
+	for _, command := range currentChunk.Commands {
+		duration += command.Duration
+		stdout.WriteString(command.Stdout)
+		stderr.WriteString(command.Stderr)
+	}
+	testCase.Time = formatSeconds(duration)
+	testCase.SystemOut = stdout.String()
+	testCase.SystemErr = stderr.String()
+
+	switch {
+	case currentChunk.IsSkipped:
+		testCase.Skipped = &JUnitMessage{Message: currentChunk.SkipReason}
+	case !currentChunk.HasStarted:
+		testCase.Skipped = &JUnitMessage{Message: "not executed"}
+	case currentChunk.Runtime == "writer":
+		// a writer chunk has no command, it failed if its file wasn't written
+		if currentChunk.WriteError != nil {
+			testCase.Failure = &JUnitMessage{Message: currentChunk.WriteError.Error(), Type: "Write"}
+		}
+	case currentChunk.Context != nil && currentChunk.Context.Cfg.DryRun:
+		testCase.Skipped = &JUnitMessage{Message: "dry run"}
+	case currentChunk.ReadyError != nil:
+		testCase.Failure = &JUnitMessage{Message: currentChunk.ReadyError.Error(), Type: "Readiness"}
+	case len(currentChunk.Commands) == 0:
+		testCase.Failure = &JUnitMessage{Message: "the chunk could not be prepared for execution"}
+	case isInterrupted(currentChunk.Commands):
+		testCase.Error = &JUnitMessage{Message: "the execution was interrupted", Type: "Interrupted"}
+	default:
+		testCase.Failure = commandsFailure(currentChunk.Commands)
+	}
+	if testCase.Failure != nil {
+		testCase.RerunFailures = attemptFailures
+	} else {
+		testCase.FlakyFailures = attemptFailures
+	}
+	return testCase
+}
+
+// commandsFailure returns the failure of the first of the commands that did not
+// execute correctly, nil if they all succeeded.
+func commandsFailure(commands []*chunk.RunningCommand) *JUnitMessage {
+	for _, command := range commands {
+		// the user chose not to execute the command in interactive mode
+		if command.Cmd == nil {
+			continue
+		}
+		exitCode, hasRun := command.ExitCode()
+		if !hasRun {
+			return &JUnitMessage{Message: fmt.Sprintf("%s did not run to completion", command.CmdPrettyName)}
+		}
+		if command.HasSucceeded() {
+			continue
+		}
+		if command.ExpectExit.Matches(exitCode) {
+			return &JUnitMessage{
+				Message: fmt.Sprintf("%s has an unexpected stderr", command.CmdPrettyName),
+				Type:    "Stderr",
+				Content: command.Stderr,
+			}
+		}
+		return &JUnitMessage{
+			Message: fmt.Sprintf("%s failed with exit code %d, expected %s", command.CmdPrettyName, exitCode, command.ExpectExit),
+			Type:    "ExitCode",
+			Content: command.Stderr,
+		}
+	}
+	return nil
+}
+
+// isInterrupted tells whether one of the commands got cancelled because the
+// execution was interrupted.
+func isInterrupted(commands []*chunk.RunningCommand) bool {
+	for _, command := range commands {
+		if command.Interrupted {
+			return true
+		}
+	}
+	return false
+}
+
+// testCaseName names a chunk after its label, its stage/id reference, or its
+// position within its stage.
+func testCaseName(stageName string, index int, currentChunk *chunk.ExecutableChunk) string {
+	if currentChunk.Label != "" {
+		return currentChunk.Label
+	}
+	if currentChunk.Id != "" {
+		return stageName + "/" + currentChunk.Id
+	}
+	return stageName + "/" + strconv.Itoa(index)
+}
+
+// formatSeconds formats a duration in seconds as expected by JUnit.
+func formatSeconds(duration time.Duration) string {
+	return strconv.FormatFloat(duration.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,200 @@
+package report
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/arkmq-org/markdown-runner/chunk"
+	"github.com/arkmq-org/markdown-runner/config"
+	"github.com/arkmq-org/markdown-runner/runnercontext"
+	"github.com/arkmq-org/markdown-runner/stage"
+	"github.com/arkmq-org/markdown-runner/view"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJUnitReport(t *testing.T) {
+	t.Run("record executed stages", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "test")
+		assert.NoError(t, err, "Failed to create temp dir")
+		defer os.RemoveAll(tmpDir)
+
+		cfg := &config.Config{MinutesToTimeout: 1}
+		ctx := &runnercontext.Context{
+			Cfg:   cfg,
+			RView: view.NewView("mock"),
+		}
+		stages := []*stage.Stage{
+			stage.NewStage(ctx, []*chunk.ExecutableChunk{
+				{Stage: "main", Label: "say hello", Content: []string{"echo hello"}, Source: chunk.Source{File: "test.md", Line: 3}, Context: ctx},
+				{Stage: "main", Id: "failing", Content: []string{"false"}, Context: ctx},
+				{Stage: "main", Content: []string{"echo skipped"}, Context: ctx},
+			}),
+			stage.NewStage(ctx, []*chunk.ExecutableChunk{
+				{Stage: "parallel", Content: []string{"echo one"}, IsParallel: true, Context: ctx},
+				{Stage: "parallel", Content: []string{"echo two"}, IsParallel: true, Context: ctx},
+			}),
+		}
+		var runErr error
+		for _, s := range stages {
+			if err := s.Execute(stages, make(map[string]string), runErr); err != nil {
+				runErr = err
+			}
+		}
+		assert.Error(t, runErr)
+
+		junitReport := NewJUnitReport()
+		junitReport.RecordFile("test.md", stages, 0, runErr)
+		junitReport.RecordFile("broken.md", nil, 0, errors.New("JSON unmarshal error"))
+		reportFile := path.Join(tmpDir, "report.xml")
+		err = junitReport.WriteFile(reportFile)
+		assert.NoError(t, err, "Failed to write the report")
+
+		content, err := os.ReadFile(reportFile)
+		assert.NoError(t, err, "Failed to read the report")
+		var suites JUnitTestSuites
+		err = xml.Unmarshal(content, &suites)
+		assert.NoError(t, err, "Expected a valid XML report")
+
+		assert.Equal(t, 6, suites.Tests)
+		assert.Equal(t, 1, suites.Failures)
+		assert.Equal(t, 1, suites.Errors)
+		assert.Equal(t, 3, suites.Skipped)
+		assert.Len(t, suites.Suites, 2)
+
+		suite := suites.Suites[0]
+		assert.Equal(t, "test.md", suite.Name)
+		assert.Len(t, suite.TestCases, 5)
+		assert.Equal(t, "say hello", suite.TestCases[0].Name)
+		assert.Equal(t, "hello\n", suite.TestCases[0].SystemOut)
+		assert.Equal(t, "test.md", suite.TestCases[0].File)
+		assert.Equal(t, 3, suite.TestCases[0].Line)
+		assert.Nil(t, suite.TestCases[0].Failure)
+		assert.Equal(t, "main/failing", suite.TestCases[1].Name)
+		assert.NotNil(t, suite.TestCases[1].Failure)
+		assert.Contains(t, suite.TestCases[1].Failure.Message, "exit code 1")
+		assert.Equal(t, "main/2", suite.TestCases[2].Name)
+		assert.NotNil(t, suite.TestCases[2].Skipped)
+		assert.Equal(t, "parallel/0", suite.TestCases[3].Name)
+		assert.NotNil(t, suite.TestCases[3].Skipped)
+
+		broken := suites.Suites[1]
+		assert.Equal(t, 1, broken.Errors)
+		assert.Len(t, broken.TestCases, 1)
+		assert.Equal(t, "JSON unmarshal error", broken.TestCases[0].Error.Message)
+	})
+	t.Run("record parallel stages", func(t *testing.T) {
+		cfg := &config.Config{MinutesToTimeout: 1}
+		ctx := &runnercontext.Context{
+			Cfg:   cfg,
+			RView: view.NewView("mock"),
+		}
+		stages := []*stage.Stage{
+			stage.NewStage(ctx, []*chunk.ExecutableChunk{
+				{Stage: "parallel", Content: []string{"echo one"}, IsParallel: true, Context: ctx},
+				{Stage: "parallel", Content: []string{"echo two"}, IsParallel: true, Context: ctx},
+			}),
+		}
+		err := stages[0].Execute(stages, make(map[string]string), nil)
+		assert.NoError(t, err)
+
+		junitReport := NewJUnitReport()
+		junitReport.RecordFile("parallel.md", stages, 0, nil)
+		suite := junitReport.suites[0]
+		assert.Equal(t, 2, suite.Tests)
+		assert.Equal(t, 0, suite.Failures)
+		assert.Equal(t, 0, suite.Skipped)
+		assert.Equal(t, "two\n", suite.TestCases[1].SystemOut)
+	})
+	t.Run("record writer chunks", func(t *testing.T) {
+		cfg := &config.Config{MinutesToTimeout: 1}
+		ctx := &runnercontext.Context{
+			Cfg:   cfg,
+			RView: view.NewView("mock"),
+		}
+		rootDir := t.TempDir()
+		stages := []*stage.Stage{
+			stage.NewStage(ctx, []*chunk.ExecutableChunk{
+				{Stage: "write", Runtime: "writer", Destination: "written.txt", RootDir: rootDir, Content: []string{"hello"}, Context: ctx},
+				{Stage: "write", Runtime: "writer", Destination: "missing/written.txt", RootDir: rootDir, Content: []string{"hello"}, Context: ctx},
+			}),
+		}
+		err := stages[0].Execute(stages, make(map[string]string), nil)
+		assert.Error(t, err)
+
+		junitReport := NewJUnitReport()
+		junitReport.RecordFile("writer.md", stages, 0, err)
+		suite := junitReport.suites[0]
+		assert.Nil(t, suite.TestCases[0].Failure)
+		assert.NotNil(t, suite.TestCases[1].Failure)
+		assert.Equal(t, "Write", suite.TestCases[1].Failure.Type)
+		assert.Contains(t, suite.TestCases[1].Failure.Message, "missing/written.txt")
+	})
+	t.Run("record retried chunks", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "test")
+		assert.NoError(t, err, "Failed to create temp dir")
+		defer os.RemoveAll(tmpDir)
+
+		cfg := &config.Config{MinutesToTimeout: 1}
+		ctx := &runnercontext.Context{
+			Cfg:   cfg,
+			RView: view.NewView("mock"),
+		}
+		stages := []*stage.Stage{
+			stage.NewStage(ctx, []*chunk.ExecutableChunk{
+				// fails on the first attempt only
+				{Stage: "main", Label: "flaky", Runtime: "bash", RootDir: tmpDir, Retries: 2, Content: []string{"test -f marker || { touch marker; exit 1; }"}, Context: ctx},
+				{Stage: "main", Label: "broken", Retries: 1, Content: []string{"false"}, Context: ctx},
+			}),
+		}
+		err = stages[0].Execute(stages, make(map[string]string), nil)
+		assert.Error(t, err)
+
+		junitReport := NewJUnitReport()
+		junitReport.RecordFile("retries.md", stages, 0, err)
+		suite := junitReport.suites[0]
+		assert.Equal(t, 1, suite.Failures)
+
+		flaky := suite.TestCases[0]
+		assert.Nil(t, flaky.Failure)
+		assert.Len(t, flaky.FlakyFailures, 1)
+		assert.Empty(t, flaky.RerunFailures)
+
+		broken := suite.TestCases[1]
+		assert.NotNil(t, broken.Failure)
+		assert.Empty(t, broken.FlakyFailures)
+		assert.Len(t, broken.RerunFailures, 1)
+		assert.Contains(t, broken.RerunFailures[0].Message, "exit code 1")
+	})
+	t.Run("record interrupted chunks", func(t *testing.T) {
+		interrupt, cancel := context.WithCancel(context.Background())
+		ctx := &runnercontext.Context{
+			Cfg:       &config.Config{MinutesToTimeout: 1},
+			RView:     view.NewView("mock"),
+			Interrupt: interrupt,
+		}
+		stages := []*stage.Stage{
+			stage.NewStage(ctx, []*chunk.ExecutableChunk{
+				{Stage: "main", Content: []string{"sleep 30"}, Context: ctx},
+			}),
+		}
+		time.AfterFunc(100*time.Millisecond, cancel)
+		err := stages[0].Execute(stages, make(map[string]string), nil)
+		assert.ErrorIs(t, err, runnercontext.ErrInterrupted)
+
+		junitReport := NewJUnitReport()
+		junitReport.RecordFile("test.md", stages, 0, err)
+		suite := junitReport.suites[0]
+		assert.Equal(t, 1, suite.Errors)
+		assert.Len(t, suite.TestCases, 1, "Expected the interruption to be attributed to the chunk")
+		assert.Equal(t, "Interrupted", suite.TestCases[0].Error.Type)
+	})
+	t.Run("write error", func(t *testing.T) {
+		err := NewJUnitReport().WriteFile("/invalid/dir/report.xml")
+		assert.Error(t, err, "Expected an error when writing to an invalid directory")
+	})
+}
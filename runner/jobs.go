@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/arkmq-org/markdown-runner/config"
+	"github.com/arkmq-org/markdown-runner/parser"
+	"github.com/arkmq-org/markdown-runner/runnercontext"
+)
+
+// RunMDFiles executes the markdown files one after the other, or up to
+// cfg.Jobs of them concurrently. Concurrent files get their own copy of the
+// configuration, and the ones whose front matter sets exclusive are executed
+// alone. No more file is started once one fails or ctx is done, see RunMD.
+//
+// It returns the errors of the files that failed.
+func RunMDFiles(ctx context.Context, cfg *config.Config, files []string, recorders ...Recorder) error {
+	if cfg.Jobs <= 1 {
+		for _, file := range files {
+			if ctx.Err() != nil {
+				return runnercontext.ErrInterrupted
+			}
+			err := RunMD(ctx, cfg, file, recorders...)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var errs []error
+	fail := func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		errs = append(errs, err)
+	}
+	hasFailed := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(errs) > 0
+	}
+	// every running file holds a slot until it's done
+	slots := make(chan struct{}, cfg.Jobs)
+	for _, file := range files {
+		if hasFailed() {
+			break
+		}
+		if ctx.Err() != nil {
+			fail(runnercontext.ErrInterrupted)
+			break
+		}
+		frontMatter, err := parser.ReadFrontMatter(file)
+		if err != nil {
+			fail(err)
+			break
+		}
+		if frontMatter.Exclusive {
+			// wait for the running files to be done before starting it
+			wg.Wait()
+			if hasFailed() {
+				break
+			}
+			if err := RunMD(ctx, cfg.ForFile(), file, recorders...); err != nil {
+				fail(err)
+			}
+			continue
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := RunMD(ctx, cfg.ForFile(), file, recorders...); err != nil {
+				fail(err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
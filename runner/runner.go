@@ -4,13 +4,20 @@
 package runner
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"os/exec"
 	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/arkmq-org/markdown-runner/chunk"
+	"github.com/arkmq-org/markdown-runner/condition"
 	"github.com/arkmq-org/markdown-runner/config"
 	"github.com/arkmq-org/markdown-runner/parser"
 	"github.com/arkmq-org/markdown-runner/runnercontext"
@@ -18,37 +25,123 @@ import (
 	"github.com/arkmq-org/markdown-runner/view"
 )
 
+// Recorder gets notified of the outcome of every markdown file once it has been
+// executed. It's used to build reports spanning multiple files.
+type Recorder interface {
+	// RecordFile receives the executed stages of the file, nil if the file
+	// could not be parsed, along with the time it took and its error if any.
+	RecordFile(file string, stages []*stage.Stage, duration time.Duration, err error)
+}
+
 // RunMD orchestrates the entire execution process for a single markdown file.
 // It parses the file to get the stages, then iterates through them, executing
 // the chunks in order. It handles parallel execution, dependencies, and teardown
 // stages. If the configuration is set, it will also update the markdown file
 // with the output of the executed chunks.
 //
+// ctx interrupts the execution once done: the running commands are cancelled,
+// the teardown stages executed, and the file fails with
+// runnercontext.ErrInterrupted.
 // file is the name of the markdown file to execute.
+// recorders are notified with the outcome of the file once it's done.
 // It returns an error if any chunk fails and is not part of a teardown stage.
-func RunMD(cfg *config.Config, file string) error {
+func RunMD(ctx context.Context, cfg *config.Config, file string, recorders ...Recorder) error {
 	var tmpDirs map[string]string = make(map[string]string)
 	var terminatingError error
+	var stages []*stage.Stage
+	startTime := time.Now()
+	defer func() {
+		for _, recorder := range recorders {
+			recorder.RecordFile(file, stages, time.Since(startTime), terminatingError)
+		}
+	}()
 	markdownDir := path.Dir(file)
 	fileName := path.Base(file)
-	ui := view.NewView(cfg.View)
-	ctx := &runnercontext.Context{
-		Cfg:   cfg,
-		RView: ui,
+	var ui view.RunnerView
+	switch {
+	case cfg.RunnerView != nil:
+		ui = cfg.RunnerView
+	case cfg.Jobs > 1:
+		ui = view.NewGroupedView(cfg.View)
+	default:
+		ui = view.NewView(cfg.View)
 	}
+	runCtx := runnercontext.NewContext(cfg, ui)
+	runCtx.Interrupt = ctx
 
 	ui.StartFile(file)
 
-	stages, err := parser.ExtractStages(ctx, fileName, markdownDir)
-	if err != nil {
-		ui.EndFile(file, err)
-		return err
+	document, terminatingError := parser.ParseDocument(runCtx, fileName, markdownDir)
+	if terminatingError != nil {
+		ui.EndFile(file, terminatingError)
+		return terminatingError
 	}
+	stages = document.Stages
 	if len(stages) == 0 {
+		ui.EndFile(file, nil)
 		return nil
 	}
+	frontMatter := document.FrontMatter
+	if frontMatter.Description != "" {
+		ui.Info(frontMatter.Description)
+	}
+	if frontMatter.Skip {
+		for _, currentStage := range stages {
+			for _, currentChunk := range currentStage.Chunks {
+				currentChunk.IsSkipped = true
+				currentChunk.SkipReason = "the file is skipped by its front matter"
+			}
+		}
+		ui.Info("Skipping the file as its front matter asks to")
+		ui.EndFile(file, nil)
+		return nil
+	}
+	if terminatingError = checkTools(frontMatter.Tools); terminatingError != nil {
+		ui.EndFile(file, terminatingError)
+		return terminatingError
+	}
+	for _, name := range slices.Sorted(maps.Keys(frontMatter.Env)) {
+		runCtx.Setenv(name, frontMatter.Env[name])
+	}
+	if frontMatter.Timeout > 0 {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		var cancel context.CancelFunc
+		runCtx.Interrupt, cancel = context.WithTimeout(ctx, frontMatter.Timeout)
+		defer cancel()
+	}
+	if cfg.Tags != "" || cfg.SkipTags != "" {
+		terminatingError = selectChunks(cfg, ui, stages)
+		if terminatingError != nil {
+			ui.EndFile(file, terminatingError)
+			return terminatingError
+		}
+	}
+
+	// the file resumes from the chunk it failed at in the previous execution
+	resume := cfg.ResumePoints[file]
+	if resume != nil && !slices.ContainsFunc(stages, func(s *stage.Stage) bool { return s.Name == resume.Stage }) {
+		ui.Warning(fmt.Sprintf("The stage %s to resume from no longer exists, executing the whole file", resume.Stage))
+		resume = nil
+	}
+	if resume != nil {
+		runCtx.Restore(resume)
+		ui.Info(fmt.Sprintf("Resuming from the chunk %d of the stage %s", resume.Chunk, resume.Stage))
+	}
 
 	for _, currentStage := range stages {
+		if resume != nil {
+			if currentStage.Name != resume.Stage {
+				// the restored environment is kept for the file to be resumed again
+				currentStage.Env = slices.Clone(runCtx.Env)
+				currentStage.ShellDefinitions = runCtx.ShellDefinitions
+				continue
+			}
+			currentStage.ResumeFrom = resume.Chunk
+			resume = nil
+		}
+
 		if cfg.StartFromStage != "" {
 			// Check if this is the right file (if file-specific start-from is requested)
 			var shouldStart bool
@@ -96,10 +189,12 @@ func RunMD(cfg *config.Config, file string) error {
 			if shouldDebug && currentStage.Name == cfg.DebugFromStage {
 				if cfg.DebugFromChunk != "" {
 					// Specific chunk requested - validate it exists
-					_, err := findChunkByIdOrIndex(currentStage, cfg.DebugFromChunk)
-					if err != nil {
-						ui.EndFile(file, err)
-						return err
+					_, terminatingError = findChunkByIdOrIndex(currentStage, cfg.DebugFromChunk)
+					if terminatingError != nil {
+						stage.StopBackgroundChunks(stages)
+						runCtx.CloseShells()
+						ui.EndFile(file, terminatingError)
+						return terminatingError
 					}
 					// Pass the chunk ID to the stage for targeted debugging
 					currentStage.DebugFromChunk = cfg.DebugFromChunk
@@ -121,13 +216,36 @@ func RunMD(cfg *config.Config, file string) error {
 		if err != nil {
 			terminatingError = err
 		}
+		currentStage.Env = slices.Clone(runCtx.Env)
+		currentStage.ShellDefinitions = runCtx.ShellDefinitions
+
+	}
+
+	// background chunks run until the file is done, they have to be stopped for their output to be complete
+	err := stage.StopBackgroundChunks(stages)
+	if err != nil && terminatingError == nil {
+		terminatingError = err
+	}
+	runCtx.CloseShells()
 
+	// the next files start from the variables exported by this one only when asked to
+	if cfg.ShareEnv {
+		cfg.Env = runCtx.Env
+	}
+
+	// the file timed out rather than being interrupted
+	if runCtx.Interrupt != ctx && errors.Is(runCtx.Interrupt.Err(), context.DeadlineExceeded) && terminatingError != nil {
+		terminatingError = fmt.Errorf("the file timed out after %s: %v", frontMatter.Timeout, terminatingError)
+	}
+
+	if cfg.Check && terminatingError == nil {
+		terminatingError = checkChunksOutput(stages)
 	}
 
 	if cfg.UpdateFile && terminatingError == nil {
-		terminatingError = parser.UpdateChunkOutput(fileName, markdownDir, stages)
+		terminatingError = document.UpdateChunkOutput(cfg.UpdateHidden)
 		if terminatingError == nil {
-			os.Rename(path.Join(markdownDir, fileName+".out"), path.Join(markdownDir, fileName))
+			os.Rename(document.File+".out", document.File)
 		}
 	}
 
@@ -139,6 +257,61 @@ func RunMD(cfg *config.Config, file string) error {
 	return terminatingError
 }
 
+// checkTools returns an error naming the tools that can't be found.
+func checkTools(tools []string) error {
+	var missing []string
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			missing = append(missing, tool)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the file requires tools that can't be found: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkChunksOutput compares the output of every executed chunk with the
+// output documented in the markdown file. It returns all the differences found
+// joined in a single error.
+func checkChunksOutput(stages []*stage.Stage) error {
+	var errs []error
+	for _, currentStage := range stages {
+		for _, chunk := range currentStage.Chunks {
+			if err := chunk.CheckOutput(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// selectChunks excludes the chunks of the stages that aren't selected by the
+// --tags and --skip-tags expressions, and reports how many remain.
+func selectChunks(cfg *config.Config, ui view.RunnerView, stages []*stage.Stage) error {
+	var tags, skipTags *condition.Expression
+	var err error
+	if cfg.Tags != "" {
+		tags, err = condition.ParseTags(cfg.Tags)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.SkipTags != "" {
+		skipTags, err = condition.ParseTags(cfg.SkipTags)
+		if err != nil {
+			return err
+		}
+	}
+	total := 0
+	for _, currentStage := range stages {
+		total += len(currentStage.Chunks)
+	}
+	selected := stage.SelectChunks(stages, tags, skipTags)
+	ui.Info(fmt.Sprintf("%d out of %d chunks selected by the tags", selected, total))
+	return nil
+}
+
 // findChunkByIdOrIndex finds a chunk in a stage by either its ID or by index (0-based)
 func findChunkByIdOrIndex(stage *stage.Stage, identifier string) (*chunk.ExecutableChunk, error) {
 	// Try to parse as integer index first
@@ -1,11 +1,16 @@
 package runner
 
 import (
+	"context"
 	"os"
 	"path"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/arkmq-org/markdown-runner/config"
+	"github.com/arkmq-org/markdown-runner/runnercontext"
+	"github.com/arkmq-org/markdown-runner/view"
 	"github.com/pterm/pterm"
 	"github.com/stretchr/testify/assert"
 )
@@ -33,7 +38,7 @@ echo "hello"
 		err = os.WriteFile(mdFile, []byte(mdContent), 0o644)
 		assert.NoError(t, err, "Failed to write to temp file")
 
-		err = RunMD(cfg, mdFile)
+		err = RunMD(context.Background(), cfg, mdFile)
 		assert.NoError(t, err, "Unexpected error")
 	})
 
@@ -56,7 +61,7 @@ echo "teardown"
 		err = os.WriteFile(mdFile, []byte(mdContent), 0o644)
 		assert.NoError(t, err, "Failed to write to temp file")
 
-		err = RunMD(cfg, mdFile)
+		err = RunMD(context.Background(), cfg, mdFile)
 		assert.Error(t, err, "Expected an error, but got none")
 	})
 
@@ -75,7 +80,7 @@ echo "hello"
 		err = os.WriteFile(mdFile, []byte(mdContent), 0o644)
 		assert.NoError(t, err, "Failed to write to temp file")
 
-		err = RunMD(cfg, mdFile)
+		err = RunMD(context.Background(), cfg, mdFile)
 		assert.NoError(t, err, "Unexpected error")
 
 		updatedContent, err := os.ReadFile(mdFile)
@@ -91,6 +96,100 @@ hello
 		assert.Equal(t, expectedContent, string(updatedContent), "File content is not as expected")
 	})
 
+	t.Run("check", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "test")
+		assert.NoError(t, err, "Failed to create temp dir")
+		defer os.RemoveAll(tmpDir)
+
+		cfg := &config.Config{MarkdownDir: tmpDir, Check: true, MinutesToTimeout: 1}
+		mdContent := `
+` + "```" + `bash {"stage":"test"}
+echo "hello"
+` + "```" + `
+` + "```" + `shell markdown_runner
+hello
+` + "```" + `
+`
+		mdFile := path.Join(tmpDir, "test.md")
+		err = os.WriteFile(mdFile, []byte(mdContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		err = RunMD(context.Background(), cfg, mdFile)
+		assert.NoError(t, err, "Expected the documented output to match")
+
+		err = os.WriteFile(mdFile, []byte(strings.Replace(mdContent, "\nhello\n", "\ngoodbye\n", 1)), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		err = RunMD(context.Background(), cfg, mdFile)
+		assert.Error(t, err, "Expected an error when the documented output has drifted")
+	})
+
+	t.Run("background", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "test")
+		assert.NoError(t, err, "Failed to create temp dir")
+		defer os.RemoveAll(tmpDir)
+
+		cfg := &config.Config{MarkdownDir: tmpDir, UpdateFile: true, MinutesToTimeout: 1}
+		mdContent := `
+` + "```" + `bash {"stage":"setup", "runtime":"bash", "rootdir":"$tmpdir.shared", "background":true, "ready":{"file":"pid", "interval":"50ms"}}
+echo "server started"
+echo $$ > pid
+while true; do sleep 0.1; done
+` + "```" + `
+
+` + "```" + `bash {"stage":"test", "runtime":"bash", "rootdir":"$tmpdir.shared"}
+kill -0 $(cat pid)
+cp pid ` + path.Join(tmpDir, "pid") + `
+` + "```" + `
+`
+		mdFile := path.Join(tmpDir, "test.md")
+		err = os.WriteFile(mdFile, []byte(mdContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		err = RunMD(context.Background(), cfg, mdFile)
+		assert.NoError(t, err, "Expected the background process to be reachable from the next stages")
+
+		pid, err := os.ReadFile(path.Join(tmpDir, "pid"))
+		assert.NoError(t, err, "Failed to read the pid of the background process")
+		_, err = os.Stat(path.Join("/proc", strings.TrimSpace(string(pid))))
+		assert.True(t, os.IsNotExist(err), "Expected the background process to be stopped with the file")
+
+		updatedContent, err := os.ReadFile(mdFile)
+		assert.NoError(t, err, "Failed to read updated file")
+		assert.Contains(t, string(updatedContent), "```shell markdown_runner\nserver started\n```")
+	})
+
+	t.Run("tags", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "test")
+		assert.NoError(t, err, "Failed to create temp dir")
+		defer os.RemoveAll(tmpDir)
+
+		cfg := &config.Config{MarkdownDir: tmpDir, Tags: "smoke", SkipTags: "slow", MinutesToTimeout: 1}
+		mdContent := `
+` + "```" + `bash {"stage":"setup", "id":"prepare"}
+touch ` + path.Join(tmpDir, "prepared") + `
+` + "```" + `
+
+` + "```" + `bash {"stage":"test", "tags":["smoke"], "requires":"setup/prepare"}
+ls ` + path.Join(tmpDir, "prepared") + `
+` + "```" + `
+
+` + "```" + `bash {"stage":"test", "tags":["smoke", "slow"]}
+exit 1
+` + "```" + `
+
+` + "```" + `bash {"stage":"test"}
+exit 1
+` + "```" + `
+`
+		mdFile := path.Join(tmpDir, "test.md")
+		err = os.WriteFile(mdFile, []byte(mdContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		err = RunMD(context.Background(), cfg, mdFile)
+		assert.NoError(t, err, "Expected only the selected chunks and their dependencies to run")
+	})
+
 	t.Run("start from", func(t *testing.T) {
 		tmpDir, err := os.MkdirTemp("", "test")
 		assert.NoError(t, err, "Failed to create temp dir")
@@ -109,7 +208,7 @@ echo "should run"
 		err = os.WriteFile(mdFile, []byte(mdContent), 0o644)
 		assert.NoError(t, err, "Failed to write to temp file")
 
-		err = RunMD(cfg, mdFile)
+		err = RunMD(context.Background(), cfg, mdFile)
 		assert.NoError(t, err, "Unexpected error")
 	})
 
@@ -126,7 +225,7 @@ echo "should run"
 		err = os.WriteFile(mdFile, []byte(mdContent), 0o644)
 		assert.NoError(t, err, "Failed to write to temp file")
 
-		err = RunMD(cfg, mdFile)
+		err = RunMD(context.Background(), cfg, mdFile)
 		assert.NoError(t, err, "Unexpected error")
 	})
 
@@ -145,7 +244,7 @@ echo "bad json"
 		err = os.WriteFile(mdFile, []byte(mdContent), 0o644)
 		assert.NoError(t, err, "Failed to write to temp file")
 
-		err = RunMD(cfg, mdFile)
+		err = RunMD(context.Background(), cfg, mdFile)
 		assert.Error(t, err, "Expected a parser error")
 	})
 
@@ -169,10 +268,215 @@ echo "teardown executed" > ` + outputFile + `
 		err = os.WriteFile(mdFile, []byte(mdContent), 0o644)
 		assert.NoError(t, err, "Failed to write to temp file")
 
-		err = RunMD(cfg, mdFile)
+		err = RunMD(context.Background(), cfg, mdFile)
 		assert.NoError(t, err, "Expected no error")
 
 		_, err = os.Stat(outputFile)
 		assert.NoError(t, err, "Expected teardown chunk to be executed")
 	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "test")
+		assert.NoError(t, err, "Failed to create temp dir")
+		defer os.RemoveAll(tmpDir)
+
+		cfg := &config.Config{MarkdownDir: tmpDir, MinutesToTimeout: 1}
+		skippedFile := path.Join(tmpDir, "skipped.txt")
+		teardownFile := path.Join(tmpDir, "teardown.txt")
+		mdContent := `
+` + "```" + `bash {"stage":"main"}
+sleep 30
+` + "```" + `
+
+` + "```" + `bash {"stage":"next"}
+touch ` + skippedFile + `
+` + "```" + `
+
+` + "```" + `bash {"stage":"teardown"}
+touch ` + teardownFile + `
+` + "```" + `
+`
+		mdFile := path.Join(tmpDir, "test.md")
+		err = os.WriteFile(mdFile, []byte(mdContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(200*time.Millisecond, cancel)
+		start := time.Now()
+		err = RunMD(ctx, cfg, mdFile)
+		assert.ErrorIs(t, err, runnercontext.ErrInterrupted)
+		assert.Less(t, time.Since(start), 10*time.Second, "Expected the running command to be cancelled")
+
+		_, err = os.Stat(skippedFile)
+		assert.True(t, os.IsNotExist(err), "Expected the next stages to be skipped")
+		_, err = os.Stat(teardownFile)
+		assert.NoError(t, err, "Expected teardown chunk to be executed")
+	})
+	t.Run("front matter", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		cfg := &config.Config{MarkdownDir: tmpDir, MinutesToTimeout: 1}
+		outputFile := path.Join(tmpDir, "output.txt")
+		mdContent := `---
+markdown-runner:
+  description: Greets from the front matter
+  tools: [sh]
+  env:
+    GREETING: hello
+  defaults:
+    runtime: bash
+---
+` + "```" + `bash {"stage":"test"}
+echo "$GREETING" > ` + outputFile + `
+` + "```" + `
+`
+		mdFile := path.Join(tmpDir, "test.md")
+		err := os.WriteFile(mdFile, []byte(mdContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		err = RunMD(context.Background(), cfg, mdFile)
+		assert.NoError(t, err, "Unexpected error")
+		output, err := os.ReadFile(outputFile)
+		assert.NoError(t, err, "Expected the chunk to be executed as a bash script")
+		assert.Equal(t, "hello\n", string(output))
+
+		err = os.WriteFile(mdFile, []byte(strings.Replace(mdContent, "[sh]", "[sh, missing-tool]", 1)), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+		err = RunMD(context.Background(), cfg, mdFile)
+		assert.ErrorContains(t, err, "missing-tool")
+
+		assert.NoError(t, os.Remove(outputFile))
+		err = os.WriteFile(mdFile, []byte(strings.Replace(mdContent, "  tools: [sh]", "  skip: true", 1)), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+		err = RunMD(context.Background(), cfg, mdFile)
+		assert.NoError(t, err, "Unexpected error")
+		_, err = os.Stat(outputFile)
+		assert.True(t, os.IsNotExist(err), "Expected the chunks of a skipped file not to be executed")
+	})
+
+	t.Run("front matter timeout", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		cfg := &config.Config{MarkdownDir: tmpDir, MinutesToTimeout: 1}
+		teardownFile := path.Join(tmpDir, "teardown.txt")
+		mdContent := `---
+markdown-runner:
+  timeout: 200ms
+---
+` + "```" + `bash {"stage":"main"}
+sleep 30
+` + "```" + `
+
+` + "```" + `bash {"stage":"teardown"}
+touch ` + teardownFile + `
+` + "```" + `
+`
+		mdFile := path.Join(tmpDir, "test.md")
+		err := os.WriteFile(mdFile, []byte(mdContent), 0o644)
+		assert.NoError(t, err, "Failed to write to temp file")
+
+		start := time.Now()
+		err = RunMD(context.Background(), cfg, mdFile)
+		assert.ErrorContains(t, err, "timed out after 200ms")
+		assert.NotErrorIs(t, err, runnercontext.ErrInterrupted, "Expected a timeout not to look like an interruption")
+		assert.Less(t, time.Since(start), 10*time.Second, "Expected the running command to be cancelled")
+		_, err = os.Stat(teardownFile)
+		assert.NoError(t, err, "Expected teardown chunk to be executed")
+	})
+}
+
+func TestRunMDFiles(t *testing.T) {
+	// waitFor writes the marker of its file, then waits for the marker of another file
+	waitFor := func(dir, name, other string) string {
+		return `
+` + "```" + `bash {"stage":"test", "runtime":"bash"}
+touch ` + path.Join(dir, name) + `
+for i in $(seq 20); do
+  [ -f ` + path.Join(dir, other) + ` ] && exit 0
+  sleep 0.1
+done
+exit 1
+` + "```" + `
+`
+	}
+
+	t.Run("executes the files concurrently", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "test")
+		assert.NoError(t, err, "Failed to create temp dir")
+		defer os.RemoveAll(tmpDir)
+
+		cfg := &config.Config{MarkdownDir: tmpDir, MinutesToTimeout: 1, Jobs: 2, View: "ci"}
+		fileA := path.Join(tmpDir, "a.md")
+		fileB := path.Join(tmpDir, "b.md")
+		assert.NoError(t, os.WriteFile(fileA, []byte(waitFor(tmpDir, "a", "b")), 0o644))
+		assert.NoError(t, os.WriteFile(fileB, []byte(waitFor(tmpDir, "b", "a")), 0o644))
+
+		err = RunMDFiles(context.Background(), cfg, []string{fileA, fileB})
+		assert.NoError(t, err, "Expected both files to be running at the same time")
+	})
+
+	t.Run("executes the exclusive files alone", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "test")
+		assert.NoError(t, err, "Failed to create temp dir")
+		defer os.RemoveAll(tmpDir)
+
+		cfg := &config.Config{MarkdownDir: tmpDir, MinutesToTimeout: 1, Jobs: 2, View: "ci"}
+		fileA := path.Join(tmpDir, "a.md")
+		fileB := path.Join(tmpDir, "b.md")
+		exclusive := "---\nmarkdown-runner:\n  exclusive: true\n---\n"
+		assert.NoError(t, os.WriteFile(fileA, []byte(exclusive+waitFor(tmpDir, "a", "b")), 0o644))
+		assert.NoError(t, os.WriteFile(fileB, []byte(waitFor(tmpDir, "b", "a")), 0o644))
+
+		err = RunMDFiles(context.Background(), cfg, []string{fileA, fileB})
+		assert.Error(t, err, "Expected the exclusive file to run without the other one")
+		_, err = os.Stat(path.Join(tmpDir, "b"))
+		assert.True(t, os.IsNotExist(err), "Expected no file to be started after a failure")
+	})
+
+	t.Run("isolates the configuration of the files", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "test")
+		assert.NoError(t, err, "Failed to create temp dir")
+		defer os.RemoveAll(tmpDir)
+
+		cfg := &config.Config{MarkdownDir: tmpDir, MinutesToTimeout: 1, Jobs: 2, View: "ci", Env: []string{"PATH=" + os.Getenv("PATH")}}
+		mdFile := path.Join(tmpDir, "test.md")
+		mdContent := `
+` + "```" + `bash {"stage":"test", "runtime":"bash"}
+export LEAKED=true
+` + "```" + `
+`
+		assert.NoError(t, os.WriteFile(mdFile, []byte(mdContent), 0o644))
+
+		err = RunMDFiles(context.Background(), cfg, []string{mdFile})
+		assert.NoError(t, err, "Unexpected error")
+		assert.Equal(t, []string{"PATH=" + os.Getenv("PATH")}, cfg.Env)
+	})
+
+	t.Run("ends the files without chunks", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "test")
+		assert.NoError(t, err, "Failed to create temp dir")
+		defer os.RemoveAll(tmpDir)
+
+		ui := view.NewView("mock")
+		cfg := &config.Config{MarkdownDir: tmpDir, MinutesToTimeout: 1, Jobs: 2, RunnerView: ui}
+		emptyFile := path.Join(tmpDir, "empty.md")
+		mdFile := path.Join(tmpDir, "test.md")
+		assert.NoError(t, os.WriteFile(emptyFile, []byte("# Nothing to execute\n"), 0o644))
+		assert.NoError(t, os.WriteFile(mdFile, []byte("```bash {\"stage\":\"test\"}\ntrue\n```\n"), 0o644))
+
+		err = RunMDFiles(context.Background(), cfg, []string{emptyFile, mdFile})
+		assert.NoError(t, err, "Unexpected error")
+		assert.Len(t, ui.(*view.MockRunnerView).Calls["EndFile"], 2, "Expected every started file to be ended")
+	})
+
+	t.Run("returns the errors of the files", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "test")
+		assert.NoError(t, err, "Failed to create temp dir")
+		defer os.RemoveAll(tmpDir)
+
+		cfg := &config.Config{MarkdownDir: tmpDir, MinutesToTimeout: 1, Jobs: 2, View: "ci"}
+		mdFile := path.Join(tmpDir, "test.md")
+		assert.NoError(t, os.WriteFile(mdFile, []byte("---\nmarkdown-runner: [invalid\n---\n"), 0o644))
+
+		err = RunMDFiles(context.Background(), cfg, []string{mdFile})
+		assert.ErrorContains(t, err, "invalid front matter")
+	})
 }
@@ -1,13 +1,80 @@
 package runnercontext
 
 import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+
 	"github.com/arkmq-org/markdown-runner/config"
 	"github.com/arkmq-org/markdown-runner/view"
 )
 
+// ErrInterrupted is the error of the files whose execution got interrupted,
+// such as by Ctrl-C.
+var ErrInterrupted = errors.New("interrupted")
+
 // Context provides a shared context for execution, containing
 // the configuration and UI view.
 type Context struct {
 	Cfg   *config.Config
 	RView view.RunnerView
+	// Env is the environment the chunks of the file are executed with. It
+	// starts as a copy of Cfg.Env and gets the variables exported by the bash
+	// chunks, without affecting the other files.
+	Env []string
+	// ShellDefinitions holds the functions and aliases defined by the bash
+	// chunks of the file, restored at the beginning of the next ones.
+	ShellDefinitions string
+	// Shells holds the long-lived shells the bash chunks of the file are fed
+	// to when they ask for a persistent shell, by scope.
+	Shells map[string]io.Closer
+	// Interrupt is done once the execution gets interrupted: the running
+	// commands are cancelled and only the teardown chunks are executed from
+	// then on. Nil when the execution can't be interrupted.
+	Interrupt context.Context
+}
+
+// NewContext returns the context for executing a file, starting from its own
+// copy of the environment of the configuration.
+func NewContext(cfg *config.Config, rview view.RunnerView) *Context {
+	return &Context{
+		Cfg:   cfg,
+		RView: rview,
+		Env:   slices.Clone(cfg.Env),
+	}
+}
+
+// Interrupted returns whether the execution got interrupted.
+func (ctx *Context) Interrupted() bool {
+	return ctx.Interrupt != nil && ctx.Interrupt.Err() != nil
+}
+
+// Restore puts the environment of the file back as it was at the resume point.
+func (ctx *Context) Restore(point *config.ResumePoint) {
+	ctx.Env = slices.DeleteFunc(ctx.Env, func(variable string) bool {
+		name, _, _ := strings.Cut(variable, "=")
+		return slices.Contains(point.Unset, name) || slices.ContainsFunc(point.Env, func(set string) bool {
+			return strings.HasPrefix(set, name+"=")
+		})
+	})
+	ctx.Env = append(ctx.Env, point.Env...)
+	ctx.ShellDefinitions = point.ShellDefinitions
+}
+
+// Setenv sets a variable of the environment of the file.
+func (ctx *Context) Setenv(name string, value string) {
+	ctx.Env = slices.DeleteFunc(ctx.Env, func(variable string) bool {
+		return strings.HasPrefix(variable, name+"=")
+	})
+	ctx.Env = append(ctx.Env, name+"="+value)
+}
+
+// CloseShells ends the persistent shells of the file, once it's done.
+func (ctx *Context) CloseShells() {
+	for key, shell := range ctx.Shells {
+		shell.Close()
+		delete(ctx.Shells, key)
+	}
 }
@@ -0,0 +1,73 @@
+package stage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/arkmq-org/markdown-runner/chunk"
+	"github.com/arkmq-org/markdown-runner/runnercontext"
+)
+
+// chunkResolver gives the conditions of the chunks access to the environment
+// and to the other chunks of the file.
+type chunkResolver struct {
+	stages []*Stage
+	ctx    *runnercontext.Context
+}
+
+// Getenv implements condition.Resolver, looking up the environment passed to
+// the chunks. Like for the commands, the environment of the runner is used when
+// it's empty.
+func (r *chunkResolver) Getenv(name string) string {
+	if len(r.ctx.Env) == 0 {
+		return os.Getenv(name)
+	}
+	value := ""
+	for _, variable := range r.ctx.Env {
+		if key, current, found := strings.Cut(variable, "="); found && key == name {
+			value = current
+		}
+	}
+	return value
+}
+
+// ChunkSucceeded implements condition.Resolver
+func (r *chunkResolver) ChunkSucceeded(ref string) (bool, error) {
+	referenced, err := r.find(ref)
+	if err != nil {
+		return false, err
+	}
+	return referenced.HasStarted && !referenced.IsSkipped && referenced.HasExecutedCorrectly(), nil
+}
+
+// ChunkFailed implements condition.Resolver
+func (r *chunkResolver) ChunkFailed(ref string) (bool, error) {
+	referenced, err := r.find(ref)
+	if err != nil {
+		return false, err
+	}
+	return referenced.HasStarted && !referenced.IsSkipped && !referenced.HasExecutedCorrectly(), nil
+}
+
+// ChunkOutput implements condition.Resolver
+func (r *chunkResolver) ChunkOutput(ref string) (string, error) {
+	referenced, err := r.find(ref)
+	if err != nil {
+		return "", err
+	}
+	return referenced.Output(), nil
+}
+
+// find returns the chunk referenced as "stageName/chunkId".
+func (r *chunkResolver) find(ref string) (*chunk.ExecutableChunk, error) {
+	stageName, chunkId, found := strings.Cut(ref, "/")
+	if !found {
+		return nil, fmt.Errorf("invalid chunk reference '%s', expected 'stageName/chunkId'", ref)
+	}
+	referenced := FindChunkById(r.stages, stageName, chunkId)
+	if referenced == nil {
+		return nil, fmt.Errorf("no chunk with the id '%s' in the stage '%s'", chunkId, stageName)
+	}
+	return referenced, nil
+}
@@ -0,0 +1,56 @@
+package stage
+
+import (
+	"strings"
+
+	"github.com/arkmq-org/markdown-runner/chunk"
+	"github.com/arkmq-org/markdown-runner/condition"
+)
+
+// SelectChunks marks the chunks that aren't selected by the tag expressions as
+// excluded. A chunk is selected when its tags match the tags expression, or when
+// there's none, and don't match the skipTags one, when there's one. The chunks
+// a selected chunk requires are selected as well, whatever their tags, and so
+// are the teardown chunks, for what the selected chunks started to be cleaned
+// up.
+//
+// It returns the number of selected chunks.
+func SelectChunks(stages []*Stage, tags, skipTags *condition.Expression) int {
+	selected := make(map[*chunk.ExecutableChunk]bool)
+	var pending []*chunk.ExecutableChunk
+	for _, stage := range stages {
+		for _, chunk := range stage.Chunks {
+			if chunk.Stage != "teardown" {
+				if tags != nil && !tags.MatchTags(chunk.Tags) {
+					continue
+				}
+				if skipTags != nil && skipTags.MatchTags(chunk.Tags) {
+					continue
+				}
+			}
+			selected[chunk] = true
+			pending = append(pending, chunk)
+		}
+	}
+	// pull in the dependencies of the selected chunks, and theirs in turn
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		stageName, chunkId, found := strings.Cut(current.Requires, "/")
+		if !found {
+			continue
+		}
+		required := FindChunkById(stages, stageName, chunkId)
+		if required == nil || selected[required] {
+			continue
+		}
+		selected[required] = true
+		pending = append(pending, required)
+	}
+	for _, stage := range stages {
+		for _, chunk := range stage.Chunks {
+			chunk.IsExcluded = !selected[chunk]
+		}
+	}
+	return len(selected)
+}
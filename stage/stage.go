@@ -2,7 +2,9 @@
 package stage
 
 import (
+	"errors"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -18,6 +20,11 @@ type Stage struct {
 	Chunks         []*chunk.ExecutableChunk
 	Ctx            *runnercontext.Context
 	DebugFromChunk string // ID or index of chunk to start debugging from
+	ResumeFrom     int    // Index of the chunk the stage resumes from, the previous ones being skipped
+	// Env and ShellDefinitions are the environment of the file once the stage
+	// was executed, see runnercontext.Context
+	Env              []string
+	ShellDefinitions string
 }
 
 // NewStage creates a new stage from a list of chunks. It assumes all chunks
@@ -27,7 +34,8 @@ func NewStage(ctx *runnercontext.Context, chunks []*chunk.ExecutableChunk) *Stag
 		return nil
 	}
 	isParallel := false
-	for _, chunk := range chunks {
+	for index, chunk := range chunks {
+		chunk.Index = index
 		if chunk.IsParallel {
 			isParallel = true
 		}
@@ -76,11 +84,25 @@ func (s *Stage) Execute(stages []*Stage, tmpDirs map[string]string, terminatingE
 
 	for _, chunk := range s.Chunks {
 		chunk.Context = s.Ctx
+		// Once interrupted, only the teardown chunks are executed
+		if terminatingError == nil && s.Ctx.Interrupted() {
+			terminatingError = runnercontext.ErrInterrupted
+		}
 		// Examine if the chunk can be executed based on previous errors
 		if terminatingError != nil && chunk.Stage != "teardown" {
 			chunk.Skip()
 			continue
 		}
+		// Examine if the chunk passed in the execution being resumed
+		if chunk.Index < s.ResumeFrom {
+			chunk.SkipBecause("it passed in the previous execution")
+			continue
+		}
+		// Examine if the chunk is part of the selection made with the tags
+		if chunk.IsExcluded {
+			chunk.SkipBecause("it isn't selected by the tags")
+			continue
+		}
 		// Examine if the tool must be run interactively from this chunk
 		if chunk.HasBreakpoint && !s.Ctx.Cfg.IgnoreBreakpoints {
 			s.Ctx.Cfg.Interactive = true
@@ -96,9 +118,21 @@ func (s *Stage) Execute(stages []*Stage, tmpDirs map[string]string, terminatingE
 			reqChunkId := strings.Split(chunk.Requires, "/")[1]
 			reqChunk := FindChunkById(stages, reqStageName, reqChunkId)
 			if reqChunk == nil || !reqChunk.HasExecutedCorrectly() {
+				chunk.SkipBecause(fmt.Sprintf("the required chunk %s did not execute correctly", chunk.Requires))
 				continue
 			}
 		}
+		// Examine the conditions of the chunk
+		skipReason, err := chunk.EvaluateConditions(&chunkResolver{stages: stages, ctx: s.Ctx})
+		if err != nil {
+			chunk.SkipBecause(fmt.Sprintf("its conditions could not be evaluated: %s", err))
+			terminatingError = err
+			continue
+		}
+		if skipReason != "" {
+			chunk.SkipBecause(skipReason)
+			continue
+		}
 		terminatingError = chunk.PrepareForExecution(tmpDirs)
 		if terminatingError != nil {
 			continue
@@ -116,8 +150,16 @@ func (s *Stage) Execute(stages []*Stage, tmpDirs map[string]string, terminatingE
 			if err != nil {
 				terminatingError = err
 			}
+		} else if chunk.IsBackground {
+			err := chunk.StartBackground()
+			if err != nil {
+				terminatingError = err
+			}
 		} else {
 			err := chunk.ExecuteSequential()
+			if err != nil {
+				err = chunk.Retry(err, tmpDirs)
+			}
 			if err != nil {
 				terminatingError = err
 			}
@@ -127,7 +169,8 @@ func (s *Stage) Execute(stages []*Stage, tmpDirs map[string]string, terminatingE
 	if s.IsParallel {
 		s.Ctx.RView.StartParallelMode()
 		for _, chunk := range s.Chunks {
-			if chunk.IsSkipped {
+			// a chunk that failed to be prepared has nothing to start
+			if chunk.IsSkipped || len(chunk.Commands) == 0 {
 				continue
 			}
 			// start the chunk
@@ -139,7 +182,11 @@ func (s *Stage) Execute(stages []*Stage, tmpDirs map[string]string, terminatingE
 		}
 		// wait or kill (in case an error occurred)
 		for _, chunk := range towait {
-			err := chunk.WaitParallel(terminatingError != nil)
+			shouldKill := terminatingError != nil
+			err := chunk.WaitParallel(shouldKill)
+			if err != nil && !shouldKill {
+				err = chunk.Retry(err, tmpDirs)
+			}
 			if err != nil {
 				terminatingError = err
 			}
@@ -148,6 +195,19 @@ func (s *Stage) Execute(stages []*Stage, tmpDirs map[string]string, terminatingE
 	return terminatingError
 }
 
+// StopBackgroundChunks terminates the background chunks of the stages that are
+// still running, the most recently started first. It returns the errors of the
+// ones that exited by themselves with a failure.
+func StopBackgroundChunks(stages []*Stage) error {
+	var errs []error
+	for _, stage := range slices.Backward(stages) {
+		for _, chunk := range slices.Backward(stage.Chunks) {
+			errs = append(errs, chunk.StopBackground())
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // FindChunkById searches through a list of stages to find a chunk with a
 // specific ID within a given stage.
 //
@@ -2,9 +2,11 @@ package stage
 
 import (
 	"os"
+	"runtime"
 	"testing"
 
 	"github.com/arkmq-org/markdown-runner/chunk"
+	"github.com/arkmq-org/markdown-runner/condition"
 	"github.com/arkmq-org/markdown-runner/config"
 	"github.com/arkmq-org/markdown-runner/runnercontext"
 	"github.com/arkmq-org/markdown-runner/view"
@@ -192,6 +194,24 @@ func TestStage(t *testing.T) {
 			assert.NoError(t, err)
 		})
 
+		t.Run("should consider an expected failure as a met dependency", func(t *testing.T) {
+			cfg := &config.Config{MinutesToTimeout: 1}
+			ctx := &runnercontext.Context{
+				Cfg:   cfg,
+				RView: view.NewView("mock"),
+			}
+			dependent := &chunk.ExecutableChunk{Stage: "main", Requires: "main/failing", Content: []string{"true"}, Context: ctx}
+			stages := []*Stage{
+				NewStage(ctx, []*chunk.ExecutableChunk{
+					{Id: "failing", Stage: "main", Content: []string{"false"}, ExpectExit: chunk.ExitExpectation{Codes: []int{1}}, Context: ctx},
+					dependent,
+				}),
+			}
+			err := stages[0].Execute(stages, make(map[string]string), nil)
+			assert.NoError(t, err)
+			assert.True(t, dependent.HasExecutedCorrectly(), "Expected the dependent chunk to run")
+		})
+
 		t.Run("should handle breakpoints", func(t *testing.T) {
 			cfg := &config.Config{MinutesToTimeout: 1, IgnoreBreakpoints: false}
 			ui := view.NewView("mock")
@@ -324,6 +344,157 @@ func TestStage(t *testing.T) {
 			testStage.Ctx = ctx
 			err = testStage.Execute(stages, make(map[string]string), nil)
 			assert.NoError(t, err)
+			assert.True(t, testStage.Chunks[0].IsSkipped)
+			assert.Contains(t, testStage.Chunks[0].SkipReason, "setup/chunk1")
+			assert.Len(t, ui.(*view.MockRunnerView).Calls["Skipped"], 1, "Expected the skipped chunk to be reported")
+		})
+	})
+	t.Run("execute with conditions", func(t *testing.T) {
+		testCases := []struct {
+			name        string
+			chunk       *chunk.ExecutableChunk
+			shouldRun   bool
+			expectError bool
+		}{
+			{name: "true if", chunk: &chunk.ExecutableChunk{If: "env('MDR_TEST') == 'on'"}, shouldRun: true},
+			{name: "false if", chunk: &chunk.ExecutableChunk{If: "env('MDR_UNSET')"}, shouldRun: false},
+			{name: "true skip_if", chunk: &chunk.ExecutableChunk{SkipIf: "command('true')"}, shouldRun: false},
+			{name: "false skip_if", chunk: &chunk.ExecutableChunk{SkipIf: "os != '" + runtime.GOOS + "'"}, shouldRun: true},
+			{name: "succeeded chunk", chunk: &chunk.ExecutableChunk{If: "succeeded('setup/ok') && !failed('setup/ok')"}, shouldRun: true},
+			{name: "failed chunk", chunk: &chunk.ExecutableChunk{If: "failed('setup/ko')"}, shouldRun: true},
+			{name: "skipped chunk", chunk: &chunk.ExecutableChunk{If: "succeeded('setup/skipped') || failed('setup/skipped')"}, shouldRun: false},
+			{name: "chunk output", chunk: &chunk.ExecutableChunk{If: "output('setup/ok') =~ 'v2\\.[0-9]'"}, shouldRun: true},
+			{name: "unknown chunk", chunk: &chunk.ExecutableChunk{If: "succeeded('setup/unknown')"}, expectError: true},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				cfg := &config.Config{MinutesToTimeout: 1, Env: []string{"MDR_TEST=off", "MDR_TEST=on"}}
+				ctx := runnercontext.NewContext(cfg, view.NewView("mock"))
+				setup := NewStage(ctx, []*chunk.ExecutableChunk{
+					{Id: "ok", Stage: "setup", Content: []string{"echo v2.1"}, Context: ctx},
+					{Id: "ko", Stage: "setup", Content: []string{"false"}, Context: ctx},
+					{Id: "skipped", Stage: "setup", Content: []string{"true"}, If: "false", Context: ctx},
+				})
+				tc.chunk.Stage = "main"
+				tc.chunk.Content = []string{"true"}
+				tc.chunk.Context = ctx
+				main := NewStage(ctx, []*chunk.ExecutableChunk{tc.chunk})
+				stages := []*Stage{setup, main}
+				for _, c := range append(setup.Chunks, main.Chunks...) {
+					assert.NoError(t, c.ParseExecutionPolicy())
+				}
+				// the failure of setup/ko is ignored to execute the main stage
+				setup.Execute(stages, make(map[string]string), nil)
+				err := main.Execute(stages, make(map[string]string), nil)
+				if tc.expectError {
+					assert.Error(t, err)
+					return
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, tc.shouldRun, tc.chunk.HasExecutedCorrectly())
+				assert.Equal(t, !tc.shouldRun, tc.chunk.IsSkipped)
+			})
+		}
+		t.Run("condition failing to evaluate in a parallel stage", func(t *testing.T) {
+			ctx := runnercontext.NewContext(&config.Config{MinutesToTimeout: 1}, view.NewView("mock"))
+			parallel := NewStage(ctx, []*chunk.ExecutableChunk{
+				{Stage: "parallel", Content: []string{"true"}, If: "succeeded('nope/x')", IsParallel: true, Context: ctx},
+				{Stage: "parallel", Content: []string{"true"}, IsParallel: true, Context: ctx},
+			})
+			for _, c := range parallel.Chunks {
+				assert.NoError(t, c.ParseExecutionPolicy())
+			}
+			err := parallel.Execute([]*Stage{parallel}, make(map[string]string), nil)
+			assert.ErrorContains(t, err, "nope")
+			assert.True(t, parallel.Chunks[0].IsSkipped)
+			assert.Contains(t, parallel.Chunks[0].SkipReason, "could not be evaluated")
+		})
+	})
+	t.Run("select chunks", func(t *testing.T) {
+		newStages := func() []*Stage {
+			return []*Stage{
+				{
+					Name: "setup",
+					Chunks: []*chunk.ExecutableChunk{
+						{Id: "cluster"},
+						{Id: "operator", Requires: "setup/cluster"},
+						{Id: "unrelated"},
+					},
+				},
+				{
+					Name: "main",
+					Chunks: []*chunk.ExecutableChunk{
+						{Id: "fast", Tags: []string{"smoke"}, Requires: "setup/operator"},
+						{Id: "slow", Tags: []string{"smoke", "slow"}},
+						{Id: "full", Tags: []string{"nightly"}},
+					},
+				},
+				{
+					Name: "teardown",
+					Chunks: []*chunk.ExecutableChunk{
+						{Id: "cleanup", Stage: "teardown", Tags: []string{"slow"}},
+					},
+				},
+			}
+		}
+		excluded := func(stages []*Stage) []string {
+			var ids []string
+			for _, stage := range stages {
+				for _, chunk := range stage.Chunks {
+					if chunk.IsExcluded {
+						ids = append(ids, chunk.Id)
+					}
+				}
+			}
+			return ids
+		}
+		testCases := []struct {
+			name     string
+			tags     string
+			skipTags string
+			selected int
+			excluded []string
+		}{
+			{name: "tags", tags: "smoke", selected: 5, excluded: []string{"unrelated", "full"}},
+			{name: "tags with negation", tags: "smoke && !slow", selected: 4, excluded: []string{"unrelated", "slow", "full"}},
+			{name: "skip tags only", skipTags: "slow || nightly", selected: 5, excluded: []string{"slow", "full"}},
+			{name: "tags and skip tags", tags: "smoke || nightly", skipTags: "slow", selected: 5, excluded: []string{"unrelated", "slow"}},
+			{name: "no match", tags: "unknown", selected: 1, excluded: []string{"cluster", "operator", "unrelated", "fast", "slow", "full"}},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				var tags, skipTags *condition.Expression
+				var err error
+				if tc.tags != "" {
+					tags, err = condition.ParseTags(tc.tags)
+					assert.NoError(t, err)
+				}
+				if tc.skipTags != "" {
+					skipTags, err = condition.ParseTags(tc.skipTags)
+					assert.NoError(t, err)
+				}
+				stages := newStages()
+				assert.Equal(t, tc.selected, SelectChunks(stages, tags, skipTags))
+				assert.Equal(t, tc.excluded, excluded(stages))
+			})
+		}
+
+		t.Run("skips the excluded chunks", func(t *testing.T) {
+			ctx := &runnercontext.Context{
+				Cfg:   &config.Config{MinutesToTimeout: 1},
+				RView: view.NewView("mock"),
+			}
+			selectedChunk := &chunk.ExecutableChunk{Stage: "main", Content: []string{"true"}, Tags: []string{"smoke"}, Context: ctx}
+			excludedChunk := &chunk.ExecutableChunk{Stage: "main", Content: []string{"true"}, Context: ctx}
+			stage := NewStage(ctx, []*chunk.ExecutableChunk{selectedChunk, excludedChunk})
+			tags, err := condition.ParseTags("smoke")
+			assert.NoError(t, err)
+			SelectChunks([]*Stage{stage}, tags, nil)
+			assert.NoError(t, stage.Execute([]*Stage{stage}, make(map[string]string), nil))
+			assert.True(t, selectedChunk.HasExecutedCorrectly())
+			assert.False(t, selectedChunk.IsSkipped)
+			assert.True(t, excludedChunk.IsSkipped)
+			assert.Equal(t, "it isn't selected by the tags", excludedChunk.SkipReason)
 		})
 	})
 }
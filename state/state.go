@@ -0,0 +1,243 @@
+// Package state persists the outcome of an execution to a file, for the next
+// execution to re-run the files that failed or to resume them from the chunk
+// they failed at.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arkmq-org/markdown-runner/chunk"
+	"github.com/arkmq-org/markdown-runner/config"
+	"github.com/arkmq-org/markdown-runner/stage"
+)
+
+// The statuses of a chunk
+const (
+	Passed  = "passed"
+	Failed  = "failed"
+	Skipped = "skipped"
+)
+
+// State is the outcome of the executed markdown files, written to its file
+// every time a file is done. It is safe to record files concurrently.
+type State struct {
+	mutex sync.Mutex
+	path  string
+	// env is the environment the files start from, the snapshots of the
+	// stages only hold what the chunks changed
+	env   []string
+	Files map[string]*FileState `json:"files"`
+}
+
+// FileState is the outcome of a markdown file.
+type FileState struct {
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+	// Time is when the file was done
+	Time   string       `json:"time"`
+	Stages []StageState `json:"stages,omitempty"`
+}
+
+// StageState is the outcome of a stage, along with the environment of the
+// file once it was executed.
+type StageState struct {
+	Name       string       `json:"name"`
+	IsParallel bool         `json:"parallel,omitempty"`
+	Chunks     []ChunkState `json:"chunks"`
+	// Executed is set when the environment below was captured
+	Executed bool `json:"executed"`
+	// Env holds the variables the chunks set, as KEY=VALUE
+	Env []string `json:"env,omitempty"`
+	// Unset holds the names of the variables the chunks unset
+	Unset            []string `json:"unset,omitempty"`
+	ShellDefinitions string   `json:"shell_definitions,omitempty"`
+}
+
+// ChunkState is the outcome of a chunk.
+type ChunkState struct {
+	Index  int    `json:"index"`
+	Id     string `json:"id,omitempty"`
+	Label  string `json:"label,omitempty"`
+	Status string `json:"status"`
+}
+
+// New returns an empty state written to the given path, for the files
+// starting from the given environment.
+func New(path string, env []string) *State {
+	return &State{path: path, env: env, Files: make(map[string]*FileState)}
+}
+
+// Load reads the state of the previous execution from the given path, for the
+// files starting from the given environment. The files recorded from then on
+// replace their previous outcome.
+func Load(path string, env []string) (*State, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no previous execution is recorded in %s: %w", path, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := New(path, env)
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]*FileState)
+	}
+	return state, nil
+}
+
+// Passed returns whether the file passed in the recorded execution.
+func (s *State) Passed(file string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fileState, ok := s.Files[file]
+	return ok && fileState.Passed
+}
+
+// ResumePoint returns where the file resumes from: its first failed chunk out
+// of the teardown stages, along with the environment the file had at that
+// point. A parallel stage resumes as a whole, from the environment the
+// previous stage left. It returns nil when the file has to be executed from
+// the beginning, such as when it passed or failed outside of its chunks.
+func (s *State) ResumePoint(file string) *config.ResumePoint {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fileState, ok := s.Files[file]
+	if !ok || fileState.Passed {
+		return nil
+	}
+	for index, stageState := range fileState.Stages {
+		if stageState.Name == "teardown" {
+			continue
+		}
+		failed := slices.IndexFunc(stageState.Chunks, func(chunk ChunkState) bool { return chunk.Status == Failed })
+		if failed < 0 {
+			continue
+		}
+		point := &config.ResumePoint{Stage: stageState.Name, Chunk: stageState.Chunks[failed].Index}
+		snapshot := stageState
+		if stageState.IsParallel {
+			point.Chunk = 0
+			if index == 0 {
+				return point
+			}
+			snapshot = fileState.Stages[index-1]
+		}
+		if !snapshot.Executed {
+			// the environment at that point is unknown
+			return nil
+		}
+		point.Env = snapshot.Env
+		point.Unset = snapshot.Unset
+		point.ShellDefinitions = snapshot.ShellDefinitions
+		return point
+	}
+	return nil
+}
+
+// RecordFile implements runner.Recorder, writing the state to its file.
+func (s *State) RecordFile(file string, stages []*stage.Stage, duration time.Duration, err error) {
+	fileState := &FileState{Passed: err == nil, Time: time.Now().Format(time.RFC3339)}
+	if err != nil {
+		fileState.Error = err.Error()
+	}
+	for _, currentStage := range stages {
+		stageState := StageState{Name: currentStage.Name, IsParallel: currentStage.IsParallel}
+		for index, currentChunk := range currentStage.Chunks {
+			chunkState := ChunkState{Index: index, Id: currentChunk.Id, Label: currentChunk.Label, Status: chunkStatus(currentChunk)}
+			stageState.Chunks = append(stageState.Chunks, chunkState)
+		}
+		if currentStage.Env != nil {
+			stageState.Executed = true
+			stageState.Env, stageState.Unset = diffEnv(s.env, currentStage.Env)
+			stageState.ShellDefinitions = currentStage.ShellDefinitions
+		}
+		fileState.Stages = append(fileState.Stages, stageState)
+	}
+	s.mutex.Lock()
+	s.Files[file] = fileState
+	s.mutex.Unlock()
+	if err := s.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot write the state file %s: %s\n", s.path, err)
+	}
+}
+
+// chunkStatus returns whether the chunk passed, failed or was skipped, as it's
+// reported in the JUnit report.
+func chunkStatus(currentChunk *chunk.ExecutableChunk) string {
+	switch {
+	case currentChunk.IsSkipped || !currentChunk.HasStarted:
+		return Skipped
+	case currentChunk.Runtime == "writer":
+		if currentChunk.WriteError != nil {
+			return Failed
+		}
+		return Passed
+	case currentChunk.Context != nil && currentChunk.Context.Cfg.DryRun:
+		return Skipped
+	case currentChunk.ReadyError != nil || len(currentChunk.Commands) == 0:
+		return Failed
+	}
+	for _, command := range currentChunk.Commands {
+		// the user chose not to execute the command in interactive mode
+		if command.Cmd == nil {
+			continue
+		}
+		if _, hasRun := command.ExitCode(); !hasRun || !command.HasSucceeded() {
+			return Failed
+		}
+	}
+	return Passed
+}
+
+// Save writes the state to its file, replacing it at once for an interrupted
+// execution not to leave it half written.
+func (s *State) Save() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(append(content, '\n'))
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), s.path)
+}
+
+// diffEnv returns the variables of env that aren't in base as is, and the
+// names of the variables of base that env doesn't have.
+func diffEnv(base []string, env []string) (set []string, unset []string) {
+	for _, variable := range env {
+		if !slices.Contains(base, variable) {
+			set = append(set, variable)
+		}
+	}
+	for _, variable := range base {
+		name, _, _ := strings.Cut(variable, "=")
+		if !slices.ContainsFunc(env, func(kept string) bool { return strings.HasPrefix(kept, name+"=") }) {
+			unset = append(unset, name)
+		}
+	}
+	return set, unset
+}
@@ -0,0 +1,98 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arkmq-org/markdown-runner/chunk"
+	"github.com/arkmq-org/markdown-runner/config"
+	"github.com/arkmq-org/markdown-runner/runnercontext"
+	"github.com/arkmq-org/markdown-runner/stage"
+	"github.com/arkmq-org/markdown-runner/view"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestState(t *testing.T) {
+	t.Run("it should find where a file resumes from", func(t *testing.T) {
+		state := New("", nil)
+		state.Files["sequential.md"] = &FileState{Stages: []StageState{
+			{Name: "init", Executed: true, Env: []string{"A=1"}, Chunks: []ChunkState{{Index: 0, Status: Passed}}},
+			{Name: "test", Executed: true, Env: []string{"A=1", "B=2"}, Unset: []string{"C"}, ShellDefinitions: "greet () {}", Chunks: []ChunkState{
+				{Index: 0, Status: Passed},
+				{Index: 1, Status: Failed},
+				{Index: 2, Status: Skipped},
+			}},
+		}}
+		assert.Equal(t, &config.ResumePoint{Stage: "test", Chunk: 1, Env: []string{"A=1", "B=2"}, Unset: []string{"C"}, ShellDefinitions: "greet () {}"},
+			state.ResumePoint("sequential.md"))
+
+		state.Files["parallel.md"] = &FileState{Stages: []StageState{
+			{Name: "init", Executed: true, Env: []string{"A=1"}, Chunks: []ChunkState{{Index: 0, Status: Passed}}},
+			{Name: "test", IsParallel: true, Executed: true, Env: []string{"A=2"}, Chunks: []ChunkState{
+				{Index: 0, Status: Passed},
+				{Index: 1, Status: Failed},
+			}},
+		}}
+		assert.Equal(t, &config.ResumePoint{Stage: "test", Chunk: 0, Env: []string{"A=1"}}, state.ResumePoint("parallel.md"),
+			"Expected a parallel stage to resume as a whole from the environment of the previous stage")
+	})
+	t.Run("it should execute the files from the beginning otherwise", func(t *testing.T) {
+		state := New("", nil)
+		state.Files["passed.md"] = &FileState{Passed: true}
+		state.Files["teardown.md"] = &FileState{Stages: []StageState{
+			{Name: "teardown", Executed: true, Chunks: []ChunkState{{Index: 0, Status: Failed}}},
+		}}
+		state.Files["unknown.md"] = &FileState{Stages: []StageState{
+			{Name: "test", Chunks: []ChunkState{{Index: 0, Status: Failed}}},
+		}}
+		for _, file := range []string{"passed.md", "teardown.md", "unknown.md", "missing.md"} {
+			assert.Nil(t, state.ResumePoint(file), file)
+		}
+		assert.True(t, state.Passed("passed.md"))
+		assert.False(t, state.Passed("missing.md"))
+	})
+	t.Run("it should only keep what the chunks changed in the environment", func(t *testing.T) {
+		set, unset := diffEnv([]string{"A=1", "B=2", "C=3"}, []string{"A=1", "B=4", "D=5"})
+		assert.Equal(t, []string{"B=4", "D=5"}, set)
+		assert.Equal(t, []string{"C"}, unset)
+	})
+	t.Run("it should record the status of the chunks", func(t *testing.T) {
+		ctx := runnercontext.NewContext(&config.Config{MinutesToTimeout: 1}, view.NewView("mock"))
+		rootDir := t.TempDir()
+		stages := []*stage.Stage{
+			stage.NewStage(ctx, []*chunk.ExecutableChunk{
+				{Stage: "main", Content: []string{"true"}, Context: ctx},
+				{Stage: "main", Runtime: "writer", Destination: "written.txt", RootDir: rootDir, Content: []string{"hello"}, Context: ctx},
+				{Stage: "main", Runtime: "writer", Destination: "missing/written.txt", RootDir: rootDir, Content: []string{"hello"}, Context: ctx},
+				{Stage: "main", Content: []string{"true"}, Context: ctx},
+			}),
+		}
+		err := stages[0].Execute(stages, make(map[string]string), nil)
+		assert.Error(t, err)
+
+		state := New(filepath.Join(t.TempDir(), "state.json"), nil)
+		state.RecordFile("writer.md", stages, 0, err)
+		var statuses []string
+		for _, chunkState := range state.Files["writer.md"].Stages[0].Chunks {
+			statuses = append(statuses, chunkState.Status)
+		}
+		assert.Equal(t, []string{Passed, Passed, Failed, Skipped}, statuses)
+	})
+	t.Run("it should save and load the state", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "state.json")
+		_, err := Load(path, nil)
+		assert.Error(t, err, "Expected a missing state file to be an error")
+
+		state := New(path, nil)
+		state.Files["a.md"] = &FileState{Passed: true}
+		assert.NoError(t, state.Save())
+		loaded, err := Load(path, nil)
+		assert.NoError(t, err)
+		assert.True(t, loaded.Passed("a.md"))
+
+		assert.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+		_, err = Load(path, nil)
+		assert.Error(t, err)
+	})
+}
@@ -91,6 +91,12 @@ for test_file in cases/*.md; do
             "./markdown-runner -d cases -f '.*'${test_file} 2>&1 | grep -E 'DRY-RUN.*|.*echo happy path'" || ((FAILED_TESTS++))
         run_test "Happy path test (${test_name}) should succeed" \
             "./markdown-runner cases -f '.*'${test_file}" || ((FAILED_TESTS++))
+    # The check test verifies that the documented output is compared
+    elif [ "${test_name}" == "check" ]; then
+        run_test "Check test (${test_name}) should match the documented output" \
+            "./markdown-runner --check cases -f '.*'${test_file}" || ((FAILED_TESTS++))
+        run_test "Check test (${test_name}) should fail on drifted output" \
+            "sed 's/^documented output$/drifted output/' ${test_file} > check_drift.md && ! ./markdown-runner --check check_drift.md; rm -f check_drift.md" || ((FAILED_TESTS++))
     # The verbose_set_x test verifies set -x functionality
     elif [ "${test_name}" == "verbose_set_x" ]; then
         run_test "Verbose set -x test (${test_name}) should show command tracing with -v" \
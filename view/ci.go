@@ -4,6 +4,7 @@ package view
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/pterm/pterm"
 )
@@ -11,12 +12,19 @@ import (
 // CiView is a view for CI environments, with no spinners and simplified outptut
 // it ignores the interactive mode completely and prints only the output of the crashing command (if the case may arise)
 type CiView struct {
+	// the output of the commands is printed from the goroutines copying it
+	mutex            sync.Mutex
 	hasPrintedResult bool
+	hasPrintedOutput bool
+	details          map[string]CommandDetails
+	// failures of commands are held until the end of the file, as a failing
+	// chunk might succeed once retried
+	failures []string
 }
 
 // newCiView returns a new CiView
 func newCiView() RunnerView {
-	return &CiView{}
+	return &CiView{details: make(map[string]CommandDetails)}
 }
 
 // StartFile implements RunnerView
@@ -25,6 +33,11 @@ func (v *CiView) StartFile(file string) {
 }
 
 func (v *CiView) EndFile(file string, err error) {
+	v.hasPrintedOutput = false
+	if err != nil {
+		v.printFailures()
+	}
+	v.failures = nil
 	if v.hasPrintedResult {
 		return
 	}
@@ -35,6 +48,18 @@ func (v *CiView) EndFile(file string, err error) {
 	}
 }
 
+// printFailures marks the file as failed and prints the failures held so far
+func (v *CiView) printFailures() {
+	if !v.hasPrintedResult {
+		fmt.Println("❌")
+		v.hasPrintedResult = true
+	}
+	for _, failure := range v.failures {
+		pterm.Error.Println(failure)
+	}
+	v.failures = nil
+}
+
 // StartStage implements RunnerView
 func (v *CiView) StartStage(stageName string, chunkCount int, verbose bool) {
 	if verbose {
@@ -55,6 +80,29 @@ func (v *CiView) QuitParallelMode() error {
 	return nil
 }
 
+// DescribeCommand implements RunnerView
+func (v *CiView) DescribeCommand(id string, details CommandDetails) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.details[id] = details
+}
+
+// CommandOutput implements RunnerView, printing the line prefixed by the name
+// of its chunk as the commands of a parallel stage write at the same time
+func (v *CiView) CommandOutput(id string, stderr bool, line string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	// the result of the file is printed on its own line after the output
+	if !v.hasPrintedOutput && !v.hasPrintedResult {
+		fmt.Println()
+	}
+	v.hasPrintedOutput = true
+	fmt.Println(v.details[id].prefix() + line)
+}
+
+// CommandExited implements RunnerView
+func (v *CiView) CommandExited(id string, exitCode int) {}
+
 // StartCommand implements RunnerView
 func (v *CiView) StartCommand(id, text string) error {
 	return nil
@@ -78,12 +126,11 @@ func (v *CiView) SkipCommand(id, text string) error {
 
 // StopCommand implements RunnerView
 func (v *CiView) StopCommand(id string, success bool, message string) error {
+	v.mutex.Lock()
+	delete(v.details, id)
+	v.mutex.Unlock()
 	if !success {
-		if !v.hasPrintedResult {
-			fmt.Println("❌")
-			v.hasPrintedResult = true
-		}
-		pterm.Error.Println(id, message)
+		v.failures = append(v.failures, id+" "+message)
 	}
 	return nil
 }
@@ -99,10 +146,7 @@ func (v *CiView) Info(message string) {
 
 // Error implements RunnerView
 func (v *CiView) Error(message string) {
-	if !v.hasPrintedResult {
-		fmt.Println("❌")
-		v.hasPrintedResult = true
-	}
+	v.printFailures()
 	pterm.Error.Println(message)
 }
 
@@ -6,21 +6,41 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/pterm/pterm"
 )
 
+// tailLines is the number of lines of the output of a streamed command shown
+// under its spinner
+const tailLines = 5
+
 // default view for the user that supports interactive prompting and has nice UI features such as spinners
 type ptermView struct {
 	multiPrinter *pterm.MultiPrinter
 	spinners     map[string]*pterm.SpinnerPrinter
 	isParallel   bool
+	// the output of the commands is given from the goroutines copying it
+	mutex   sync.Mutex
+	streams map[string]*streamedCommand
+}
+
+// streamedCommand is the state of a command whose output is shown under its
+// spinner while it's running
+type streamedCommand struct {
+	text string
+	tail []string
+	// printer renders the spinner over several lines outside of the parallel
+	// mode, nil otherwise
+	printer *pterm.MultiPrinter
 }
 
 func newDefaultView() RunnerView {
 	return &ptermView{
 		spinners:   make(map[string]*pterm.SpinnerPrinter),
 		isParallel: false,
+		streams:    make(map[string]*streamedCommand),
 	}
 }
 
@@ -56,21 +76,100 @@ func (v *ptermView) InteractivePromptForCommand(prompt string, commandName strin
 	return result, nil
 }
 
+func (v *ptermView) DescribeCommand(id string, details CommandDetails) {
+	if details.Streamed {
+		v.mutex.Lock()
+		defer v.mutex.Unlock()
+		v.streams[id] = &streamedCommand{}
+	}
+}
+
+func (v *ptermView) CommandExited(id string, exitCode int) {}
+
+// CommandOutput shows the last lines of the output of the command under its
+// spinner
+func (v *ptermView) CommandOutput(id string, stderr bool, line string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	stream, ok := v.streams[id]
+	sp, err := v.getSpinner(id)
+	if !ok || err != nil {
+		return
+	}
+	// only the last state of a line redrawn with carriage returns is kept,
+	// and the lines mustn't wrap for the spinner to be redrawn in place
+	if index := strings.LastIndex(line, "\r"); index >= 0 {
+		line = line[index+1:]
+	}
+	if pterm.RawOutput {
+		fmt.Println("    " + line)
+		return
+	}
+	if width := pterm.GetTerminalWidth() - 4; width > 0 && len([]rune(line)) > width {
+		line = string([]rune(line)[:width])
+	}
+	stream.tail = append(stream.tail, line)
+	if len(stream.tail) > tailLines {
+		stream.tail = stream.tail[len(stream.tail)-tailLines:]
+	}
+	sp.UpdateText(stream.text + "\n    " + pterm.Gray(strings.Join(stream.tail, "\n    ")))
+}
+
+// endStream removes the output of a streamed command from under its spinner,
+// prior to stopping it
+func (v *ptermView) endStream(id string, sp *pterm.SpinnerPrinter) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if stream, ok := v.streams[id]; ok {
+		sp.UpdateText(stream.text)
+		stream.tail = nil
+	}
+}
+
+// forget drops the spinner of a command once stopped, along with the printer
+// rendering it
+func (v *ptermView) forget(id string) {
+	v.mutex.Lock()
+	delete(v.spinners, id)
+	stream, ok := v.streams[id]
+	delete(v.streams, id)
+	v.mutex.Unlock()
+	if ok && stream.printer != nil {
+		stream.printer.Stop()
+	}
+}
+
 func (v *ptermView) StartCommand(id, text string) error {
 	var sp *pterm.SpinnerPrinter
 	var err error
+	v.mutex.Lock()
+	stream := v.streams[id]
+	v.mutex.Unlock()
+	if stream != nil {
+		stream.text = text
+	}
 	if v.isParallel {
 
 		if v.multiPrinter == nil {
 			return errors.New("Start a parallel parallel requires entering parallel mode first")
 		}
 		sp, err = pterm.DefaultSpinner.WithWriter(v.multiPrinter.NewWriter()).Start(text)
+	} else if stream != nil && !pterm.RawOutput {
+		// the output shown under the spinner takes several lines, which only
+		// a multi printer redraws in place
+		stream.printer = pterm.DefaultMultiPrinter.WithWriter(os.Stdout)
+		sp, err = pterm.DefaultSpinner.WithWriter(stream.printer.NewWriter()).Start(text)
+		if err == nil {
+			_, err = stream.printer.Start()
+		}
 	} else {
 		sp, err = pterm.DefaultSpinner.Start(text)
 	}
 	if err != nil {
 		return err
 	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
 	v.spinners[id] = sp
 	return nil
 }
@@ -93,6 +192,7 @@ func (v *ptermView) StopCommand(id string, success bool, message string) error {
 	if err != nil {
 		return err
 	}
+	v.endStream(id, sp)
 	if success {
 		if message != "" {
 			sp.Success(message)
@@ -102,7 +202,7 @@ func (v *ptermView) StopCommand(id string, success bool, message string) error {
 	} else {
 		sp.Fail(message)
 	}
-	delete(v.spinners, id)
+	v.forget(id)
 	return nil
 }
 
@@ -111,6 +211,7 @@ func (v *ptermView) DryRunCommand(id, text string) error {
 	if err != nil {
 		return err
 	}
+	v.endStream(id, sp)
 	sp.InfoPrinter = &pterm.PrefixPrinter{
 		MessageStyle: &pterm.Style{pterm.FgLightBlue},
 		Prefix: pterm.Prefix{
@@ -119,7 +220,7 @@ func (v *ptermView) DryRunCommand(id, text string) error {
 		},
 	}
 	sp.Info(text)
-	delete(v.spinners, id)
+	v.forget(id)
 	return nil
 }
 
@@ -128,6 +229,7 @@ func (v *ptermView) SkipCommand(id, text string) error {
 	if err != nil {
 		return err
 	}
+	v.endStream(id, sp)
 	sp.InfoPrinter = &pterm.PrefixPrinter{
 		MessageStyle: &pterm.Style{pterm.FgLightBlue},
 		Prefix: pterm.Prefix{
@@ -136,7 +238,7 @@ func (v *ptermView) SkipCommand(id, text string) error {
 		},
 	}
 	sp.Info(text)
-	delete(v.spinners, id)
+	v.forget(id)
 	return nil
 }
 
@@ -149,8 +251,9 @@ func (v *ptermView) KillCommand(id, text string) error {
 	if err != nil {
 		return err
 	}
+	v.endStream(id, sp)
 	sp.Fail(fmt.Sprintf("Killed %s", text))
-	delete(v.spinners, id)
+	v.forget(id)
 	return nil
 }
 
@@ -0,0 +1,156 @@
+// Package view provides a layer of abstraction for all UI operations,
+// decoupling the core logic from the presentation layer (e.g., pterm).
+package view
+
+import "sync"
+
+// replayMutex prevents the grouped views of different files from printing
+// their feedback at the same time
+var replayMutex sync.Mutex
+
+// GroupedView holds the feedback about a file until it's done, to print it at
+// once through the view it wraps. It's used when several files are executed
+// concurrently, for their feedback not to be interleaved.
+// Like the CI view, it ignores the interactive mode completely.
+type GroupedView struct {
+	mutex sync.Mutex
+	inner RunnerView
+	calls []func()
+}
+
+// NewGroupedView returns a view keeping the feedback about a file grouped, for
+// the given kind of view. As spinners can't be grouped, the default view is
+// replaced by the CI one. The json view is returned as is, every event
+// mentioning the file it's about.
+func NewGroupedView(kind string) RunnerView {
+	switch kind {
+	case "json", "mock":
+		return NewView(kind)
+	default:
+		return newGroupedView(newCiView())
+	}
+}
+
+// newGroupedView returns a GroupedView wrapping the given view
+func newGroupedView(inner RunnerView) RunnerView {
+	return &GroupedView{inner: inner}
+}
+
+// hold records a call to the wrapped view, to be replayed once the file is done
+func (v *GroupedView) hold(call func()) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.calls = append(v.calls, call)
+}
+
+// StartFile implements RunnerView
+func (v *GroupedView) StartFile(file string) {
+	v.hold(func() { v.inner.StartFile(file) })
+}
+
+// EndFile implements RunnerView, printing all the feedback about the file
+func (v *GroupedView) EndFile(file string, err error) {
+	v.hold(func() { v.inner.EndFile(file, err) })
+	v.mutex.Lock()
+	calls := v.calls
+	v.calls = nil
+	v.mutex.Unlock()
+	replayMutex.Lock()
+	defer replayMutex.Unlock()
+	for _, call := range calls {
+		call()
+	}
+}
+
+// StartStage implements RunnerView
+func (v *GroupedView) StartStage(stageName string, chunkCount int, verbose bool) {
+	v.hold(func() { v.inner.StartStage(stageName, chunkCount, verbose) })
+}
+
+// DeclareParallelMode implements RunnerView
+func (v *GroupedView) DeclareParallelMode() {
+	v.hold(v.inner.DeclareParallelMode)
+}
+
+// StartParallelMode implements RunnerView
+func (v *GroupedView) StartParallelMode() error {
+	v.hold(func() { v.inner.StartParallelMode() })
+	return nil
+}
+
+// QuitParallelMode implements RunnerView
+func (v *GroupedView) QuitParallelMode() error {
+	v.hold(func() { v.inner.QuitParallelMode() })
+	return nil
+}
+
+// DescribeCommand implements RunnerView
+func (v *GroupedView) DescribeCommand(id string, details CommandDetails) {
+	v.hold(func() { v.inner.DescribeCommand(id, details) })
+}
+
+// StartCommand implements RunnerView
+func (v *GroupedView) StartCommand(id, text string) error {
+	v.hold(func() { v.inner.StartCommand(id, text) })
+	return nil
+}
+
+// CommandOutput implements RunnerView
+func (v *GroupedView) CommandOutput(id string, stderr bool, line string) {
+	v.hold(func() { v.inner.CommandOutput(id, stderr, line) })
+}
+
+// InteractivePromptForCommand implements RunnerView
+func (v *GroupedView) InteractivePromptForCommand(prompt string, commandName string, isInteractive *bool) (string, error) {
+	*isInteractive = false
+	return "y", nil
+}
+
+// DryRunCommand implements RunnerView
+func (v *GroupedView) DryRunCommand(id, text string) error {
+	v.hold(func() { v.inner.DryRunCommand(id, text) })
+	return nil
+}
+
+// SkipCommand implements RunnerView
+func (v *GroupedView) SkipCommand(id, text string) error {
+	v.hold(func() { v.inner.SkipCommand(id, text) })
+	return nil
+}
+
+// CommandExited implements RunnerView
+func (v *GroupedView) CommandExited(id string, exitCode int) {
+	v.hold(func() { v.inner.CommandExited(id, exitCode) })
+}
+
+// StopCommand implements RunnerView
+func (v *GroupedView) StopCommand(id string, success bool, message string) error {
+	v.hold(func() { v.inner.StopCommand(id, success, message) })
+	return nil
+}
+
+// KillCommand implements RunnerView
+func (v *GroupedView) KillCommand(id, text string) error {
+	v.hold(func() { v.inner.KillCommand(id, text) })
+	return nil
+}
+
+// Info implements RunnerView
+func (v *GroupedView) Info(message string) {
+	v.hold(func() { v.inner.Info(message) })
+}
+
+// Error implements RunnerView
+func (v *GroupedView) Error(message string) {
+	v.hold(func() { v.inner.Error(message) })
+}
+
+// Warning implements RunnerView
+func (v *GroupedView) Warning(message string) {
+	v.hold(func() { v.inner.Warning(message) })
+}
+
+// HasLogger implements RunnerView
+func (v *GroupedView) HasLogger(id string) bool {
+	return true
+}
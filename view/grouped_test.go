@@ -0,0 +1,42 @@
+package view
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupedView(t *testing.T) {
+	t.Run("holds the feedback until the end of the file", func(t *testing.T) {
+		inner := newMockView()
+		v := newGroupedView(inner)
+		v.StartFile("test.md")
+		v.StartStage("main", 1, false)
+		v.DescribeCommand("cmd-1", CommandDetails{Stage: "main"})
+		assert.NoError(t, v.StartCommand("cmd-1", "echo hello"))
+		assert.NoError(t, v.StopCommand("cmd-1", false, "failed"))
+		assert.Empty(t, inner.Calls, "Expected nothing to be printed before the end of the file")
+
+		v.EndFile("test.md", errors.New("boom"))
+		assert.Len(t, inner.Calls["StartRun"], 1)
+		assert.Len(t, inner.Calls["StartStage"], 1)
+		assert.Len(t, inner.Calls["StartSpinner"], 1)
+		assert.Equal(t, [][]any{{"cmd-1", false, "failed"}}, inner.Calls["StopSpinner"])
+	})
+
+	t.Run("ignores the interactive mode", func(t *testing.T) {
+		v := newGroupedView(newMockView())
+		isInteractive := true
+		answer, err := v.InteractivePromptForCommand("prompt", "cmd", &isInteractive)
+		assert.NoError(t, err)
+		assert.Equal(t, "y", answer)
+		assert.False(t, isInteractive)
+	})
+
+	t.Run("replaces the default view", func(t *testing.T) {
+		assert.IsType(t, &GroupedView{}, NewGroupedView("default"))
+		assert.IsType(t, &GroupedView{}, NewGroupedView("ci"))
+		assert.IsType(t, &JsonView{}, NewGroupedView("json"))
+	})
+}
@@ -0,0 +1,218 @@
+// Package view provides a layer of abstraction for all UI operations,
+// decoupling the core logic from the presentation layer (e.g., pterm).
+package view
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// jsonEvent is a single line of the json view output
+type jsonEvent struct {
+	Time       string `json:"time"`
+	Event      string `json:"event"`
+	File       string `json:"file,omitempty"`
+	Stage      string `json:"stage,omitempty"`
+	ChunkId    string `json:"chunk_id,omitempty"`
+	ChunkIndex *int   `json:"chunk_index,omitempty"`
+	Label      string `json:"label,omitempty"`
+	Source     string `json:"source,omitempty"`
+	Attempt    int    `json:"attempt,omitempty"`
+	CommandId  string `json:"command_id,omitempty"`
+	Text       string `json:"text,omitempty"`
+	Stream     string `json:"stream,omitempty"`
+	Success    *bool  `json:"success,omitempty"`
+	ExitCode   *int   `json:"exit_code,omitempty"`
+	ChunkCount *int   `json:"chunk_count,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// JsonView emits one JSON object per line for every callback, it's meant to be consumed by other programs
+// it ignores the interactive mode completely like the CI view.
+type JsonView struct {
+	mutex     sync.Mutex
+	encoder   *json.Encoder
+	file      string
+	stage     string
+	details   map[string]CommandDetails
+	exitCodes map[string]int
+}
+
+// newJsonView returns a new JsonView writing its events to the given writer
+func newJsonView(writer io.Writer) RunnerView {
+	return &JsonView{
+		encoder:   json.NewEncoder(writer),
+		details:   make(map[string]CommandDetails),
+		exitCodes: make(map[string]int),
+	}
+}
+
+// emit writes the event, completing it with the time and the current file
+func (v *JsonView) emit(event jsonEvent) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	event.Time = time.Now().Format(time.RFC3339Nano)
+	event.File = v.file
+	if event.Stage == "" {
+		event.Stage = v.stage
+	}
+	v.encoder.Encode(event)
+}
+
+// commandEvent builds an event for the given command with the details of its chunk
+func (v *JsonView) commandEvent(name string, id string, text string) jsonEvent {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	event := jsonEvent{Event: name, CommandId: id, Text: text}
+	if details, ok := v.details[id]; ok {
+		index := details.ChunkIndex
+		event.Stage = details.Stage
+		event.ChunkId = details.ChunkId
+		event.ChunkIndex = &index
+		event.Label = details.Label
+		event.Source = details.Source
+		event.Attempt = details.Attempt
+	}
+	if exitCode, ok := v.exitCodes[id]; ok {
+		event.ExitCode = &exitCode
+	}
+	return event
+}
+
+// forget drops what is known about a command once it's over
+func (v *JsonView) forget(id string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	delete(v.details, id)
+	delete(v.exitCodes, id)
+}
+
+// StartFile implements RunnerView
+func (v *JsonView) StartFile(file string) {
+	v.mutex.Lock()
+	v.file = file
+	v.stage = ""
+	v.mutex.Unlock()
+	v.emit(jsonEvent{Event: "start_file"})
+}
+
+// EndFile implements RunnerView
+func (v *JsonView) EndFile(file string, err error) {
+	success := err == nil
+	event := jsonEvent{Event: "end_file", Success: &success}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	v.emit(event)
+}
+
+// StartStage implements RunnerView
+func (v *JsonView) StartStage(stageName string, chunkCount int, verbose bool) {
+	v.mutex.Lock()
+	v.stage = stageName
+	v.mutex.Unlock()
+	v.emit(jsonEvent{Event: "start_stage", ChunkCount: &chunkCount})
+}
+
+// DeclareParallelMode implements RunnerView
+func (v *JsonView) DeclareParallelMode() {}
+
+// StartParallelMode implements RunnerView
+func (v *JsonView) StartParallelMode() error {
+	return nil
+}
+
+// QuitParallelMode implements RunnerView
+func (v *JsonView) QuitParallelMode() error {
+	return nil
+}
+
+// DescribeCommand implements RunnerView
+func (v *JsonView) DescribeCommand(id string, details CommandDetails) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.details[id] = details
+}
+
+// StartCommand implements RunnerView
+func (v *JsonView) StartCommand(id, text string) error {
+	v.emit(v.commandEvent("start_command", id, text))
+	return nil
+}
+
+// CommandOutput implements RunnerView
+func (v *JsonView) CommandOutput(id string, stderr bool, line string) {
+	event := v.commandEvent("command_output", id, line)
+	event.Stream = "stdout"
+	if stderr {
+		event.Stream = "stderr"
+	}
+	v.emit(event)
+}
+
+// InteractivePromptForCommand implements RunnerView
+func (v *JsonView) InteractivePromptForCommand(prompt string, commandName string, isInteractive *bool) (string, error) {
+	*isInteractive = false
+	return "y", nil
+}
+
+// DryRunCommand implements RunnerView
+func (v *JsonView) DryRunCommand(id, text string) error {
+	v.emit(v.commandEvent("dry_run_command", id, text))
+	v.forget(id)
+	return nil
+}
+
+// SkipCommand implements RunnerView
+func (v *JsonView) SkipCommand(id, text string) error {
+	v.emit(v.commandEvent("skip_command", id, text))
+	v.forget(id)
+	return nil
+}
+
+// CommandExited implements RunnerView
+func (v *JsonView) CommandExited(id string, exitCode int) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.exitCodes[id] = exitCode
+}
+
+// StopCommand implements RunnerView
+func (v *JsonView) StopCommand(id string, success bool, message string) error {
+	event := v.commandEvent("stop_command", id, "")
+	event.Success = &success
+	event.Message = message
+	v.emit(event)
+	v.forget(id)
+	return nil
+}
+
+// KillCommand implements RunnerView
+func (v *JsonView) KillCommand(id, text string) error {
+	v.emit(v.commandEvent("kill_command", id, text))
+	v.forget(id)
+	return nil
+}
+
+// Info implements RunnerView
+func (v *JsonView) Info(message string) {
+	v.emit(jsonEvent{Event: "info", Message: message})
+}
+
+// Error implements RunnerView
+func (v *JsonView) Error(message string) {
+	v.emit(jsonEvent{Event: "error", Message: message})
+}
+
+// Warning implements RunnerView
+func (v *JsonView) Warning(message string) {
+	v.emit(jsonEvent{Event: "warning", Message: message})
+}
+
+// HasLogger implements RunnerView
+func (v *JsonView) HasLogger(id string) bool {
+	return true
+}
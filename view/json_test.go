@@ -0,0 +1,86 @@
+package view
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJsonView(t *testing.T) {
+	t.Run("emits one event per line", func(t *testing.T) {
+		var output bytes.Buffer
+		v := newJsonView(&output)
+		v.StartFile("test.md")
+		v.StartStage("main", 1, false)
+		v.DescribeCommand("cmd-1", CommandDetails{Stage: "main", ChunkId: "hello", ChunkIndex: 0, Label: "say hello", Source: "test.md:3"})
+		assert.NoError(t, v.StartCommand("cmd-1", "echo hello"))
+		v.CommandExited("cmd-1", 2)
+		assert.NoError(t, v.StopCommand("cmd-1", false, "failed"))
+		v.Warning("careful")
+		v.EndFile("test.md", errors.New("boom"))
+
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		assert.Len(t, lines, 6)
+		var events []map[string]any
+		for _, line := range lines {
+			var event map[string]any
+			assert.NoError(t, json.Unmarshal([]byte(line), &event), "Expected every line to be valid JSON")
+			assert.NotEmpty(t, event["time"])
+			assert.Equal(t, "test.md", event["file"])
+			events = append(events, event)
+		}
+		assert.Equal(t, "start_file", events[0]["event"])
+		assert.Equal(t, "start_stage", events[1]["event"])
+		assert.Equal(t, float64(1), events[1]["chunk_count"])
+
+		assert.Equal(t, "start_command", events[2]["event"])
+		assert.Equal(t, "main", events[2]["stage"])
+		assert.Equal(t, "hello", events[2]["chunk_id"])
+		assert.Equal(t, float64(0), events[2]["chunk_index"])
+		assert.Equal(t, "say hello", events[2]["label"])
+		assert.Equal(t, "test.md:3", events[2]["source"])
+		assert.Equal(t, "echo hello", events[2]["text"])
+
+		assert.Equal(t, "stop_command", events[3]["event"])
+		assert.Equal(t, false, events[3]["success"])
+		assert.Equal(t, float64(2), events[3]["exit_code"])
+		assert.Equal(t, "failed", events[3]["message"])
+
+		assert.Equal(t, "warning", events[4]["event"])
+		assert.Equal(t, "end_file", events[5]["event"])
+		assert.Equal(t, "boom", events[5]["error"])
+	})
+	t.Run("emits the output of the commands", func(t *testing.T) {
+		var output bytes.Buffer
+		v := newJsonView(&output)
+		v.DescribeCommand("cmd-1", CommandDetails{Stage: "main", Label: "say hello", Streamed: true})
+		v.CommandOutput("cmd-1", false, "hello")
+		v.CommandOutput("cmd-1", true, "careful")
+
+		var events []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+			var event map[string]any
+			assert.NoError(t, json.Unmarshal([]byte(line), &event))
+			events = append(events, event)
+		}
+		assert.Len(t, events, 2)
+		assert.Equal(t, "command_output", events[0]["event"])
+		assert.Equal(t, "say hello", events[0]["label"])
+		assert.Equal(t, "stdout", events[0]["stream"])
+		assert.Equal(t, "hello", events[0]["text"])
+		assert.Equal(t, "stderr", events[1]["stream"])
+		assert.Equal(t, "careful", events[1]["text"])
+	})
+	t.Run("is not interactive", func(t *testing.T) {
+		v := newJsonView(&bytes.Buffer{})
+		isInteractive := true
+		result, err := v.InteractivePromptForCommand("prompt", "cmd", &isInteractive)
+		assert.NoError(t, err)
+		assert.Equal(t, "y", result)
+		assert.False(t, isInteractive)
+	})
+}
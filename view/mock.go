@@ -2,8 +2,12 @@
 // decoupling the core logic from the presentation layer (e.g., pterm).
 package view
 
+import "sync"
+
 // MockRunnerView provides a mock implementation of the UI interface for testing.
 type MockRunnerView struct {
+	// the output of the commands is given from the goroutines copying it
+	mutex    sync.Mutex
 	Calls    map[string][][]any
 	Spinners map[string]string
 }
@@ -17,6 +21,8 @@ func newMockView() *MockRunnerView {
 }
 
 func (m *MockRunnerView) logCall(name string, args ...any) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	m.Calls[name] = append(m.Calls[name], args)
 }
 
@@ -24,7 +30,8 @@ func (m *MockRunnerView) StartFile(file string) {
 	m.logCall("StartRun", file)
 }
 
-func (v *MockRunnerView) EndFile(file string, err error) {
+func (m *MockRunnerView) EndFile(file string, err error) {
+	m.logCall("EndFile", file, err)
 }
 
 func (m *MockRunnerView) StartStage(stageName string, chunkCount int, verbose bool) {
@@ -51,12 +58,24 @@ func (m *MockRunnerView) InteractivePromptForCommand(prompt string, commandName
 	return "", nil
 }
 
+func (m *MockRunnerView) DescribeCommand(id string, details CommandDetails) {
+	m.logCall("DescribeCommand", id, details)
+}
+
+func (m *MockRunnerView) CommandExited(id string, exitCode int) {
+	m.logCall("CommandExited", id, exitCode)
+}
+
 func (m *MockRunnerView) StartCommand(id, text string) error {
 	m.logCall("StartSpinner", id, text)
 	m.Spinners[id] = "ok"
 	return nil
 }
 
+func (m *MockRunnerView) CommandOutput(id string, stderr bool, line string) {
+	m.logCall("CommandOutput", id, stderr, line)
+}
+
 func (m *MockRunnerView) StopCommand(id string, success bool, message string) error {
 	m.logCall("StopSpinner", id, success, message)
 	delete(m.Spinners, id)
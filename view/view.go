@@ -2,6 +2,11 @@
 // decoupling the core logic from the presentation layer (e.g., pterm).
 package view
 
+import (
+	"fmt"
+	"os"
+)
+
 // The RunnerView takes care of visual feedback to the user about what's happening to the file being executed
 // This interface allows for several implementation, such as one for the tests.
 type RunnerView interface {
@@ -16,14 +21,20 @@ type RunnerView interface {
 	StartParallelMode() error
 	// Quit the parallel mode to resume sequential operation
 	QuitParallelMode() error
+	// Attaches the details of the chunk a command belongs to, prior to starting it
+	DescribeCommand(id string, details CommandDetails)
 	// Gives feedback that a command has started
 	StartCommand(id, text string) error
+	// Gives a line the command wrote to its stdout, or its stderr, as it's written
+	CommandOutput(id string, stderr bool, line string)
 	// Prompts the user for what to do for a given command
 	InteractivePromptForCommand(prompt string, commandName string, isInteractive *bool) (string, error)
 	// Gives feedback that the command is in dry-run mode
 	DryRunCommand(id, text string) error
 	// Gives feedback that the command was skipped
 	SkipCommand(id, text string) error
+	// Gives the exit code of a command that ran, prior to stopping it
+	CommandExited(id string, exitCode int)
 	// Gives feedback that the command was done
 	StopCommand(id string, success bool, message string) error
 	// Gives feedback that the command was killed
@@ -38,13 +49,43 @@ type RunnerView interface {
 	HasLogger(id string) bool
 }
 
-// NewView returns the interface chosen by the user. The kind can be "mock" "ci" of "default"
+// CommandDetails describes the chunk a command belongs to
+type CommandDetails struct {
+	Stage      string
+	ChunkId    string
+	ChunkIndex int
+	Label      string
+	// Source locates the chunk in its markdown file as file:line
+	Source string
+	// Attempt starts at 1 and grows every time a failing chunk is retried
+	Attempt int
+	// Streamed is set when the output of the command is given line by line
+	// with CommandOutput while it's running
+	Streamed bool
+}
+
+// prefix names the command in front of the lines of its output, by the label
+// of its chunk, its id, or its position within its stage
+func (details CommandDetails) prefix() string {
+	switch {
+	case details.Label != "":
+		return "[" + details.Label + "] "
+	case details.ChunkId != "":
+		return "[" + details.ChunkId + "] "
+	default:
+		return fmt.Sprintf("[%s/%d] ", details.Stage, details.ChunkIndex)
+	}
+}
+
+// NewView returns the interface chosen by the user. The kind can be "mock" "ci" "json" of "default"
 func NewView(kind string) RunnerView {
 	switch kind {
 	case "mock":
 		return newMockView()
 	case "ci":
 		return newCiView()
+	case "json":
+		return newJsonView(os.Stdout)
 	default:
 		return newDefaultView()
 	}